@@ -106,7 +106,9 @@ type Chart struct {
 
 // Profile defines properties for accessing a profile
 type Profile struct {
-	// Source defines properties of the source of the profile
+	// Source defines properties of the source of the profile. Without a URL, the profile is
+	// read from the repository and ref of the including profile. With a URL but neither a
+	// branch nor a tag, the profile is read from the main branch of that repository.
 	Source *Source `json:"source,omitempty"`
 }
 
@@ -17,12 +17,48 @@ limitations under the License.
 package v1alpha1
 
 import (
-	"strings"
-
 	"github.com/fluxcd/pkg/apis/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+const (
+	// ConditionTypeScanning is the condition reporting whether repositories are
+	// being scanned.
+	ConditionTypeScanning = "Scanning"
+	// ConditionTypeFailed is the condition reporting whether the last scan failed.
+	ConditionTypeFailed = "Failed"
+	// ConditionTypePersisted is the condition reporting whether the discovered
+	// profiles are persisted to be restored on restart.
+	ConditionTypePersisted = "Persisted"
+)
+
+const (
+	// ReasonScanInProgress is used while repositories are being scanned.
+	ReasonScanInProgress = "ScanInProgress"
+	// ReasonScanSucceeded is used when repositories have been scanned.
+	ReasonScanSucceeded = "ScanSucceeded"
+	// ReasonScanFailed is used when a repository could not be scanned.
+	ReasonScanFailed = "ScanFailed"
+	// ReasonTagsFailed is used when some tags of a repository could not be scanned.
+	ReasonTagsFailed = "TagsFailed"
+	// ReasonInvalidTags is used when the profile definitions of some tags of a repository
+	// are invalid.
+	ReasonInvalidTags = "InvalidTags"
+	// ReasonRepositoryRemoved is used when a repository removed from the spec is removed
+	// from the catalog.
+	ReasonRepositoryRemoved = "RepositoryRemoved"
+	// ReasonProfileConflict is used when repositories publish a profile with the same name
+	// and version.
+	ReasonProfileConflict = "ProfileConflict"
+	// ReasonStaticCatalog is used for catalog sources listing their profiles in the spec.
+	ReasonStaticCatalog = "StaticCatalog"
+	// ReasonCatalogPersisted is used when the discovered profiles are persisted.
+	ReasonCatalogPersisted = "CatalogPersisted"
+	// ReasonCatalogTooLarge is used when the discovered profiles are too large to be
+	// persisted in a ConfigMap.
+	ReasonCatalogTooLarge = "CatalogTooLarge"
+)
+
 // ProfileCatalogSourceSpec defines the desired state of ProfileCatalogSource
 type ProfileCatalogSourceSpec struct {
 	// Profiles is the list of profiles exposed by the catalog
@@ -31,6 +67,10 @@ type ProfileCatalogSourceSpec struct {
 	// Repos contains a list of repositories to scan for profiles
 	// +optional
 	Repos []Repository `json:"repositories,omitempty"`
+	// Interval is the interval at which the repositories are rescanned for new
+	// profiles (default: 10m)
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
 }
 
 // Repository defines the list of repositories to scan for profiles
@@ -47,6 +87,46 @@ type Repository struct {
 	// 'known_hosts' fields.
 	// +optional
 	SecretRef *meta.LocalObjectReference `json:"secretRef,omitempty"`
+	// Interval overrides the interval at which this repository is rescanned
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+	// TagPattern is the pattern the tags of profiles are matched with. It is either
+	// a regular expression or a template such as '{path}-v{version}', where the
+	// 'version' capture is the version of the profile and the optional 'path'
+	// capture is the directory of the profile (default: [<path>/]<version>)
+	// +optional
+	TagPattern string `json:"tagPattern,omitempty"`
+	// ProfileFilename is the name of the file containing the profile definition
+	// (default: profile.yaml)
+	// +optional
+	ProfileFilename string `json:"profileFilename,omitempty"`
+	// IncludeTags is a list of globs, such as 'nginx/v1.*', the tags to scan must
+	// match one of
+	// +optional
+	IncludeTags []string `json:"includeTags,omitempty"`
+	// ExcludeTags is a list of globs matching tags which are not scanned
+	// +optional
+	ExcludeTags []string `json:"excludeTags,omitempty"`
+	// SemverConstraint is a constraint the versions of the tags to scan must
+	// satisfy, such as '>=1.0.0 <3.0.0'. Pre-releases only satisfy constraints
+	// which include a pre-release
+	// +optional
+	SemverConstraint string `json:"semverConstraint,omitempty"`
+	// Branches is the list of branches tracked for pre-release versions of a
+	// profile. The head of a branch is listed as 0.0.0-<branch>.<sha>, which is
+	// replaced when the head moves
+	// +optional
+	Branches []TrackedBranch `json:"branches,omitempty"`
+}
+
+// TrackedBranch is a branch of a repository containing a pre-release version of a profile
+type TrackedBranch struct {
+	// Name is the name of the branch
+	Name string `json:"name"`
+	// Path is the directory of the profile in the branch (default: the root of
+	// the repository)
+	// +optional
+	Path string `json:"path,omitempty"`
 }
 
 // ProfileCatalogEntry defines details about a given profile.
@@ -65,11 +145,41 @@ type ProfileCatalogEntry struct {
 	// Profile name
 	Name               string `json:"name,omitempty"`
 	ProfileDescription `json:",inline"`
+	// TagPattern is the pattern of the repository the tag was matched with
+	// +optional
+	TagPattern string `json:"tagPattern,omitempty"`
+	// Branch is the tracked branch a pre-release version of the profile was read
+	// from, in which case the tag is its version and not a tag of the repository
+	// +optional
+	Branch string `json:"branch,omitempty"`
+	// Commit is the commit of the tracked branch the pre-release version of the
+	// profile was read from
+	// +optional
+	Commit string `json:"commit,omitempty"`
+	// ProfileFilename is the name of the file containing the profile definition
+	// (default: profile.yaml)
+	// +optional
+	ProfileFilename string `json:"profileFilename,omitempty"`
+	// ArtifactPaths is the list of local artifact paths of the profile which exist in the repository
+	// +optional
+	ArtifactPaths []string `json:"artifactPaths,omitempty"`
 }
 
 // ProfileCatalogSourceStatus defines the observed state of ProfileCatalogSource
 type ProfileCatalogSourceStatus struct {
-	ScannedRepositories []ScannedRepository `json:"scannedRepositories,omitempty"`
+	// Conditions holds the conditions for the ProfileCatalogSource
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// ObservedGeneration is the last generation of the spec which has been scanned
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// LastScanTime is the last time a repository of the catalog source was scanned
+	// +optional
+	LastScanTime *metav1.Time `json:"lastScanTime,omitempty"`
+	// LastHandledReconcileAt is the value of the last handled rescan request annotation
+	// +optional
+	LastHandledReconcileAt string              `json:"lastHandledReconcileAt,omitempty"`
+	ScannedRepositories    []ScannedRepository `json:"scannedRepositories,omitempty"`
 }
 
 // ScannedRepository contains the list of repositories that have been scanned and
@@ -77,12 +187,58 @@ type ProfileCatalogSourceStatus struct {
 type ScannedRepository struct {
 	// URL is the repository URL
 	URL string `json:"url,omitempty"`
-	// Tags is the list of tags that have been scanned
+	// Tags is the list of tags that have been scanned, including the versions of
+	// the tracked branches
 	Tags []string `json:"tags,omitempty"`
+	// Commits maps the scanned tags to the hash they referred to when they were
+	// scanned. Tags which are moved are scanned again
+	// +optional
+	Commits map[string]string `json:"commits,omitempty"`
+	// LastScanTime is the last time the repository was scanned
+	// +optional
+	LastScanTime *metav1.Time `json:"lastScanTime,omitempty"`
+	// FailedTags is the list of tags that could not be scanned and are retried
+	// +optional
+	FailedTags []FailedTag `json:"failedTags,omitempty"`
+	// InvalidTags is the list of scanned tags whose profile definition is invalid. They
+	// are not retried and only scanned again when they are moved
+	// +optional
+	InvalidTags []InvalidTag `json:"invalidTags,omitempty"`
+	// Conditions holds the conditions for the repository
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// FailedTag is a tag of a repository that could not be scanned
+type FailedTag struct {
+	// Tag is the name of the tag
+	Tag string `json:"tag"`
+	// Message is the reason the tag could not be scanned
+	// +optional
+	Message string `json:"message,omitempty"`
+	// Attempts is the number of times scanning the tag has failed
+	Attempts int `json:"attempts"`
+	// LastAttemptTime is the last time the tag was scanned
+	LastAttemptTime metav1.Time `json:"lastAttemptTime"`
+	// NextAttemptTime is the earliest time the tag is scanned again
+	NextAttemptTime metav1.Time `json:"nextAttemptTime"`
+}
+
+// InvalidTag is a tag of a repository whose profile definition is invalid
+type InvalidTag struct {
+	// Tag is the name of the tag
+	Tag string `json:"tag"`
+	// Message is the reason the profile definition is invalid
+	// +optional
+	Message string `json:"message,omitempty"`
 }
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status",description=""
+// +kubebuilder:printcolumn:name="Status",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].message",description=""
+// +kubebuilder:printcolumn:name="Last Scan",type="date",JSONPath=".status.lastScanTime",description=""
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp",description=""
 
 // ProfileCatalogSource is the Schema for the ProfileCatalogSources API
 type ProfileCatalogSource struct {
@@ -105,11 +261,3 @@ type ProfileCatalogSourceList struct {
 func init() {
 	SchemeBuilder.Register(&ProfileCatalogSource{}, &ProfileCatalogSourceList{})
 }
-
-func GetVersionFromTag(tag string) string {
-	splitTag := strings.Split(tag, "/")
-	if len(splitTag) == 2 {
-		return splitTag[1]
-	}
-	return tag
-}
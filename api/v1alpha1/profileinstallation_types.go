@@ -25,12 +25,42 @@ import (
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 // NOTE: Run "make" to regenerate code after modifying this file
 
+// ConditionTypeReady is the condition reporting whether the artifacts of a
+// ProfileInstallation have been deployed.
+const ConditionTypeReady = "Ready"
+
+// ConditionTypeValuesReady is the condition reporting whether the helm values
+// of a ProfileInstallation could be merged.
+const ConditionTypeValuesReady = "ValuesReady"
+
+const (
+	// ReasonArtifactsCreated is used when all artifacts have been created.
+	ReasonArtifactsCreated = "ArtifactsCreated"
+	// ReasonSourceNotReady is used while the source of the profile has not
+	// produced an artifact yet.
+	ReasonSourceNotReady = "SourceNotReady"
+	// ReasonFailed is used when the installation could not be reconciled.
+	ReasonFailed = "Failed"
+	// ReasonValuesMerged is used when the helm values have been merged.
+	ReasonValuesMerged = "ValuesMerged"
+	// ReasonValuesInvalid is used when the helm values could not be read or merged.
+	ReasonValuesInvalid = "ValuesInvalid"
+)
+
 // ProfileInstallationSpec defines the desired state of a ProfileInstallation
 type ProfileInstallationSpec struct {
-	// ConfigMap is the name of the configmap to pull helm values from
+	// ConfigMap is the name of the configmap to pull helm values from.
+	// Values are read from the key named after the artifact and merged over
+	// the default values of the artifact
 	// +optional
 	ConfigMap string `json:"configMap,omitempty"`
 
+	// Secret is the name of the secret to pull sensitive helm values from.
+	// Values are read from the key named after the artifact and take precedence
+	// over the values of the configmap
+	// +optional
+	Secret string `json:"secret,omitempty"`
+
 	// GitRepository is the git repository flux resource the installation uses
 	GitRepository *GitRepository `json:"gitRepository,omitempty"`
 	// Source defines properties of the source of the profile
@@ -67,6 +97,16 @@ type Source struct {
 	// Tag is the git tag containing the profile definition
 	// +optional
 	Tag string `json:"tag,omitempty"`
+
+	// Commit is the git commit of the branch containing the profile definition,
+	// which is checked out instead of the head of the branch
+	// +optional
+	Commit string `json:"commit,omitempty"`
+
+	// ProfileFilename is the name of the file containing the profile definition
+	// (default: profile.yaml)
+	// +optional
+	ProfileFilename string `json:"profileFilename,omitempty"`
 }
 
 // Catalog defines properties of the catalog this profile is from
@@ -91,12 +131,56 @@ type ProfileInstallationStatus struct {
 	// Conditions holds the conditions for the ProfileInstallation
 	// +optional
 	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// Source is the resolved location of the installed profile
+	// +optional
+	Source *ResolvedSource `json:"source,omitempty"`
+	// NestedProfiles reports the profiles included by the installed profile
+	// +optional
+	NestedProfiles []NestedProfileStatus `json:"nestedProfiles,omitempty"`
+}
+
+// NestedProfileStatus reports the state of a profile included by another profile
+type NestedProfileStatus struct {
+	// Name is the name of the artifact including the profile, prefixed with
+	// the names of the artifacts including its parent profiles
+	Name string `json:"name"`
+	// Depth is the nesting level of the profile, starting at 1 for profiles
+	// included by the installed profile
+	Depth int `json:"depth"`
+	// Source is the resolved location of the profile
+	// +optional
+	Source *ResolvedSource `json:"source,omitempty"`
+	// Ready is true once the profile and all profiles it includes have been expanded
+	Ready bool `json:"ready"`
+	// Message describes why the profile could not be expanded
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// ResolvedSource records exactly which revision of a profile has been deployed
+type ResolvedSource struct {
+	// URL is the URL of the profile repo
+	URL string `json:"url,omitempty"`
+	// Branch is the git repo branch containing the profile definition
+	// +optional
+	Branch string `json:"branch,omitempty"`
+	// Tag is the git tag containing the profile definition
+	// +optional
+	Tag string `json:"tag,omitempty"`
+	// Path is the location in the git repo containing the profile definition
+	// +optional
+	Path string `json:"path,omitempty"`
+	// Commit is the git commit the profile definition was read from
+	// +optional
+	Commit string `json:"commit,omitempty"`
 }
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
 // +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status",description=""
 // +kubebuilder:printcolumn:name="Status",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].message",description=""
+// +kubebuilder:printcolumn:name="Tag",type="string",JSONPath=".status.source.tag",description="",priority=1
+// +kubebuilder:printcolumn:name="Commit",type="string",JSONPath=".status.source.commit",description="",priority=1
 // +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp",description=""
 
 // ProfileInstallation is the Schema for the profileinstallations API
@@ -0,0 +1,75 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"strings"
+
+	"github.com/fluxcd/pkg/apis/meta"
+)
+
+// RescanRepositoriesAnnotation limits a rescan request to some of the repositories of a
+// ProfileCatalogSource. Its value is in the format '<requested at> <url>...', where
+// <requested at> is the value of the rescan request annotation the repositories were
+// requested with. A rescan request without a matching annotation rescans all repositories.
+const RescanRepositoriesAnnotation = "weave.works/rescan-repositories"
+
+// RequestedRepositories returns the repositories the current rescan request of the
+// ProfileCatalogSource is limited to, or nil if all repositories are requested.
+func RequestedRepositories(source *ProfileCatalogSource) []string {
+	requestedAt := source.Annotations[meta.ReconcileRequestAnnotation]
+	fields := strings.Fields(source.Annotations[RescanRepositoriesAnnotation])
+	if requestedAt == "" || len(fields) < 2 || fields[0] != requestedAt {
+		return nil
+	}
+	return fields[1:]
+}
+
+// RequestRescan annotates the ProfileCatalogSource with a rescan request for the
+// repositories, which are added to the repositories of a pending request. All repositories
+// are rescanned when repos is empty.
+func RequestRescan(source *ProfileCatalogSource, requestedAt string, repos ...string) {
+	pending := source.Annotations[meta.ReconcileRequestAnnotation] != source.Status.LastHandledReconcileAt
+	requested := RequestedRepositories(source)
+	if source.Annotations == nil {
+		source.Annotations = make(map[string]string)
+	}
+	source.Annotations[meta.ReconcileRequestAnnotation] = requestedAt
+	// a pending request for all repositories stays a request for all repositories
+	if len(repos) == 0 || (pending && requested == nil) {
+		delete(source.Annotations, RescanRepositoriesAnnotation)
+		return
+	}
+	if pending {
+		for _, repo := range repos {
+			if !containsString(requested, repo) {
+				requested = append(requested, repo)
+			}
+		}
+		repos = requested
+	}
+	source.Annotations[RescanRepositoriesAnnotation] = requestedAt + " " + strings.Join(repos, " ")
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	// DefaultProfileFilename is the name of the file containing the ProfileDefinition
+	// of a profile.
+	DefaultProfileFilename = "profile.yaml"
+	// DefaultTagPattern matches tags in the format [<path>/]<version>.
+	DefaultTagPattern = `^(?:(?P<path>[^/]+)/)?(?P<version>[^/]+)$`
+	// BranchTagPattern matches the tags of the profiles read from tracked branches,
+	// which are in the format [<path>/]<version> with a path of any depth.
+	BranchTagPattern = `^(?:(?P<path>.+)/)?(?P<version>[^/]+)$`
+)
+
+const (
+	tagPatternPath    = "path"
+	tagPatternVersion = "version"
+)
+
+var tagPatterns sync.Map
+
+// CompileTagPattern compiles the tag pattern of a repository. The pattern is either
+// a regular expression or a template such as '{path}-v{version}'. The 'version'
+// capture is required and holds the version of the profile, the optional 'path'
+// capture holds the directory of the profile within the repository. An empty
+// pattern compiles DefaultTagPattern.
+func CompileTagPattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		pattern = DefaultTagPattern
+	}
+	if re, ok := tagPatterns.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+
+	expr := pattern
+	if isTagTemplate(pattern) {
+		expr = templateToRegexp(pattern)
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tag pattern %q: %w", pattern, err)
+	}
+	if re.SubexpIndex(tagPatternVersion) < 0 {
+		return nil, fmt.Errorf("invalid tag pattern %q: missing %q capture", pattern, tagPatternVersion)
+	}
+	tagPatterns.Store(pattern, re)
+	return re, nil
+}
+
+func isTagTemplate(pattern string) bool {
+	return strings.Contains(pattern, "{"+tagPatternVersion+"}")
+}
+
+// templateToRegexp turns the placeholders of a template into named captures and
+// quotes everything else.
+func templateToRegexp(template string) string {
+	captures := map[string]string{
+		"{" + tagPatternPath + "}":    fmt.Sprintf("(?P<%s>.+)", tagPatternPath),
+		"{" + tagPatternVersion + "}": fmt.Sprintf("(?P<%s>[^/]+)", tagPatternVersion),
+	}
+	placeholders := regexp.MustCompile(`\{(` + tagPatternPath + `|` + tagPatternVersion + `)\}`)
+
+	var expr strings.Builder
+	expr.WriteString("^")
+	last := 0
+	for _, loc := range placeholders.FindAllStringIndex(template, -1) {
+		expr.WriteString(regexp.QuoteMeta(template[last:loc[0]]))
+		expr.WriteString(captures[template[loc[0]:loc[1]]])
+		last = loc[1]
+	}
+	expr.WriteString(regexp.QuoteMeta(template[last:]))
+	expr.WriteString("$")
+	return expr.String()
+}
+
+// ParseTag returns the version and the directory of the profile a tag was created
+// for, and whether the tag matches the pattern. With the default pattern tags which
+// do not match refer to the profile at the root of the repository, with the tag as
+// the version.
+func ParseTag(tag, pattern string) (string, string, bool) {
+	re, err := CompileTagPattern(pattern)
+	if err != nil {
+		return "", "", false
+	}
+	match := re.FindStringSubmatch(tag)
+	if match == nil {
+		if pattern == "" {
+			return tag, "", true
+		}
+		return "", "", false
+	}
+
+	var dir string
+	if i := re.SubexpIndex(tagPatternPath); i >= 0 {
+		dir = match[i]
+	}
+	return match[re.SubexpIndex(tagPatternVersion)], dir, true
+}
+
+// GetVersionFromTag returns the version of the profile a tag was created for. Tags
+// which do not match the pattern are returned as is.
+func GetVersionFromTag(tag, pattern string) string {
+	if version, _, ok := ParseTag(tag, pattern); ok {
+		return version
+	}
+	return tag
+}
+
+// GetPathFromTag returns the directory of the profile a tag was created for. With
+// the default pattern tags in the format <dir>/<semver> refer to <dir>/profile.yaml,
+// any other tag refers to the profile.yaml at the root of the repository.
+func GetPathFromTag(tag, pattern string) string {
+	_, dir, _ := ParseTag(tag, pattern)
+	return dir
+}
+
+var invalidPrereleaseChars = regexp.MustCompile(`[^0-9a-z-]+`)
+
+// maxBranchIdentifierLength is the length of the longest branch identifier in a version. The
+// versions of branches are part of the names of the GitRepositories created to scan them.
+const maxBranchIdentifierLength = 32
+
+// BranchVersion returns the pre-release version of the profile at a commit of a
+// tracked branch, in the format 0.0.0-<branch>.<sha>. A sha of digits only is prefixed
+// with a g, as a numeric identifier must not have leading zeros.
+func BranchVersion(branch, commit string) string {
+	if len(commit) > 7 {
+		commit = commit[:7]
+	}
+	if isNumeric(commit) {
+		commit = "g" + commit
+	}
+	return fmt.Sprintf("0.0.0-%s.%s", branchIdentifier(branch), commit)
+}
+
+// branchIdentifier returns the pre-release identifier of a branch: the branch name in lower
+// case with its invalid characters replaced. An identifier longer than
+// maxBranchIdentifierLength is shortened and suffixed with a hash of the branch name.
+func branchIdentifier(branch string) string {
+	id := strings.Trim(invalidPrereleaseChars.ReplaceAllString(strings.ToLower(branch), "-"), "-")
+	// numeric identifiers must not have leading zeros, and an identifier must not be empty
+	if id == "" || isNumeric(id) {
+		id = strings.TrimSuffix("branch-"+id, "-")
+	}
+	if len(id) > maxBranchIdentifierLength {
+		sum := sha256.Sum256([]byte(branch))
+		id = strings.TrimRight(id[:maxBranchIdentifierLength-9], "-") + "-" + hex.EncodeToString(sum[:])[:8]
+	}
+	return id
+}
+
+func isNumeric(s string) bool {
+	return s != "" && strings.Trim(s, "0123456789") == ""
+}
+
+// BranchTag returns the tag of the profile in dir at a commit of a tracked branch.
+// It matches BranchTagPattern.
+func BranchTag(dir, branch, commit string) string {
+	return path.Join(dir, BranchVersion(branch, commit))
+}
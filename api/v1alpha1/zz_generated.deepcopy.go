@@ -106,6 +106,23 @@ func (in *DependsOn) DeepCopy() *DependsOn {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FailedTag) DeepCopyInto(out *FailedTag) {
+	*out = *in
+	in.LastAttemptTime.DeepCopyInto(&out.LastAttemptTime)
+	in.NextAttemptTime.DeepCopyInto(&out.NextAttemptTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FailedTag.
+func (in *FailedTag) DeepCopy() *FailedTag {
+	if in == nil {
+		return nil
+	}
+	out := new(FailedTag)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *GitRepository) DeepCopyInto(out *GitRepository) {
 	*out = *in
@@ -121,6 +138,21 @@ func (in *GitRepository) DeepCopy() *GitRepository {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *InvalidTag) DeepCopyInto(out *InvalidTag) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InvalidTag.
+func (in *InvalidTag) DeepCopy() *InvalidTag {
+	if in == nil {
+		return nil
+	}
+	out := new(InvalidTag)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Kustomize) DeepCopyInto(out *Kustomize) {
 	*out = *in
@@ -136,6 +168,26 @@ func (in *Kustomize) DeepCopy() *Kustomize {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NestedProfileStatus) DeepCopyInto(out *NestedProfileStatus) {
+	*out = *in
+	if in.Source != nil {
+		in, out := &in.Source, &out.Source
+		*out = new(ResolvedSource)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NestedProfileStatus.
+func (in *NestedProfileStatus) DeepCopy() *NestedProfileStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NestedProfileStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Profile) DeepCopyInto(out *Profile) {
 	*out = *in
@@ -160,6 +212,11 @@ func (in *Profile) DeepCopy() *Profile {
 func (in *ProfileCatalogEntry) DeepCopyInto(out *ProfileCatalogEntry) {
 	*out = *in
 	in.ProfileDescription.DeepCopyInto(&out.ProfileDescription)
+	if in.ArtifactPaths != nil {
+		in, out := &in.ArtifactPaths, &out.ArtifactPaths
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ProfileCatalogEntry.
@@ -248,6 +305,11 @@ func (in *ProfileCatalogSourceSpec) DeepCopyInto(out *ProfileCatalogSourceSpec)
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.Interval != nil {
+		in, out := &in.Interval, &out.Interval
+		*out = new(v1.Duration)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ProfileCatalogSourceSpec.
@@ -263,6 +325,17 @@ func (in *ProfileCatalogSourceSpec) DeepCopy() *ProfileCatalogSourceSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ProfileCatalogSourceStatus) DeepCopyInto(out *ProfileCatalogSourceStatus) {
 	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.LastScanTime != nil {
+		in, out := &in.LastScanTime, &out.LastScanTime
+		*out = (*in).DeepCopy()
+	}
 	if in.ScannedRepositories != nil {
 		in, out := &in.ScannedRepositories, &out.ScannedRepositories
 		*out = make([]ScannedRepository, len(*in))
@@ -498,6 +571,18 @@ func (in *ProfileInstallationStatus) DeepCopyInto(out *ProfileInstallationStatus
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.Source != nil {
+		in, out := &in.Source, &out.Source
+		*out = new(ResolvedSource)
+		**out = **in
+	}
+	if in.NestedProfiles != nil {
+		in, out := &in.NestedProfiles, &out.NestedProfiles
+		*out = make([]NestedProfileStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ProfileInstallationStatus.
@@ -518,6 +603,26 @@ func (in *Repository) DeepCopyInto(out *Repository) {
 		*out = new(meta.LocalObjectReference)
 		**out = **in
 	}
+	if in.Interval != nil {
+		in, out := &in.Interval, &out.Interval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.IncludeTags != nil {
+		in, out := &in.IncludeTags, &out.IncludeTags
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ExcludeTags != nil {
+		in, out := &in.ExcludeTags, &out.ExcludeTags
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Branches != nil {
+		in, out := &in.Branches, &out.Branches
+		*out = make([]TrackedBranch, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Repository.
@@ -530,6 +635,21 @@ func (in *Repository) DeepCopy() *Repository {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ResolvedSource) DeepCopyInto(out *ResolvedSource) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ResolvedSource.
+func (in *ResolvedSource) DeepCopy() *ResolvedSource {
+	if in == nil {
+		return nil
+	}
+	out := new(ResolvedSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ScannedRepository) DeepCopyInto(out *ScannedRepository) {
 	*out = *in
@@ -538,6 +658,36 @@ func (in *ScannedRepository) DeepCopyInto(out *ScannedRepository) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.Commits != nil {
+		in, out := &in.Commits, &out.Commits
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.LastScanTime != nil {
+		in, out := &in.LastScanTime, &out.LastScanTime
+		*out = (*in).DeepCopy()
+	}
+	if in.FailedTags != nil {
+		in, out := &in.FailedTags, &out.FailedTags
+		*out = make([]FailedTag, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.InvalidTags != nil {
+		in, out := &in.InvalidTags, &out.InvalidTags
+		*out = make([]InvalidTag, len(*in))
+		copy(*out, *in)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScannedRepository.
@@ -564,3 +714,18 @@ func (in *Source) DeepCopy() *Source {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TrackedBranch) DeepCopyInto(out *TrackedBranch) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TrackedBranch.
+func (in *TrackedBranch) DeepCopy() *TrackedBranch {
+	if in == nil {
+		return nil
+	}
+	out := new(TrackedBranch)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,266 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	profilesv1 "github.com/weaveworks/profiles/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// catalogSourceLabel labels the ConfigMaps persisting the profiles discovered for a catalog source.
+	catalogSourceLabel = "weave.works/profile-catalog-source"
+	// catalogHashAnnotation is the sha256 of the profiles persisted in the ConfigMap.
+	catalogHashAnnotation = "weave.works/profile-catalog-hash"
+	// catalogConfigMapKey is the key of the persisted profiles in the ConfigMap.
+	catalogConfigMapKey = "profiles.json"
+	// maxPersistedCatalogSize is the largest encoded catalog stored in a ConfigMap, whose data
+	// is limited to 1MiB.
+	maxPersistedCatalogSize = corev1.MaxSecretSize - len(catalogConfigMapKey)
+)
+
+// errCatalogTooLarge is returned when the encoded profiles of a catalog source do not fit in
+// a ConfigMap.
+var errCatalogTooLarge = errors.New("catalog is too large to be persisted")
+
+// persistedRepository holds the profiles found in a repository in the compact form they are
+// persisted in. The catalog source, tag pattern and profile filename of the profiles are not
+// persisted but re-derived from the catalog source on restore.
+type persistedRepository struct {
+	URL      string             `json:"url,omitempty"`
+	Profiles []persistedProfile `json:"profiles"`
+}
+
+// persistedProfile holds the fields of a catalog entry which are read from the profile
+// definition and cannot be re-derived without scanning the tag again.
+type persistedProfile struct {
+	Name                          string `json:"name"`
+	Tag                           string `json:"tag,omitempty"`
+	Branch                        string `json:"branch,omitempty"`
+	Commit                        string `json:"commit,omitempty"`
+	profilesv1.ProfileDescription `json:",inline"`
+	ArtifactPaths                 []string `json:"artifactPaths,omitempty"`
+}
+
+// catalogConfigMapName returns the name of the ConfigMap persisting the profiles of a catalog source.
+func catalogConfigMapName(sourceName string) string {
+	return "profile-catalog-" + sourceName
+}
+
+// encodeProfiles returns the compact form of the profiles, grouped by repository.
+func encodeProfiles(profiles []profilesv1.ProfileCatalogEntry) ([]byte, error) {
+	var repos []persistedRepository
+	index := make(map[string]int)
+	for _, p := range profiles {
+		i, ok := index[p.URL]
+		if !ok {
+			i = len(repos)
+			index[p.URL] = i
+			repos = append(repos, persistedRepository{URL: p.URL})
+		}
+		repos[i].Profiles = append(repos[i].Profiles, persistedProfile{
+			Name:               p.Name,
+			Tag:                p.Tag,
+			Branch:             p.Branch,
+			Commit:             p.Commit,
+			ProfileDescription: p.ProfileDescription,
+			ArtifactPaths:      p.ArtifactPaths,
+		})
+	}
+	return json.Marshal(repos)
+}
+
+// decodeProfiles returns the catalog entries of persisted profiles, re-deriving the fields which
+// are not persisted from the catalog source.
+func decodeProfiles(data []byte, pCatalog *profilesv1.ProfileCatalogSource) ([]profilesv1.ProfileCatalogEntry, error) {
+	var repos []persistedRepository
+	if err := json.Unmarshal(data, &repos); err != nil {
+		return nil, err
+	}
+	specs := make(map[string]profilesv1.Repository, len(pCatalog.Spec.Repos))
+	for _, repo := range pCatalog.Spec.Repos {
+		specs[repo.URL] = repo
+	}
+	var profiles []profilesv1.ProfileCatalogEntry
+	for _, repo := range repos {
+		spec := specs[repo.URL]
+		for _, p := range repo.Profiles {
+			tagPattern := spec.TagPattern
+			if p.Branch != "" {
+				tagPattern = profilesv1.BranchTagPattern
+			}
+			profiles = append(profiles, profilesv1.ProfileCatalogEntry{
+				Name:               p.Name,
+				Tag:                p.Tag,
+				URL:                repo.URL,
+				CatalogSource:      pCatalog.Name,
+				ProfileDescription: p.ProfileDescription,
+				TagPattern:         tagPattern,
+				Branch:             p.Branch,
+				Commit:             p.Commit,
+				ProfileFilename:    spec.ProfileFilename,
+				ArtifactPaths:      p.ArtifactPaths,
+			})
+		}
+	}
+	return profiles, nil
+}
+
+// persistCatalog writes the profiles discovered for the catalog source to a ConfigMap
+// owned by it, so the catalog can be restored when the controller restarts. The ConfigMap is
+// only written when the profiles changed. A catalog too large for a ConfigMap is not persisted
+// and errCatalogTooLarge is returned, after deleting the outdated ConfigMap so that the
+// repositories are scanned again on restart.
+func (r *ProfileCatalogSourceReconciler) persistCatalog(ctx context.Context, pCatalog *profilesv1.ProfileCatalogSource) error {
+	data, err := encodeProfiles(r.Profiles.List(pCatalog.Name))
+	if err != nil {
+		return fmt.Errorf("failed to encode profiles: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      catalogConfigMapName(pCatalog.Name),
+			Namespace: pCatalog.Namespace,
+		},
+	}
+	if len(data) > r.maxPersistedSize {
+		if err := r.Client.Delete(ctx, cm); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete outdated configmap %s: %w", cm.Name, err)
+		}
+		return fmt.Errorf("%w: %d bytes encoded, the limit is %d", errCatalogTooLarge, len(data), r.maxPersistedSize)
+	}
+
+	existing := &corev1.ConfigMap{}
+	err = r.Client.Get(ctx, client.ObjectKeyFromObject(cm), existing)
+	if err == nil && metav1.IsControlledBy(existing, pCatalog) && existing.Annotations[catalogHashAnnotation] == hash {
+		return nil
+	}
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to get configmap %s: %w", cm.Name, err)
+	}
+
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, cm, func() error {
+		if cm.Labels == nil {
+			cm.Labels = make(map[string]string)
+		}
+		cm.Labels[catalogSourceLabel] = pCatalog.Name
+		if cm.Annotations == nil {
+			cm.Annotations = make(map[string]string)
+		}
+		cm.Annotations[catalogHashAnnotation] = hash
+		cm.Data = map[string]string{catalogConfigMapKey: string(data)}
+		// take over a configmap left over from a deleted catalog source with the same name
+		if !metav1.IsControlledBy(cm, pCatalog) {
+			cm.OwnerReferences = nil
+		}
+		return controllerutil.SetControllerReference(pCatalog, cm, r.s)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to persist profiles to configmap %s: %w", cm.Name, err)
+	}
+	return nil
+}
+
+// restoreCatalog loads the profiles persisted for the catalog source into the catalog,
+// unless the catalog already exists. It reports whether the catalog exists afterwards.
+func (r *ProfileCatalogSourceReconciler) restoreCatalog(ctx context.Context, pCatalog *profilesv1.ProfileCatalogSource) (bool, error) {
+	if r.Profiles.CatalogExists(pCatalog.Name) {
+		return true, nil
+	}
+
+	cm := &corev1.ConfigMap{}
+	err := r.Client.Get(ctx, client.ObjectKey{Name: catalogConfigMapName(pCatalog.Name), Namespace: pCatalog.Namespace}, cm)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to get configmap %s: %w", catalogConfigMapName(pCatalog.Name), err)
+	}
+	// the configmap may be left over from a deleted catalog source with the same name
+	if !metav1.IsControlledBy(cm, pCatalog) {
+		return false, nil
+	}
+
+	profiles, err := decodeProfiles([]byte(cm.Data[catalogConfigMapKey]), pCatalog)
+	if err != nil {
+		return false, fmt.Errorf("failed to decode profiles from configmap %s: %w", cm.Name, err)
+	}
+	r.Profiles.AddIfNotExists(pCatalog.Name, profiles...)
+	return true, nil
+}
+
+// RestoreCatalogs populates the catalog from every ProfileCatalogSource in the cluster, using
+// the listed profiles of static sources and the persisted profiles of scanned ones. The status
+// of the restored sources is served with them until they are reconciled.
+func (r *ProfileCatalogSourceReconciler) RestoreCatalogs(ctx context.Context) error {
+	var sources profilesv1.ProfileCatalogSourceList
+	if err := r.Client.List(ctx, &sources); err != nil {
+		return fmt.Errorf("failed to list profile catalog sources: %w", err)
+	}
+
+	for i := range sources.Items {
+		pCatalog := &sources.Items[i]
+		if len(pCatalog.Spec.Profiles) > 0 {
+			if r.Profiles.AddIfNotExists(pCatalog.Name, pCatalog.Spec.Profiles...) {
+				r.Profiles.SetStatus(pCatalog.Name, pCatalog.Status)
+			}
+			continue
+		}
+		restored, err := r.restoreCatalog(ctx, pCatalog)
+		if err != nil {
+			return err
+		}
+		if restored {
+			r.Profiles.SetStatus(pCatalog.Name, pCatalog.Status)
+			r.log.Info("restored catalog", "profilecatalogsource", client.ObjectKeyFromObject(pCatalog), "profiles", len(r.Profiles.List(pCatalog.Name)))
+		}
+	}
+	return nil
+}
+
+// catalogRestorer restores the catalog when the manager starts. It intentionally runs on every
+// replica so that the API of replicas which are not the leader serves the catalog too. Only
+// the leader reconciles the catalog sources, so the other replicas serve the catalog as it
+// was persisted when they started, until they become the leader.
+type catalogRestorer struct {
+	reconciler *ProfileCatalogSourceReconciler
+}
+
+func (c catalogRestorer) Start(ctx context.Context) error {
+	if err := c.reconciler.RestoreCatalogs(ctx); err != nil {
+		// the reconciler rescans the sources whose catalog could not be restored
+		c.reconciler.log.Error(err, "failed to restore catalog")
+	}
+	return nil
+}
+
+func (c catalogRestorer) NeedLeaderElection() bool {
+	return false
+}
@@ -1,5 +1,15 @@
 package controllers
 
+import "github.com/weaveworks/profiles/pkg/installation"
+
 func (r *ProfileCatalogSourceReconciler) SetNewScanner(s NewScanner) {
 	r.newScanner = s
 }
+
+func (r *ProfileCatalogSourceReconciler) SetMaxPersistedSize(size int) {
+	r.maxPersistedSize = size
+}
+
+func (r *ProfileInstallationReconciler) SetFetcher(f installation.Fetcher) {
+	r.fetcher = f
+}
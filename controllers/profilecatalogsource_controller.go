@@ -18,10 +18,15 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/fluxcd/pkg/apis/meta"
 	"github.com/go-logr/logr"
 	profilesv1 "github.com/weaveworks/profiles/api/v1alpha1"
 	"github.com/weaveworks/profiles/pkg/catalog"
@@ -30,9 +35,13 @@ import (
 	"github.com/weaveworks/profiles/pkg/scanner"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 )
 
 // ProfileCatalogSourceReconciler reconciles a ProfileCatalogSource object
@@ -42,22 +51,61 @@ type ProfileCatalogSourceReconciler struct {
 	s          *runtime.Scheme
 	Profiles   *catalog.Catalog
 	newScanner NewScanner
+	recorder   record.EventRecorder
 	timeout    time.Duration
 	interval   time.Duration
+	// maxPersistedSize is the largest encoded catalog persisted in a ConfigMap
+	maxPersistedSize int
+	// scanSem is shared by the scans of all catalog sources, created on first use
+	scanSem     scanner.Semaphore
+	scanSemOnce sync.Once
+
+	// ScanConcurrency is the number of repositories and tags scanned in parallel, across all
+	// the repositories of all catalog sources.
+	ScanConcurrency int
+	// MaxConcurrentReconciles is the number of catalog sources reconciled in parallel.
+	MaxConcurrentReconciles int
 }
 
 func NewCatalogSourceReconciler(c client.Client, log logr.Logger, scheme *runtime.Scheme, profiles *catalog.Catalog) *ProfileCatalogSourceReconciler {
-	return &ProfileCatalogSourceReconciler{
-		Client:     c,
-		log:        log,
-		s:          scheme,
-		Profiles:   profiles,
-		newScanner: scanner.New,
-		timeout:    time.Minute * 2,
-		interval:   time.Second * 5,
+	r := &ProfileCatalogSourceReconciler{
+		Client:                  c,
+		log:                     log,
+		s:                       scheme,
+		Profiles:                profiles,
+		timeout:                 time.Minute * 2,
+		interval:                time.Second * 5,
+		maxPersistedSize:        maxPersistedCatalogSize,
+		ScanConcurrency:         scanner.DefaultConcurrency,
+		MaxConcurrentReconciles: 1,
+	}
+	r.newScanner = func(gitRepositoryManager scanner.GitRepositoryManager, gitClient scanner.GitClient, httpClient scanner.HTTPClient, logger logr.Logger) scanner.RepoScanner {
+		return scanner.New(gitRepositoryManager, gitClient, httpClient, logger, scanner.WithSemaphore(r.scanSemaphore()))
 	}
+	return r
+}
+
+// scanSemaphore returns the semaphore limiting the repositories and tags scanned in parallel
+// to ScanConcurrency.
+func (r *ProfileCatalogSourceReconciler) scanSemaphore() scanner.Semaphore {
+	r.scanSemOnce.Do(func() {
+		r.scanSem = scanner.NewSemaphore(r.ScanConcurrency)
+	})
+	return r.scanSem
 }
 
+// UseDirectScanner configures the reconciler to read profiles directly from the repositories
+// with git instead of through Flux GitRepository resources.
+func (r *ProfileCatalogSourceReconciler) UseDirectScanner() {
+	r.newScanner = func(_ scanner.GitRepositoryManager, gitClient scanner.GitClient, _ scanner.HTTPClient, logger logr.Logger) scanner.RepoScanner {
+		return scanner.NewDirect(gitClient, &git.Client{}, logger, scanner.WithSemaphore(r.scanSemaphore()))
+	}
+}
+
+// defaultScanInterval is the interval at which repositories are rescanned when the
+// catalog source does not set one.
+const defaultScanInterval = time.Minute * 10
+
 type NewScanner func(gitRepositoryManager scanner.GitRepositoryManager, gitClient scanner.GitClient, httpClients scanner.HTTPClient, logger logr.Logger) scanner.RepoScanner
 
 // +kubebuilder:rbac:groups=weave.works,resources=profilecatalogsources,verbs=get;list;watch;create;update;patch;delete
@@ -70,6 +118,7 @@ type NewScanner func(gitRepositoryManager scanner.GitRepositoryManager, gitClien
 // +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 // +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -94,45 +143,482 @@ func (r *ProfileCatalogSourceReconciler) Reconcile(ctx context.Context, req ctrl
 	if len(pCatalog.Spec.Profiles) > 0 {
 		logger.Info("updating catalog entries", "profiles", pCatalog.Spec.Profiles)
 		r.Profiles.AddOrReplace(pCatalog.Name, pCatalog.Spec.Profiles...)
-		return ctrl.Result{}, r.updateStatus(ctx, req, profilesv1.ProfileCatalogSourceStatus{})
+		status := profilesv1.ProfileCatalogSourceStatus{Conditions: pCatalog.Status.Conditions}
+		setScanConditions(&status.Conditions, pCatalog.Generation, profilesv1.ReasonStaticCatalog, fmt.Sprintf("%d profiles listed", len(pCatalog.Spec.Profiles)), nil)
+		return ctrl.Result{}, r.updateStatus(ctx, req, status)
 	}
 
 	gitRepoManager := gitrepository.NewManager(ctx, pCatalog.Namespace, r.Client, r.timeout, r.interval)
-	scanner := r.newScanner(gitRepoManager, &git.Client{}, http.DefaultClient, logger)
-	catalogExists := r.Profiles.CatalogExists(pCatalog.Name)
+	repoScanner := r.newScanner(gitRepoManager, &git.Client{}, http.DefaultClient, logger)
+	// restore the catalog persisted before a restart so that only new tags have to be scanned
+	catalogExists, err := r.restoreCatalog(ctx, &pCatalog)
+	if err != nil {
+		logger.Error(err, "failed to restore catalog, rescanning all repositories")
+	}
 
-	for _, repo := range pCatalog.Spec.Repos {
-		logger.Info("scan repo for profiles", "repo", repo)
-		var secret *corev1.Secret
-		if repo.SecretRef != nil {
-			secret = &corev1.Secret{}
-			objectKey := client.ObjectKey{Name: repo.SecretRef.Name, Namespace: pCatalog.Namespace}
-			if err := r.Client.Get(ctx, objectKey, secret); err != nil {
-				return ctrl.Result{}, fmt.Errorf("failed to find secret for repo %v: %w", repo, err)
+	// the profiles and the status of repositories removed from the spec are dropped, and the
+	// catalog persisted before the status so that they are not restored after a restart
+	if removedRepos := removeRepositories(&pCatalog); len(removedRepos) > 0 {
+		for _, url := range removedRepos {
+			removed := r.Profiles.RemoveIf(pCatalog.Name, func(p profilesv1.ProfileCatalogEntry) bool {
+				return p.URL == url
+			})
+			logger.Info("removed repository", "repo", url, "profiles", removed)
+			r.recorder.Eventf(&pCatalog, corev1.EventTypeNormal, profilesv1.ReasonRepositoryRemoved, "removed repository %s and its %d profiles from the catalog", url, len(removed))
+		}
+		if catalogExists {
+			if err := r.persist(ctx, logger, &pCatalog); err != nil {
+				return ctrl.Result{}, err
 			}
 		}
+	}
 
-		var alreadyScannedTags []string
-		if catalogExists {
-			for _, scannedRepo := range pCatalog.Status.ScannedRepositories {
-				if scannedRepo.URL == repo.URL {
-					alreadyScannedTags = scannedRepo.Tags
+	// a changed spec, a rescan request or a wiped catalog require all repositories to be
+	// scanned, unless the rescan request is limited to some repositories, e.g. by a webhook
+	requestedAt := pCatalog.Annotations[meta.ReconcileRequestAnnotation]
+	rescanRequested := requestedAt != pCatalog.Status.LastHandledReconcileAt
+	var requestedRepos []string
+	if rescanRequested {
+		requestedRepos = profilesv1.RequestedRepositories(&pCatalog)
+	}
+	scanAll := !catalogExists ||
+		pCatalog.Generation != pCatalog.Status.ObservedGeneration ||
+		(rescanRequested && requestedRepos == nil)
+
+	now := metav1.Now()
+	var (
+		requeueAfter time.Duration
+		due          []profilesv1.Repository
+	)
+	for _, repo := range pCatalog.Spec.Repos {
+		interval := scanInterval(pCatalog, repo)
+		if !scanAll && !containsString(requestedRepos, repo.URL) {
+			if lastScan := lastScanTime(pCatalog, repo); lastScan != nil {
+				elapsed := now.Sub(lastScan.Time)
+				retry, retryDue := nextFailedTagRetry(pCatalog, repo, now)
+				if elapsed < interval && !retryDue {
+					logger.Info("skipping repo, next scan not due yet", "repo", repo.URL, "due", interval-elapsed)
+					requeueAfter = minDuration(minDuration(requeueAfter, interval-elapsed), retry)
+					continue
 				}
 			}
 		}
+		due = append(due, repo)
+	}
 
-		profiles, newTags, err := scanner.ScanRepository(repo, secret, alreadyScannedTags)
-		if err != nil {
+	if len(due) > 0 {
+		for _, repo := range due {
+			i := scannedRepositoryIndex(&pCatalog, repo.URL)
+			setCondition(&pCatalog.Status.ScannedRepositories[i].Conditions, pCatalog.Generation, profilesv1.ConditionTypeScanning, metav1.ConditionTrue, profilesv1.ReasonScanInProgress, "scanning repository")
+		}
+		setCondition(&pCatalog.Status.Conditions, pCatalog.Generation, profilesv1.ConditionTypeScanning, metav1.ConditionTrue, profilesv1.ReasonScanInProgress, fmt.Sprintf("scanning %d repositories", len(due)))
+		if err := r.updateStatus(ctx, req, pCatalog.Status); err != nil {
 			return ctrl.Result{}, err
 		}
+	}
+
+	var (
+		failed  []string
+		scanned bool
+	)
+	results := r.scanRepositories(ctx, logger, repoScanner, pCatalog, due, catalogExists, now)
+	for i, repo := range due {
+		profiles, tags, err := results[i].profiles, results[i].tags, results[i].err
+		var partialErr *scanner.PartialScanError
+		if err != nil && !errors.As(err, &partialErr) {
+			logger.Error(err, "failed to scan repo", "repo", repo.URL)
+			r.recorder.Eventf(&pCatalog, corev1.EventTypeWarning, profilesv1.ReasonScanFailed, "failed to scan repository %s: %s", repo.URL, err)
+			i := scannedRepositoryIndex(&pCatalog, repo.URL)
+			setScanConditions(&pCatalog.Status.ScannedRepositories[i].Conditions, pCatalog.Generation, profilesv1.ReasonScanFailed, err.Error(), err)
+			failed = append(failed, repo.URL)
+			continue
+		}
 
-		updateScannedRepositoryStatus(&pCatalog, repo, newTags, catalogExists)
+		i := scannedRepositoryIndex(&pCatalog, repo.URL)
+		scannedRepo := &pCatalog.Status.ScannedRepositories[i]
+		var skippedTags []string
+		if catalogExists {
+			for _, failedTag := range pendingFailedTags(scannedRepo.FailedTags, now) {
+				skippedTags = append(skippedTags, failedTag.Tag)
+			}
+		}
+		newTags, removedTags := updateScannedTags(scannedRepo, tags, skippedTags, now)
+		// tags with an invalid profile definition are recorded as scanned and reported once,
+		// only the tags which failed transiently are retried and fail the repository
+		failedErr, invalidErrs := splitTagErrors(partialErr)
+		scannedRepo.FailedTags = updateFailedTags(scannedRepo.FailedTags, failedErr, catalogExists, now, scanInterval(pCatalog, repo))
+		scannedRepo.InvalidTags = updateInvalidTags(scannedRepo, invalidErrs, newTags)
+		for _, tagErr := range invalidErrs {
+			logger.Info("invalid profile definition", "repo", repo.URL, "tag", tagErr.Tag, "error", tagErr.Err.Error())
+			r.recorder.Eventf(&pCatalog, corev1.EventTypeWarning, profilesv1.ReasonInvalidTags, "invalid profile definition in repository %s: %s", repo.URL, tagErr)
+		}
+		if failedErr != nil {
+			logger.Error(failedErr, "failed to scan tags", "repo", repo.URL)
+			r.recorder.Eventf(&pCatalog, corev1.EventTypeWarning, profilesv1.ReasonTagsFailed, "failed to scan tags of repository %s: %s", repo.URL, failedErr)
+			setScanConditions(&scannedRepo.Conditions, pCatalog.Generation, profilesv1.ReasonTagsFailed, failedErr.Error(), failedErr)
+		} else {
+			setScanConditions(&scannedRepo.Conditions, pCatalog.Generation, profilesv1.ReasonScanSucceeded, fmt.Sprintf("found %d new tags", len(newTags)), nil)
+		}
+		pCatalog.Status.LastScanTime = &now
+		retry, _ := nextFailedTagRetry(pCatalog, repo, now)
+		requeueAfter = minDuration(minDuration(requeueAfter, scanInterval(pCatalog, repo)), retry)
+		// the profiles of deleted and moved tags are removed before the profiles of the moved
+		// tags are added again
+		if removed := r.Profiles.RemoveIf(pCatalog.Name, profileOfTags(repo, removedTags)); len(removed) > 0 {
+			logger.Info("removed profiles of deleted or moved tags", "repo", repo.URL, "tags", removedTags, "profiles", removed)
+		}
 		logger.Info("updating catalog with scanning reuslts", "profiles", profiles)
-		r.Profiles.Append(pCatalog.Name, profiles...)
+		if dropped := r.Profiles.Append(pCatalog.Name, profiles...); len(dropped) > 0 {
+			var conflicts []string
+			for _, p := range dropped {
+				conflicts = append(conflicts, fmt.Sprintf("%s:%s (%s)", p.Name, p.Tag, p.URL))
+			}
+			logger.Info("dropped profiles published by more than one repository", "repo", repo.URL, "profiles", dropped)
+			r.recorder.Eventf(&pCatalog, corev1.EventTypeWarning, profilesv1.ReasonProfileConflict, "dropped profiles published by more than one repository: %s", strings.Join(conflicts, ", "))
+			// the tags of the dropped profiles of the repository are not recorded as scanned, so
+			// that they are scanned again, and added once they no longer conflict
+			removeScannedTags(scannedRepo, droppedTags(repo.URL, dropped))
+		}
+		scanned = true
+	}
+
+	// persist the catalog before the status so that the scanned tags are never ahead of the
+	// persisted profiles
+	if scanned {
+		if err := r.persist(ctx, logger, &pCatalog); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	var scanErr error
+	if len(failed) > 0 {
+		scanErr = fmt.Errorf("failed to scan repositories: %s", strings.Join(failed, ", "))
+	}
+	// the catalog source is added to the catalog even when none of its repositories could be
+	// scanned, so that its status is served with the catalog
+	r.Profiles.AddIfNotExists(pCatalog.Name)
+	setSourceConditions(&pCatalog)
+	pCatalog.Status.ObservedGeneration = pCatalog.Generation
+	pCatalog.Status.LastHandledReconcileAt = requestedAt
+	logger.Info("updating status", "status", pCatalog.Status, "requeueAfter", requeueAfter)
+	if err := r.updateStatus(ctx, req, pCatalog.Status); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: requeueAfter}, scanErr
+}
+
+// persist persists the profiles of the catalog source. A catalog too large to be persisted is
+// reported in the Persisted condition and an event instead of failing the reconcile.
+func (r *ProfileCatalogSourceReconciler) persist(ctx context.Context, logger logr.Logger, pCatalog *profilesv1.ProfileCatalogSource) error {
+	err := r.persistCatalog(ctx, pCatalog)
+	if errors.Is(err, errCatalogTooLarge) {
+		logger.Error(err, "failed to persist catalog")
+		r.recorder.Eventf(pCatalog, corev1.EventTypeWarning, profilesv1.ReasonCatalogTooLarge, "profiles are not persisted and will be rescanned on restart: %s", err)
+		setCondition(&pCatalog.Status.Conditions, pCatalog.Generation, profilesv1.ConditionTypePersisted, metav1.ConditionFalse, profilesv1.ReasonCatalogTooLarge, err.Error())
+		return nil
+	}
+	if err != nil {
+		logger.Error(err, "failed to persist catalog")
+		return err
+	}
+	setCondition(&pCatalog.Status.Conditions, pCatalog.Generation, profilesv1.ConditionTypePersisted, metav1.ConditionTrue, profilesv1.ReasonCatalogPersisted, "profiles persisted to configmap "+catalogConfigMapName(pCatalog.Name))
+	return nil
+}
+
+type scanResult struct {
+	profiles []profilesv1.ProfileCatalogEntry
+	tags     map[string]string
+	err      error
+}
+
+// scanRepositories scans the repositories in parallel, returning the result of each
+// repository at its index. The scanners limit the tags scanned in parallel with the
+// semaphore shared by all scans, so the repositories are not limited here.
+func (r *ProfileCatalogSourceReconciler) scanRepositories(ctx context.Context, logger logr.Logger, repoScanner scanner.RepoScanner, pCatalog profilesv1.ProfileCatalogSource, repos []profilesv1.Repository, catalogExists bool, now metav1.Time) []scanResult {
+	results := make([]scanResult, len(repos))
+	var wg sync.WaitGroup
+	for i := range repos {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			profiles, tags, err := r.scanRepository(ctx, logger, repoScanner, pCatalog, repos[i], catalogExists, now)
+			results[i] = scanResult{profiles: profiles, tags: tags, err: err}
+		}(i)
+	}
+	wg.Wait()
+	return results
+}
+
+// scanRepository scans a repository for profiles, returning the profiles found and the
+// scanned tags with their commits.
+func (r *ProfileCatalogSourceReconciler) scanRepository(ctx context.Context, logger logr.Logger, repoScanner scanner.RepoScanner, pCatalog profilesv1.ProfileCatalogSource, repo profilesv1.Repository, catalogExists bool, now metav1.Time) ([]profilesv1.ProfileCatalogEntry, map[string]string, error) {
+	logger.Info("scan repo for profiles", "repo", repo)
+	var secret *corev1.Secret
+	if repo.SecretRef != nil {
+		secret = &corev1.Secret{}
+		objectKey := client.ObjectKey{Name: repo.SecretRef.Name, Namespace: pCatalog.Namespace}
+		if err := r.Client.Get(ctx, objectKey, secret); err != nil {
+			return nil, nil, fmt.Errorf("failed to find secret for repo %v: %w", repo, err)
+		}
+	}
+
+	// failed tags which are still backing off are skipped like the scanned tags
+	var scannedTags map[string]string
+	if catalogExists {
+		scannedTags = make(map[string]string)
+		for _, scannedRepo := range pCatalog.Status.ScannedRepositories {
+			if scannedRepo.URL == repo.URL {
+				for _, tag := range scannedRepo.Tags {
+					scannedTags[tag] = scannedRepo.Commits[tag]
+				}
+				for _, failedTag := range pendingFailedTags(scannedRepo.FailedTags, now) {
+					scannedTags[failedTag.Tag] = ""
+				}
+			}
+		}
+	}
+
+	return repoScanner.ScanRepository(repo, secret, scannedTags)
+}
+
+// profileOfTags returns whether a profile of the catalog is from one of the tags of the repository.
+func profileOfTags(repo profilesv1.Repository, tags []string) func(profilesv1.ProfileCatalogEntry) bool {
+	return func(p profilesv1.ProfileCatalogEntry) bool {
+		if p.URL != repo.URL {
+			return false
+		}
+		for _, tag := range tags {
+			if p.Tag == tag {
+				return true
+			}
+		}
+		return false
 	}
+}
 
-	logger.Info("updating status", "status", pCatalog.Status)
-	return ctrl.Result{}, r.updateStatus(ctx, req, pCatalog.Status)
+// setSourceConditions summarises the conditions of the scanned repositories on the catalog source.
+func setSourceConditions(pCatalog *profilesv1.ProfileCatalogSource) {
+	var failed []string
+	for _, repo := range pCatalog.Status.ScannedRepositories {
+		if apimeta.IsStatusConditionTrue(repo.Conditions, profilesv1.ConditionTypeFailed) {
+			failed = append(failed, repo.URL)
+		}
+	}
+
+	if len(failed) > 0 {
+		err := fmt.Errorf("%d of %d repositories failed to scan: %s", len(failed), len(pCatalog.Status.ScannedRepositories), strings.Join(failed, ", "))
+		setScanConditions(&pCatalog.Status.Conditions, pCatalog.Generation, profilesv1.ReasonScanFailed, err.Error(), err)
+		return
+	}
+	setScanConditions(&pCatalog.Status.Conditions, pCatalog.Generation, profilesv1.ReasonScanSucceeded, fmt.Sprintf("scanned %d repositories", len(pCatalog.Status.ScannedRepositories)), nil)
+}
+
+// setScanConditions sets the conditions reporting the result of a scan.
+func setScanConditions(conditions *[]metav1.Condition, generation int64, reason, message string, err error) {
+	ready, failed := metav1.ConditionTrue, metav1.ConditionFalse
+	if err != nil {
+		ready, failed = metav1.ConditionFalse, metav1.ConditionTrue
+	}
+	setCondition(conditions, generation, profilesv1.ConditionTypeScanning, metav1.ConditionFalse, reason, message)
+	setCondition(conditions, generation, profilesv1.ConditionTypeReady, ready, reason, message)
+	setCondition(conditions, generation, profilesv1.ConditionTypeFailed, failed, reason, message)
+}
+
+func setCondition(conditions *[]metav1.Condition, generation int64, conditionType string, status metav1.ConditionStatus, reason, message string) {
+	apimeta.SetStatusCondition(conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		ObservedGeneration: generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// removeRepositories removes the scanned repositories which are no longer in the spec from the
+// status, returning their URLs.
+func removeRepositories(pCatalog *profilesv1.ProfileCatalogSource) []string {
+	var (
+		kept    []profilesv1.ScannedRepository
+		removed []string
+	)
+	for _, scannedRepo := range pCatalog.Status.ScannedRepositories {
+		inSpec := false
+		for _, repo := range pCatalog.Spec.Repos {
+			if repo.URL == scannedRepo.URL {
+				inSpec = true
+			}
+		}
+		if inSpec {
+			kept = append(kept, scannedRepo)
+		} else {
+			removed = append(removed, scannedRepo.URL)
+		}
+	}
+	pCatalog.Status.ScannedRepositories = kept
+	return removed
+}
+
+// scannedRepositoryIndex returns the index of the status of a repository, adding it when missing.
+func scannedRepositoryIndex(pCatalog *profilesv1.ProfileCatalogSource, url string) int {
+	for i, scannedRepo := range pCatalog.Status.ScannedRepositories {
+		if scannedRepo.URL == url {
+			return i
+		}
+	}
+	pCatalog.Status.ScannedRepositories = append(pCatalog.Status.ScannedRepositories, profilesv1.ScannedRepository{URL: url})
+	return len(pCatalog.Status.ScannedRepositories) - 1
+}
+
+// scanInterval returns the interval at which a repository of the catalog source is rescanned.
+func scanInterval(pCatalog profilesv1.ProfileCatalogSource, repo profilesv1.Repository) time.Duration {
+	if repo.Interval != nil && repo.Interval.Duration > 0 {
+		return repo.Interval.Duration
+	}
+	if pCatalog.Spec.Interval != nil && pCatalog.Spec.Interval.Duration > 0 {
+		return pCatalog.Spec.Interval.Duration
+	}
+	return defaultScanInterval
+}
+
+// failedTagBackoff is the delay before a failed tag is retried for the first time. The delay
+// doubles with every failed attempt, up to the scan interval of the repository.
+const failedTagBackoff = time.Minute
+
+// updateFailedTags returns the failed tags of a repository after it was scanned: failed tags
+// which were not retried are kept, tags which failed again back off further and the others
+// are dropped.
+func updateFailedTags(failedTags []profilesv1.FailedTag, partialErr *scanner.PartialScanError, catalogExists bool, now metav1.Time, interval time.Duration) []profilesv1.FailedTag {
+	var updated []profilesv1.FailedTag
+	if catalogExists {
+		updated = pendingFailedTags(failedTags, now)
+	}
+	if partialErr == nil {
+		return updated
+	}
+
+	for _, tagErr := range partialErr.TagErrors {
+		attempts := 1
+		for _, failedTag := range failedTags {
+			if failedTag.Tag == tagErr.Tag {
+				attempts = failedTag.Attempts + 1
+			}
+		}
+		updated = append(updated, profilesv1.FailedTag{
+			Tag:             tagErr.Tag,
+			Message:         tagErr.Err.Error(),
+			Attempts:        attempts,
+			LastAttemptTime: now,
+			NextAttemptTime: metav1.NewTime(now.Add(retryBackoff(attempts, interval))),
+		})
+	}
+	return updated
+}
+
+// splitTagErrors separates the errors of the tags which are retried from the errors of the
+// tags whose profile definition is invalid, which fail the same way until they are moved.
+func splitTagErrors(partialErr *scanner.PartialScanError) (*scanner.PartialScanError, []scanner.TagError) {
+	if partialErr == nil {
+		return nil, nil
+	}
+	var failed, invalid []scanner.TagError
+	for _, tagErr := range partialErr.TagErrors {
+		if tagErr.Permanent() {
+			invalid = append(invalid, tagErr)
+		} else {
+			failed = append(failed, tagErr)
+		}
+	}
+	if len(failed) == 0 {
+		return nil, invalid
+	}
+	return &scanner.PartialScanError{TagErrors: failed}, invalid
+}
+
+// updateInvalidTags returns the invalid tags of a repository after it was scanned: the tags
+// which were scanned again are replaced by the invalid tags of the scan, and the tags which
+// are no longer scanned are dropped.
+func updateInvalidTags(scannedRepo *profilesv1.ScannedRepository, invalidErrs []scanner.TagError, newTags []string) []profilesv1.InvalidTag {
+	var updated []profilesv1.InvalidTag
+	for _, tagErr := range invalidErrs {
+		updated = append(updated, profilesv1.InvalidTag{Tag: tagErr.Tag, Message: tagErr.Err.Error()})
+	}
+	for _, invalidTag := range scannedRepo.InvalidTags {
+		if containsString(scannedRepo.Tags, invalidTag.Tag) && !containsString(newTags, invalidTag.Tag) && !hasInvalidTag(updated, invalidTag.Tag) {
+			updated = append(updated, invalidTag)
+		}
+	}
+	sort.Slice(updated, func(i, j int) bool {
+		return updated[i].Tag < updated[j].Tag
+	})
+	return updated
+}
+
+func hasInvalidTag(invalidTags []profilesv1.InvalidTag, tag string) bool {
+	for _, invalidTag := range invalidTags {
+		if invalidTag.Tag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// pendingFailedTags returns the failed tags which are not due to be retried yet.
+func pendingFailedTags(failedTags []profilesv1.FailedTag, now metav1.Time) []profilesv1.FailedTag {
+	var pending []profilesv1.FailedTag
+	for _, failedTag := range failedTags {
+		if now.Before(&failedTag.NextAttemptTime) {
+			pending = append(pending, failedTag)
+		}
+	}
+	return pending
+}
+
+// nextFailedTagRetry returns the time until the next failed tag of a repository is retried,
+// and whether a retry is due already. It returns zero when there are no failed tags.
+func nextFailedTagRetry(pCatalog profilesv1.ProfileCatalogSource, repo profilesv1.Repository, now metav1.Time) (time.Duration, bool) {
+	var next time.Duration
+	for _, scannedRepo := range pCatalog.Status.ScannedRepositories {
+		if scannedRepo.URL != repo.URL {
+			continue
+		}
+		for _, failedTag := range scannedRepo.FailedTags {
+			wait := failedTag.NextAttemptTime.Sub(now.Time)
+			if wait <= 0 {
+				return 0, true
+			}
+			next = minDuration(next, wait)
+		}
+	}
+	return next, false
+}
+
+// retryBackoff returns the delay before a tag which failed the given number of times is retried.
+func retryBackoff(attempts int, interval time.Duration) time.Duration {
+	backoff := failedTagBackoff
+	for i := 1; i < attempts && backoff < interval; i++ {
+		backoff *= 2
+	}
+	if backoff > interval {
+		return interval
+	}
+	return backoff
+}
+
+func lastScanTime(pCatalog profilesv1.ProfileCatalogSource, repo profilesv1.Repository) *metav1.Time {
+	for _, scannedRepo := range pCatalog.Status.ScannedRepositories {
+		if scannedRepo.URL == repo.URL {
+			return scannedRepo.LastScanTime
+		}
+	}
+	return nil
+}
+
+// minDuration returns the smallest of two durations, ignoring a zero duration.
+func minDuration(a, b time.Duration) time.Duration {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
 }
 
 func (r *ProfileCatalogSourceReconciler) updateStatus(ctx context.Context, req ctrl.Request, newStatus profilesv1.ProfileCatalogSourceStatus) error {
@@ -144,29 +630,92 @@ func (r *ProfileCatalogSourceReconciler) updateStatus(ctx context.Context, req c
 	patch := client.MergeFrom(latestCatalog.DeepCopy())
 	latestCatalog.Status = newStatus
 
-	return r.Status().Patch(ctx, &latestCatalog, patch)
+	if err := r.Status().Patch(ctx, &latestCatalog, patch); err != nil {
+		return err
+	}
+	// the status is served by the catalog API with the profiles of the catalog source
+	r.Profiles.SetStatus(req.Name, newStatus)
+	return nil
 }
 
-func updateScannedRepositoryStatus(pCatalog *profilesv1.ProfileCatalogSource, repo profilesv1.Repository, newTags []string, appendToExisting bool) {
-	for i, scannedRepo := range pCatalog.Status.ScannedRepositories {
-		if scannedRepo.URL == repo.URL {
-			if appendToExisting {
-				pCatalog.Status.ScannedRepositories[i].Tags = append(scannedRepo.Tags, newTags...)
-			} else {
-				pCatalog.Status.ScannedRepositories[i].Tags = newTags
+// updateScannedTags records the tags of a repository which are scanned, except for the skipped
+// tags, with their commits. It returns the tags which were added, and the tags which were removed
+// because they were deleted or moved.
+func updateScannedTags(scannedRepo *profilesv1.ScannedRepository, tags map[string]string, skippedTags []string, scanTime metav1.Time) ([]string, []string) {
+	var added, removed []string
+	for _, tag := range scannedRepo.Tags {
+		commit, ok := tags[tag]
+		if !ok || (commit != "" && scannedRepo.Commits[tag] != "" && commit != scannedRepo.Commits[tag]) {
+			removed = append(removed, tag)
+		}
+	}
+
+	previousTags := scannedRepo.Tags
+	scannedRepo.Tags, scannedRepo.Commits = nil, nil
+	for tag, commit := range tags {
+		if containsString(skippedTags, tag) {
+			continue
+		}
+		scannedRepo.Tags = append(scannedRepo.Tags, tag)
+		if commit != "" {
+			if scannedRepo.Commits == nil {
+				scannedRepo.Commits = make(map[string]string)
 			}
-			return
+			scannedRepo.Commits[tag] = commit
+		}
+		if !containsString(previousTags, tag) || containsString(removed, tag) {
+			added = append(added, tag)
 		}
 	}
-	pCatalog.Status.ScannedRepositories = append(pCatalog.Status.ScannedRepositories, profilesv1.ScannedRepository{
-		URL:  repo.URL,
-		Tags: newTags,
-	})
+	sort.Strings(scannedRepo.Tags)
+	scannedRepo.LastScanTime = &scanTime
+	return added, removed
+}
+
+// removeScannedTags removes tags from the scanned tags of a repository.
+func removeScannedTags(scannedRepo *profilesv1.ScannedRepository, tags []string) {
+	var kept []string
+	for _, tag := range scannedRepo.Tags {
+		if containsString(tags, tag) {
+			delete(scannedRepo.Commits, tag)
+			continue
+		}
+		kept = append(kept, tag)
+	}
+	scannedRepo.Tags = kept
+	if len(scannedRepo.Commits) == 0 {
+		scannedRepo.Commits = nil
+	}
+}
+
+// droppedTags returns the tags of the dropped profiles published by the repository `url`.
+func droppedTags(url string, dropped []profilesv1.ProfileCatalogEntry) []string {
+	var tags []string
+	for _, p := range dropped {
+		if p.URL == url {
+			tags = append(tags, p.Tag)
+		}
+	}
+	return tags
+}
+
+func containsString(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *ProfileCatalogSourceReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.recorder = mgr.GetEventRecorderFor("profilecatalogsource-controller")
+	if err := mgr.Add(catalogRestorer{reconciler: r}); err != nil {
+		return err
+	}
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&profilesv1.ProfileCatalogSource{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
 		Complete(r)
 }
@@ -3,6 +3,7 @@ package controllers_test
 import (
 	"context"
 	"fmt"
+	"path"
 	"time"
 
 	"github.com/fluxcd/pkg/apis/meta"
@@ -10,11 +11,14 @@ import (
 	"github.com/google/uuid"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
 	profilesv1 "github.com/weaveworks/profiles/api/v1alpha1"
 	"github.com/weaveworks/profiles/pkg/scanner"
 	"github.com/weaveworks/profiles/pkg/scanner/fakes"
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -62,7 +66,7 @@ var _ = Describe("ProfileCatalogSourceController", func() {
 
 			By("searching for a profile")
 			query := func() []profilesv1.ProfileCatalogEntry {
-				return catalogReconciler.Profiles.Search("foo")
+				return catalogReconciler.Profiles.List("catalog")
 			}
 			Eventually(query, 2*time.Second).Should(ContainElement(profilesv1.ProfileCatalogEntry{ProfileDescription: profilesv1.ProfileDescription{Description: "bar"}, Name: "foo", CatalogSource: "catalog"}))
 			Expect(k8sClient.Get(context.Background(), client.ObjectKey{Namespace: namespace, Name: "catalog"}, catalogSource)).To(Succeed())
@@ -77,9 +81,9 @@ var _ = Describe("ProfileCatalogSourceController", func() {
 			})
 			Expect(k8sClient.Update(context.Background(), catalogSource)).To(Succeed())
 
-			Eventually(func() []profilesv1.ProfileCatalogEntry {
-				return catalogReconciler.Profiles.Search(pName)
-			}, 2*time.Second).Should(ConsistOf(profilesv1.ProfileCatalogEntry{
+			Eventually(func() *profilesv1.ProfileCatalogEntry {
+				return catalogReconciler.Profiles.Get("catalog", pName)
+			}, 2*time.Second).Should(Equal(&profilesv1.ProfileCatalogEntry{
 				ProfileDescription: profilesv1.ProfileDescription{
 					Description: "I am new here",
 				},
@@ -90,12 +94,29 @@ var _ = Describe("ProfileCatalogSourceController", func() {
 			By("deleting the ProfileCatalogSource")
 			Expect(k8sClient.Delete(ctx, catalogSource)).To(Succeed())
 			Eventually(query, 2*time.Second).Should(BeEmpty())
-			Expect(catalogReconciler.Profiles.Search(pName)).To(BeEmpty())
+			Expect(catalogReconciler.Profiles.Get("catalog", pName)).To(BeNil())
 		})
 	})
 
 	When("providing a repo to scan", func() {
 		var catalogSource *profilesv1.ProfileCatalogSource
+
+		// scannedRepositories returns the scanned repositories without their scan times
+		scannedRepositories := func() []profilesv1.ScannedRepository {
+			Expect(k8sClient.Get(context.Background(), client.ObjectKey{Namespace: namespace, Name: "catalog-2"}, catalogSource)).To(Succeed())
+			var repos []profilesv1.ScannedRepository
+			for _, repo := range catalogSource.Status.ScannedRepositories {
+				Expect(repo.LastScanTime).NotTo(BeNil())
+				repos = append(repos, profilesv1.ScannedRepository{URL: repo.URL, Tags: repo.Tags})
+			}
+			return repos
+		}
+
+		requestRescan := func() {
+			Expect(k8sClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: "catalog-2"}, catalogSource)).To(Succeed())
+			catalogSource.Annotations = map[string]string{meta.ReconcileRequestAnnotation: time.Now().Format(time.RFC3339Nano)}
+			Expect(k8sClient.Update(ctx, catalogSource)).To(Succeed())
+		}
 		BeforeEach(func() {
 			fakeRepoScanner = new(fakes.FakeRepoScanner)
 			catalogReconciler.SetNewScanner(
@@ -107,7 +128,8 @@ var _ = Describe("ProfileCatalogSourceController", func() {
 				{
 					Name: "foo",
 				},
-			}, []string{"foo"}, nil)
+			}, map[string]string{"foo": "1111111"}, nil)
+			fakeRepoScanner.ScanRepositoryReturns(nil, map[string]string{"foo": "1111111"}, nil)
 
 			By("creating a new ProfileCatalogSource")
 			catalogSource = &profilesv1.ProfileCatalogSource{
@@ -142,6 +164,9 @@ var _ = Describe("ProfileCatalogSourceController", func() {
 				Data: map[string][]byte{},
 			}
 			Expect(k8sClient.Create(ctx, secret)).Should(Succeed())
+		})
+
+		JustBeforeEach(func() {
 			Expect(k8sClient.Create(ctx, catalogSource)).Should(Succeed())
 		})
 
@@ -153,11 +178,18 @@ var _ = Describe("ProfileCatalogSourceController", func() {
 		It("scans the repository", func() {
 			By("searching for a profile")
 			query := func() []profilesv1.ProfileCatalogEntry {
-				return catalogReconciler.Profiles.Search("foo")
+				return catalogReconciler.Profiles.List("catalog-2")
 			}
 			Eventually(query, 2*time.Second).Should(ContainElement(profilesv1.ProfileCatalogEntry{Name: "foo", CatalogSource: "catalog-2"}))
+			Eventually(scannedRepositories, 2*time.Second).ShouldNot(BeEmpty())
+
+			By("not rescanning before the interval has passed")
+			Consistently(func() int {
+				return fakeRepoScanner.ScanRepositoryCallCount()
+			}).Should(Equal(1))
 
-			By("only searching for new tags")
+			By("only searching for new tags when a rescan is requested")
+			requestRescan()
 			Eventually(func() int {
 				return fakeRepoScanner.ScanRepositoryCallCount()
 			}).Should(Equal(2))
@@ -169,46 +201,162 @@ var _ = Describe("ProfileCatalogSourceController", func() {
 			repo, secret, tags = fakeRepoScanner.ScanRepositoryArgsForCall(1)
 			Expect(repo).To(Equal(profilesv1.Repository{URL: "github.com/weaveworks/profiles-examples", SecretRef: &meta.LocalObjectReference{Name: "my-secret"}}))
 			Expect(secret.Name).To(Equal("my-secret"))
-			Expect(tags).To(ConsistOf("foo"))
+			Expect(tags).To(Equal(map[string]string{"foo": "1111111"}))
 
-			Expect(k8sClient.Get(context.Background(), client.ObjectKey{Namespace: namespace, Name: "catalog-2"}, catalogSource)).To(Succeed())
-			Expect(catalogSource.Status.ScannedRepositories).To(ConsistOf(
+			Expect(scannedRepositories()).To(ConsistOf(
 				profilesv1.ScannedRepository{
 					URL:  "github.com/weaveworks/profiles-examples",
 					Tags: []string{"foo"},
 				},
 			))
+			Expect(catalogSource.Status.LastScanTime).NotTo(BeNil())
+			Expect(catalogSource.Status.ObservedGeneration).To(Equal(catalogSource.Generation))
 		})
 
 		When("the catalog gets wiped", func() {
+			It("restores the persisted profiles without rescanning the repository", func() {
+				Eventually(scannedRepositories, 2*time.Second).ShouldNot(BeEmpty())
+				Expect(fakeRepoScanner.ScanRepositoryCallCount()).To(Equal(1))
+
+				By("persisting the profiles in a configmap")
+				cm := &corev1.ConfigMap{}
+				Expect(k8sClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: "profile-catalog-catalog-2"}, cm)).To(Succeed())
+				Expect(cm.Data["profiles.json"]).To(MatchJSON(`[{"profiles": [{"name": "foo"}]}]`))
+				Expect(cm.Annotations).To(HaveKey("weave.works/profile-catalog-hash"))
+				Expect(metav1.IsControlledBy(cm, catalogSource)).To(BeTrue())
+
+				By("not rewriting the configmap when the profiles did not change")
+				requestRescan()
+				Eventually(func() int {
+					return fakeRepoScanner.ScanRepositoryCallCount()
+				}, 2*time.Second).Should(Equal(2))
+				Consistently(func() string {
+					latest := &corev1.ConfigMap{}
+					Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(cm), latest)).To(Succeed())
+					return latest.ResourceVersion
+				}).Should(Equal(cm.ResourceVersion))
+
+				By("restoring the catalog at startup")
+				catalogReconciler.Profiles.Remove("catalog-2")
+				Expect(catalogReconciler.RestoreCatalogs(ctx)).To(Succeed())
+				Expect(catalogReconciler.Profiles.List("catalog-2")).To(ConsistOf(profilesv1.ProfileCatalogEntry{Name: "foo", CatalogSource: "catalog-2"}))
+
+				By("serving the status of the restored catalog source")
+				Expect(k8sClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: "catalog-2"}, catalogSource)).To(Succeed())
+				source, ok := catalogReconciler.Profiles.GetSource("catalog-2")
+				Expect(ok).To(BeTrue())
+				Expect(source.Status.LastScanTime).NotTo(BeNil())
+				Expect(source.Status.LastScanTime.Equal(catalogSource.Status.LastScanTime)).To(BeTrue())
+				Expect(source.Status.ScannedRepositories).To(HaveLen(1))
+				Expect(source.Status.ScannedRepositories[0].URL).To(Equal("github.com/weaveworks/profiles-examples"))
+				Expect(apimeta.IsStatusConditionTrue(source.Status.Conditions, profilesv1.ConditionTypeReady)).To(BeTrue())
+
+				By("restoring the catalog when reconciling")
+				catalogReconciler.Profiles.Remove("catalog-2")
+				//force a reconciliation loop
+				catalogSource.Labels = map[string]string{"some": "label"}
+				Expect(k8sClient.Update(ctx, catalogSource)).Should(Succeed())
+				Eventually(func() []profilesv1.ProfileCatalogEntry {
+					return catalogReconciler.Profiles.List("catalog-2")
+				}, 2*time.Second).Should(ConsistOf(profilesv1.ProfileCatalogEntry{Name: "foo", CatalogSource: "catalog-2"}))
+				Consistently(func() int {
+					return fakeRepoScanner.ScanRepositoryCallCount()
+				}).Should(Equal(2))
+			})
+		})
+
+		When("the catalog is too large to be persisted", func() {
+			BeforeEach(func() {
+				catalogReconciler.SetMaxPersistedSize(10)
+			})
+
+			AfterEach(func() {
+				catalogReconciler.SetMaxPersistedSize(1024 * 1024)
+			})
+
+			It("reports it in the conditions and events without failing the reconcile", func() {
+				Eventually(func() *metav1.Condition {
+					Expect(k8sClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: "catalog-2"}, catalogSource)).To(Succeed())
+					return apimeta.FindStatusCondition(catalogSource.Status.Conditions, profilesv1.ConditionTypePersisted)
+				}, 2*time.Second).Should(PointTo(MatchFields(IgnoreExtras, Fields{
+					"Status": Equal(metav1.ConditionFalse),
+					"Reason": Equal(profilesv1.ReasonCatalogTooLarge),
+				})))
+				Expect(apimeta.IsStatusConditionTrue(catalogSource.Status.Conditions, profilesv1.ConditionTypeReady)).To(BeTrue())
+				Expect(catalogReconciler.Profiles.List("catalog-2")).To(ConsistOf(profilesv1.ProfileCatalogEntry{Name: "foo", CatalogSource: "catalog-2"}))
+
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: "profile-catalog-catalog-2"}, &corev1.ConfigMap{})
+				Expect(apierrors.IsNotFound(err)).To(BeTrue())
+
+				Eventually(func() []string {
+					events := &v1.EventList{}
+					Expect(k8sClient.List(ctx, events, client.InNamespace(namespace))).To(Succeed())
+					var reasons []string
+					for _, event := range events.Items {
+						reasons = append(reasons, event.Reason)
+					}
+					return reasons
+				}, 2*time.Second).Should(ContainElement(profilesv1.ReasonCatalogTooLarge))
+			})
+		})
+
+		When("the persisted catalog grows too large", func() {
+			AfterEach(func() {
+				catalogReconciler.SetMaxPersistedSize(1024 * 1024)
+			})
+
+			It("deletes the outdated configmap", func() {
+				cm := &corev1.ConfigMap{}
+				Eventually(func() error {
+					return k8sClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: "profile-catalog-catalog-2"}, cm)
+				}, 2*time.Second).Should(Succeed())
+
+				catalogReconciler.SetMaxPersistedSize(10)
+				requestRescan()
+
+				Eventually(func() bool {
+					err := k8sClient.Get(ctx, client.ObjectKeyFromObject(cm), &corev1.ConfigMap{})
+					return apierrors.IsNotFound(err)
+				}, 2*time.Second).Should(BeTrue())
+				Expect(k8sClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: "catalog-2"}, catalogSource)).To(Succeed())
+				Expect(apimeta.IsStatusConditionFalse(catalogSource.Status.Conditions, profilesv1.ConditionTypePersisted)).To(BeTrue())
+			})
+		})
+
+		When("the catalog and the persisted profiles get wiped", func() {
 			It("re-scans the repository, resetting the tags on the status", func() {
 				By("searching for a profile")
 				query := func() []profilesv1.ProfileCatalogEntry {
-					return catalogReconciler.Profiles.Search("foo")
+					return catalogReconciler.Profiles.List("catalog-2")
 				}
 				Eventually(query, 2*time.Second).Should(ContainElement(profilesv1.ProfileCatalogEntry{Name: "foo", CatalogSource: "catalog-2"}))
 
-				Eventually(func() []profilesv1.ScannedRepository {
-					Expect(k8sClient.Get(context.Background(), client.ObjectKey{Namespace: namespace, Name: "catalog-2"}, catalogSource)).To(Succeed())
-					return catalogSource.Status.ScannedRepositories
-				}, 2*time.Second).Should(ConsistOf(
+				Eventually(scannedRepositories, 2*time.Second).Should(ConsistOf(
 					profilesv1.ScannedRepository{
 						URL:  "github.com/weaveworks/profiles-examples",
 						Tags: []string{"foo"},
 					},
 				))
-				Expect(fakeRepoScanner.ScanRepositoryCallCount()).To(Equal(2))
+				Expect(fakeRepoScanner.ScanRepositoryCallCount()).To(Equal(1))
 
 				By("rescanning the repository when the catalog gets reset")
-				fakeRepoScanner.ScanRepositoryReturnsOnCall(2, []profilesv1.ProfileCatalogEntry{
+				fakeRepoScanner.ScanRepositoryReturnsOnCall(1, []profilesv1.ProfileCatalogEntry{
 					{
 						Name: "bar",
 					},
 					{
 						Name: "baz",
 					},
-				}, []string{"bar", "baz"}, nil)
+				}, map[string]string{"bar": "2222222", "baz": "3333333"}, nil)
+				fakeRepoScanner.ScanRepositoryReturns(nil, map[string]string{"bar": "2222222", "baz": "3333333"}, nil)
 
+				Expect(k8sClient.Delete(ctx, &corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "profile-catalog-catalog-2"},
+				})).To(Succeed())
+				Eventually(func() bool {
+					err := k8sClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: "profile-catalog-catalog-2"}, &corev1.ConfigMap{})
+					return apierrors.IsNotFound(err)
+				}, 2*time.Second).Should(BeTrue())
 				catalogReconciler.Profiles.Remove("catalog-2")
 				//force a reconciliation loop
 				catalogSource.Labels = map[string]string{"some": "label"}
@@ -216,35 +364,403 @@ var _ = Describe("ProfileCatalogSourceController", func() {
 
 				Eventually(func() int {
 					return fakeRepoScanner.ScanRepositoryCallCount()
-				}, time.Second*2).Should(Equal(4))
-				repo, secret, tags := fakeRepoScanner.ScanRepositoryArgsForCall(2)
+				}, time.Second*2).Should(Equal(2))
+				repo, secret, tags := fakeRepoScanner.ScanRepositoryArgsForCall(1)
 				Expect(repo).To(Equal(profilesv1.Repository{URL: "github.com/weaveworks/profiles-examples", SecretRef: &meta.LocalObjectReference{Name: "my-secret"}}))
 				Expect(secret.Name).To(Equal("my-secret"))
 				Expect(tags).To(BeNil())
 
 				query = func() []profilesv1.ProfileCatalogEntry {
-					return catalogReconciler.Profiles.Search("baz")
+					return catalogReconciler.Profiles.List("catalog-2")
 				}
 				Eventually(query, 2*time.Second).Should(ContainElement(profilesv1.ProfileCatalogEntry{Name: "baz", CatalogSource: "catalog-2"}))
-				Eventually(func() []profilesv1.ScannedRepository {
-					Expect(k8sClient.Get(context.Background(), client.ObjectKey{Namespace: namespace, Name: "catalog-2"}, catalogSource)).To(Succeed())
-					return catalogSource.Status.ScannedRepositories
-				}, 2*time.Second).Should(ConsistOf(
+				Eventually(scannedRepositories, 2*time.Second).Should(ConsistOf(
 					profilesv1.ScannedRepository{
 						URL:  "github.com/weaveworks/profiles-examples",
 						Tags: []string{"bar", "baz"},
 					},
 				))
 
+				By("only searching for new tags when a rescan is requested")
+				requestRescan()
+				Eventually(func() int {
+					return fakeRepoScanner.ScanRepositoryCallCount()
+				}, time.Second*2).Should(Equal(3))
 				repo, secret, tags = fakeRepoScanner.ScanRepositoryArgsForCall(2)
 				Expect(repo).To(Equal(profilesv1.Repository{URL: "github.com/weaveworks/profiles-examples", SecretRef: &meta.LocalObjectReference{Name: "my-secret"}}))
 				Expect(secret.Name).To(Equal("my-secret"))
-				Expect(tags).To(BeNil())
+				Expect(tags).To(Equal(map[string]string{"bar": "2222222", "baz": "3333333"}))
+			})
+		})
 
-				repo, secret, tags = fakeRepoScanner.ScanRepositoryArgsForCall(3)
-				Expect(repo).To(Equal(profilesv1.Repository{URL: "github.com/weaveworks/profiles-examples", SecretRef: &meta.LocalObjectReference{Name: "my-secret"}}))
-				Expect(secret.Name).To(Equal("my-secret"))
-				Expect(tags).To(ConsistOf("bar", "baz"))
+		When("the repository cannot be scanned", func() {
+			BeforeEach(func() {
+				fakeRepoScanner.ScanRepositoryReturnsOnCall(0, nil, nil, fmt.Errorf("authentication required"))
+				fakeRepoScanner.ScanRepositoryReturns(nil, nil, fmt.Errorf("authentication required"))
+			})
+
+			It("reports the failure in the conditions and events", func() {
+				condition := func(conditions func() []metav1.Condition, conditionType string) func() metav1.ConditionStatus {
+					return func() metav1.ConditionStatus {
+						Expect(k8sClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: "catalog-2"}, catalogSource)).To(Succeed())
+						if c := apimeta.FindStatusCondition(conditions(), conditionType); c != nil {
+							return c.Status
+						}
+						return ""
+					}
+				}
+				sourceConditions := func() []metav1.Condition { return catalogSource.Status.Conditions }
+				repoConditions := func() []metav1.Condition {
+					if len(catalogSource.Status.ScannedRepositories) == 0 {
+						return nil
+					}
+					return catalogSource.Status.ScannedRepositories[0].Conditions
+				}
+
+				Eventually(condition(sourceConditions, profilesv1.ConditionTypeFailed), 2*time.Second).Should(Equal(metav1.ConditionTrue))
+				Expect(condition(sourceConditions, profilesv1.ConditionTypeReady)()).To(Equal(metav1.ConditionFalse))
+				Expect(apimeta.FindStatusCondition(catalogSource.Status.Conditions, profilesv1.ConditionTypeReady).Message).To(
+					Equal("1 of 1 repositories failed to scan: github.com/weaveworks/profiles-examples"))
+
+				Expect(condition(repoConditions, profilesv1.ConditionTypeFailed)()).To(Equal(metav1.ConditionTrue))
+				Expect(condition(repoConditions, profilesv1.ConditionTypeScanning)()).To(Equal(metav1.ConditionFalse))
+				Expect(apimeta.FindStatusCondition(repoConditions(), profilesv1.ConditionTypeReady).Message).To(Equal("authentication required"))
+
+				By("recording the status in the catalog")
+				Eventually(func() []metav1.Condition {
+					source, _ := profiles.GetSource("catalog-2")
+					return source.Status.Conditions
+				}, 2*time.Second).Should(ContainElement(MatchFields(IgnoreExtras, Fields{
+					"Type":   Equal(profilesv1.ConditionTypeFailed),
+					"Status": Equal(metav1.ConditionTrue),
+				})))
+
+				Eventually(func() []string {
+					events := &v1.EventList{}
+					Expect(k8sClient.List(ctx, events, client.InNamespace(namespace))).To(Succeed())
+					var messages []string
+					for _, event := range events.Items {
+						if event.Reason == profilesv1.ReasonScanFailed {
+							messages = append(messages, event.Message)
+						}
+					}
+					return messages
+				}, 2*time.Second).Should(ContainElement("failed to scan repository github.com/weaveworks/profiles-examples: authentication required"))
+			})
+		})
+
+		When("one of the repositories cannot be scanned", func() {
+			BeforeEach(func() {
+				catalogSource.Spec.Repos = append(catalogSource.Spec.Repos, profilesv1.Repository{URL: "github.com/weaveworks/broken"})
+				fakeRepoScanner.ScanRepositoryStub = func(repo profilesv1.Repository, secret *corev1.Secret, tags map[string]string) ([]profilesv1.ProfileCatalogEntry, map[string]string, error) {
+					if repo.URL == "github.com/weaveworks/broken" {
+						return nil, nil, fmt.Errorf("authentication required")
+					}
+					return []profilesv1.ProfileCatalogEntry{{Name: "foo"}}, map[string]string{"foo": ""}, nil
+				}
+			})
+
+			It("keeps the profiles of the other repositories", func() {
+				Eventually(func() []profilesv1.ProfileCatalogEntry {
+					return catalogReconciler.Profiles.List("catalog-2")
+				}, 2*time.Second).Should(ConsistOf(profilesv1.ProfileCatalogEntry{Name: "foo", CatalogSource: "catalog-2"}))
+
+				Eventually(func() []string {
+					Expect(k8sClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: "catalog-2"}, catalogSource)).To(Succeed())
+					var failed []string
+					for _, repo := range catalogSource.Status.ScannedRepositories {
+						if apimeta.IsStatusConditionTrue(repo.Conditions, profilesv1.ConditionTypeFailed) {
+							failed = append(failed, repo.URL)
+						}
+					}
+					return failed
+				}, 2*time.Second).Should(ConsistOf("github.com/weaveworks/broken"))
+			})
+		})
+
+		When("two repositories publish the same profile version", func() {
+			BeforeEach(func() {
+				catalogSource.Spec.Repos = append(catalogSource.Spec.Repos, profilesv1.Repository{URL: "github.com/weaveworks/fork"})
+				fakeRepoScanner.ScanRepositoryStub = func(repo profilesv1.Repository, secret *corev1.Secret, tags map[string]string) ([]profilesv1.ProfileCatalogEntry, map[string]string, error) {
+					if repo.URL == "github.com/weaveworks/fork" {
+						return []profilesv1.ProfileCatalogEntry{{Name: "foo", Tag: "0.1.0", URL: repo.URL}}, map[string]string{"0.1.0": ""}, nil
+					}
+					return []profilesv1.ProfileCatalogEntry{{Name: "foo", Tag: "v0.1.0", URL: repo.URL}}, map[string]string{"v0.1.0": ""}, nil
+				}
+			})
+
+			It("keeps the existing profile and does not record the tag of the dropped profile as scanned", func() {
+				Eventually(scannedRepositories, 2*time.Second).Should(ConsistOf(
+					profilesv1.ScannedRepository{URL: "github.com/weaveworks/profiles-examples", Tags: []string{"v0.1.0"}},
+					profilesv1.ScannedRepository{URL: "github.com/weaveworks/fork"},
+				))
+				Expect(catalogReconciler.Profiles.List("catalog-2")).To(ConsistOf(
+					profilesv1.ProfileCatalogEntry{Name: "foo", Tag: "v0.1.0", URL: "github.com/weaveworks/profiles-examples", CatalogSource: "catalog-2"},
+				))
+			})
+		})
+
+		When("some tags of the repository cannot be scanned", func() {
+			BeforeEach(func() {
+				catalogSource.Spec.Repos[0].Interval = &metav1.Duration{Duration: time.Second}
+				fakeRepoScanner.ScanRepositoryReturnsOnCall(0, []profilesv1.ProfileCatalogEntry{{Name: "foo"}}, map[string]string{"foo": ""}, &scanner.PartialScanError{
+					TagErrors: []scanner.TagError{{Tag: "bar", Err: fmt.Errorf("request failed status code 503")}},
+				})
+				fakeRepoScanner.ScanRepositoryReturnsOnCall(1, []profilesv1.ProfileCatalogEntry{{Name: "bar"}}, map[string]string{"foo": "", "bar": ""}, nil)
+				fakeRepoScanner.ScanRepositoryReturns(nil, map[string]string{"foo": "", "bar": ""}, nil)
+			})
+
+			It("adds the profiles of the other tags and retries the failed tags", func() {
+				Eventually(func() []profilesv1.ProfileCatalogEntry {
+					return catalogReconciler.Profiles.List("catalog-2")
+				}, 2*time.Second).Should(ContainElement(profilesv1.ProfileCatalogEntry{Name: "foo", CatalogSource: "catalog-2"}))
+
+				By("recording the failed tags in the status")
+				Eventually(func() []profilesv1.FailedTag {
+					Expect(k8sClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: "catalog-2"}, catalogSource)).To(Succeed())
+					if len(catalogSource.Status.ScannedRepositories) == 0 {
+						return nil
+					}
+					return catalogSource.Status.ScannedRepositories[0].FailedTags
+				}, 2*time.Second).Should(ConsistOf(
+					MatchFields(IgnoreExtras, Fields{
+						"Tag":      Equal("bar"),
+						"Message":  Equal("request failed status code 503"),
+						"Attempts": Equal(1),
+					}),
+				))
+				Expect(catalogSource.Status.ScannedRepositories[0].Tags).To(ConsistOf("foo"))
+				Expect(apimeta.IsStatusConditionTrue(catalogSource.Status.ScannedRepositories[0].Conditions, profilesv1.ConditionTypeFailed)).To(BeTrue())
+
+				By("retrying the failed tags")
+				Eventually(func() []profilesv1.ProfileCatalogEntry {
+					return catalogReconciler.Profiles.List("catalog-2")
+				}, 5*time.Second).Should(ContainElement(profilesv1.ProfileCatalogEntry{Name: "bar", CatalogSource: "catalog-2"}))
+				_, _, tags := fakeRepoScanner.ScanRepositoryArgsForCall(1)
+				Expect(tags).To(Equal(map[string]string{"foo": ""}))
+
+				Eventually(func() []profilesv1.FailedTag {
+					Expect(k8sClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: "catalog-2"}, catalogSource)).To(Succeed())
+					return catalogSource.Status.ScannedRepositories[0].FailedTags
+				}, 2*time.Second).Should(BeEmpty())
+				Expect(catalogSource.Status.ScannedRepositories[0].Tags).To(ConsistOf("foo", "bar"))
+			})
+		})
+
+		When("the profile definition of a tag is invalid", func() {
+			BeforeEach(func() {
+				catalogSource.Spec.Repos[0].Interval = &metav1.Duration{Duration: time.Second}
+				fakeRepoScanner.ScanRepositoryReturnsOnCall(0, []profilesv1.ProfileCatalogEntry{{Name: "foo"}}, map[string]string{"foo": "", "bar": ""}, &scanner.PartialScanError{
+					TagErrors: []scanner.TagError{{Tag: "bar", Err: &scanner.InvalidProfileError{Err: fmt.Errorf("invalid profile.yaml")}}},
+				})
+				fakeRepoScanner.ScanRepositoryReturns(nil, map[string]string{"foo": "", "bar": ""}, nil)
+			})
+
+			It("records the tag as invalid without retrying it or failing the source", func() {
+				Eventually(func() []profilesv1.InvalidTag {
+					Expect(k8sClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: "catalog-2"}, catalogSource)).To(Succeed())
+					if len(catalogSource.Status.ScannedRepositories) == 0 {
+						return nil
+					}
+					return catalogSource.Status.ScannedRepositories[0].InvalidTags
+				}, 2*time.Second).Should(ConsistOf(profilesv1.InvalidTag{Tag: "bar", Message: "invalid profile.yaml"}))
+				scannedRepo := catalogSource.Status.ScannedRepositories[0]
+				Expect(scannedRepo.Tags).To(ConsistOf("foo", "bar"))
+				Expect(scannedRepo.FailedTags).To(BeEmpty())
+				Expect(apimeta.IsStatusConditionTrue(scannedRepo.Conditions, profilesv1.ConditionTypeReady)).To(BeTrue())
+				Expect(apimeta.IsStatusConditionTrue(catalogSource.Status.Conditions, profilesv1.ConditionTypeReady)).To(BeTrue())
+
+				By("not scanning the invalid tag again")
+				Eventually(fakeRepoScanner.ScanRepositoryCallCount, 5*time.Second).Should(BeNumerically(">=", 2))
+				_, _, tags := fakeRepoScanner.ScanRepositoryArgsForCall(1)
+				Expect(tags).To(Equal(map[string]string{"foo": "", "bar": ""}))
+				Expect(k8sClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: "catalog-2"}, catalogSource)).To(Succeed())
+				Expect(catalogSource.Status.ScannedRepositories[0].InvalidTags).To(ConsistOf(profilesv1.InvalidTag{Tag: "bar", Message: "invalid profile.yaml"}))
+			})
+		})
+
+		When("the head of a tracked branch moves", func() {
+			BeforeEach(func() {
+				catalogSource.Spec.Repos[0].Branches = []profilesv1.TrackedBranch{{Name: "main"}}
+				branchProfile := func(commit string) profilesv1.ProfileCatalogEntry {
+					return profilesv1.ProfileCatalogEntry{
+						Name:       "foo",
+						URL:        "github.com/weaveworks/profiles-examples",
+						Tag:        profilesv1.BranchTag("", "main", commit),
+						TagPattern: profilesv1.BranchTagPattern,
+						Branch:     "main",
+					}
+				}
+				fakeRepoScanner.ScanRepositoryReturnsOnCall(0, []profilesv1.ProfileCatalogEntry{branchProfile("1a1a1a1")}, map[string]string{"0.0.0-main.1a1a1a1": ""}, nil)
+				fakeRepoScanner.ScanRepositoryReturnsOnCall(1, []profilesv1.ProfileCatalogEntry{branchProfile("2b2b2b2")}, map[string]string{"0.0.0-main.2b2b2b2": ""}, nil)
+				fakeRepoScanner.ScanRepositoryReturns(nil, map[string]string{"0.0.0-main.2b2b2b2": ""}, nil)
+			})
+
+			It("replaces the profile of the previous head", func() {
+				Eventually(scannedRepositories, 2*time.Second).Should(ConsistOf(
+					profilesv1.ScannedRepository{URL: "github.com/weaveworks/profiles-examples", Tags: []string{"0.0.0-main.1a1a1a1"}},
+				))
+
+				requestRescan()
+				Eventually(scannedRepositories, 2*time.Second).Should(ConsistOf(
+					profilesv1.ScannedRepository{URL: "github.com/weaveworks/profiles-examples", Tags: []string{"0.0.0-main.2b2b2b2"}},
+				))
+				_, _, tags := fakeRepoScanner.ScanRepositoryArgsForCall(1)
+				Expect(tags).To(Equal(map[string]string{"0.0.0-main.1a1a1a1": ""}))
+
+				profiles := catalogReconciler.Profiles.List("catalog-2")
+				Expect(profiles).To(HaveLen(1))
+				Expect(profiles[0].Tag).To(Equal("0.0.0-main.2b2b2b2"))
+				Expect(catalogReconciler.Profiles.GetWithVersion(logr.Discard(), "catalog-2", "foo", "0.0.0-main.2b2b2b2")).NotTo(BeNil())
+			})
+		})
+
+		When("the tag filters of the repository change", func() {
+			BeforeEach(func() {
+				fakeRepoScanner.ScanRepositoryReturnsOnCall(0, []profilesv1.ProfileCatalogEntry{
+					{Name: "foo", URL: "github.com/weaveworks/profiles-examples", Tag: "v0.1.0"},
+					{Name: "foo", URL: "github.com/weaveworks/profiles-examples", Tag: "v1.0.0"},
+				}, map[string]string{"v0.1.0": "", "v1.0.0": ""}, nil)
+				fakeRepoScanner.ScanRepositoryReturns(nil, map[string]string{"v1.0.0": ""}, nil)
+			})
+
+			It("removes the profiles of the excluded tags", func() {
+				Eventually(scannedRepositories, 2*time.Second).Should(ConsistOf(
+					profilesv1.ScannedRepository{URL: "github.com/weaveworks/profiles-examples", Tags: []string{"v0.1.0", "v1.0.0"}},
+				))
+
+				catalogSource.Spec.Repos[0].SemverConstraint = ">=1.0.0"
+				Expect(k8sClient.Update(ctx, catalogSource)).To(Succeed())
+				Eventually(scannedRepositories, 2*time.Second).Should(ConsistOf(
+					profilesv1.ScannedRepository{URL: "github.com/weaveworks/profiles-examples", Tags: []string{"v1.0.0"}},
+				))
+				profiles := catalogReconciler.Profiles.List("catalog-2")
+				Expect(profiles).To(HaveLen(1))
+				Expect(profiles[0].Tag).To(Equal("v1.0.0"))
+			})
+		})
+
+		When("tags of the repository are moved or deleted", func() {
+			BeforeEach(func() {
+				fakeRepoScanner.ScanRepositoryReturnsOnCall(0, []profilesv1.ProfileCatalogEntry{
+					{Name: "foo", URL: "github.com/weaveworks/profiles-examples", Tag: "v0.1.0"},
+					{Name: "foo", URL: "github.com/weaveworks/profiles-examples", Tag: "v1.0.0"},
+				}, map[string]string{"v0.1.0": "1111111", "v1.0.0": "2222222"}, nil)
+				fakeRepoScanner.ScanRepositoryReturnsOnCall(1, []profilesv1.ProfileCatalogEntry{
+					{Name: "foo", URL: "github.com/weaveworks/profiles-examples", Tag: "v1.0.0", ProfileDescription: profilesv1.ProfileDescription{Description: "moved"}},
+				}, map[string]string{"v1.0.0": "3333333"}, nil)
+				fakeRepoScanner.ScanRepositoryReturns(nil, map[string]string{"v1.0.0": "3333333"}, nil)
+			})
+
+			It("replaces the profiles of the moved tags and removes the profiles of the deleted tags", func() {
+				Eventually(func() map[string]string {
+					Expect(k8sClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: "catalog-2"}, catalogSource)).To(Succeed())
+					if len(catalogSource.Status.ScannedRepositories) == 0 {
+						return nil
+					}
+					return catalogSource.Status.ScannedRepositories[0].Commits
+				}, 2*time.Second).Should(Equal(map[string]string{"v0.1.0": "1111111", "v1.0.0": "2222222"}))
+
+				requestRescan()
+				Eventually(scannedRepositories, 2*time.Second).Should(ConsistOf(
+					profilesv1.ScannedRepository{URL: "github.com/weaveworks/profiles-examples", Tags: []string{"v1.0.0"}},
+				))
+				_, _, tags := fakeRepoScanner.ScanRepositoryArgsForCall(1)
+				Expect(tags).To(Equal(map[string]string{"v0.1.0": "1111111", "v1.0.0": "2222222"}))
+				Expect(catalogSource.Status.ScannedRepositories[0].Commits).To(Equal(map[string]string{"v1.0.0": "3333333"}))
+
+				profiles := catalogReconciler.Profiles.List("catalog-2")
+				Expect(profiles).To(HaveLen(1))
+				Expect(profiles[0].Tag).To(Equal("v1.0.0"))
+				Expect(profiles[0].Description).To(Equal("moved"))
+			})
+		})
+
+		When("a repository is removed from the spec", func() {
+			BeforeEach(func() {
+				catalogSource.Spec.Repos = append(catalogSource.Spec.Repos, profilesv1.Repository{URL: "github.com/weaveworks/removed"})
+				fakeRepoScanner.ScanRepositoryStub = func(repo profilesv1.Repository, secret *corev1.Secret, tags map[string]string) ([]profilesv1.ProfileCatalogEntry, map[string]string, error) {
+					if tags != nil {
+						return nil, tags, nil
+					}
+					return []profilesv1.ProfileCatalogEntry{{Name: path.Base(repo.URL), URL: repo.URL, Tag: "v0.1.0"}}, map[string]string{"v0.1.0": ""}, nil
+				}
+			})
+
+			It("removes its profiles and status", func() {
+				Eventually(func() []profilesv1.ProfileCatalogEntry {
+					return catalogReconciler.Profiles.List("catalog-2")
+				}, 2*time.Second).Should(HaveLen(2))
+
+				Expect(k8sClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: "catalog-2"}, catalogSource)).To(Succeed())
+				catalogSource.Spec.Repos = catalogSource.Spec.Repos[:1]
+				Expect(k8sClient.Update(ctx, catalogSource)).To(Succeed())
+
+				Eventually(scannedRepositories, 2*time.Second).Should(ConsistOf(
+					profilesv1.ScannedRepository{URL: "github.com/weaveworks/profiles-examples", Tags: []string{"v0.1.0"}},
+				))
+				Expect(catalogReconciler.Profiles.List("catalog-2")).To(ConsistOf(
+					profilesv1.ProfileCatalogEntry{Name: "profiles-examples", URL: "github.com/weaveworks/profiles-examples", Tag: "v0.1.0", CatalogSource: "catalog-2"},
+				))
+
+				By("persisting the remaining profiles")
+				cm := &corev1.ConfigMap{}
+				Expect(k8sClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: "profile-catalog-catalog-2"}, cm)).To(Succeed())
+				Expect(cm.Data["profiles.json"]).NotTo(ContainSubstring("github.com/weaveworks/removed"))
+
+				Eventually(func() []string {
+					events := &v1.EventList{}
+					Expect(k8sClient.List(ctx, events, client.InNamespace(namespace))).To(Succeed())
+					var messages []string
+					for _, event := range events.Items {
+						if event.Reason == profilesv1.ReasonRepositoryRemoved {
+							messages = append(messages, event.Message)
+						}
+					}
+					return messages
+				}, 2*time.Second).Should(ContainElement("removed repository github.com/weaveworks/removed and its 1 profiles from the catalog"))
+			})
+		})
+
+		When("a rescan of one of the repositories is requested", func() {
+			BeforeEach(func() {
+				catalogSource.Spec.Repos = append(catalogSource.Spec.Repos, profilesv1.Repository{URL: "github.com/weaveworks/other"})
+			})
+
+			It("only rescans the requested repository", func() {
+				Eventually(scannedRepositories, 2*time.Second).Should(HaveLen(2))
+				Expect(fakeRepoScanner.ScanRepositoryCallCount()).To(Equal(2))
+
+				Expect(k8sClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: "catalog-2"}, catalogSource)).To(Succeed())
+				profilesv1.RequestRescan(catalogSource, time.Now().Format(time.RFC3339Nano), "github.com/weaveworks/other")
+				Expect(k8sClient.Update(ctx, catalogSource)).To(Succeed())
+
+				Eventually(func() int {
+					return fakeRepoScanner.ScanRepositoryCallCount()
+				}, 2*time.Second).Should(Equal(3))
+				repo, _, _ := fakeRepoScanner.ScanRepositoryArgsForCall(2)
+				Expect(repo.URL).To(Equal("github.com/weaveworks/other"))
+				Consistently(func() int {
+					return fakeRepoScanner.ScanRepositoryCallCount()
+				}).Should(Equal(3))
+
+				By("rescanning all repositories when the request is not limited to some repositories")
+				requestRescan()
+				Eventually(func() int {
+					return fakeRepoScanner.ScanRepositoryCallCount()
+				}, 2*time.Second).Should(Equal(5))
+			})
+		})
+
+		When("the interval has passed", func() {
+			It("rescans the repository", func() {
+				Eventually(scannedRepositories, 2*time.Second).ShouldNot(BeEmpty())
+				catalogSource.Spec.Repos[0].Interval = &metav1.Duration{Duration: time.Second}
+				Expect(k8sClient.Update(ctx, catalogSource)).To(Succeed())
+
+				Eventually(func() int {
+					return fakeRepoScanner.ScanRepositoryCallCount()
+				}, 5*time.Second).Should(BeNumerically(">=", 3))
 			})
 		})
 	})
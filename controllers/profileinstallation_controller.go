@@ -0,0 +1,537 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2beta1"
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	profilesv1 "github.com/weaveworks/profiles/api/v1alpha1"
+	"github.com/weaveworks/profiles/pkg/catalog"
+	"github.com/weaveworks/profiles/pkg/installation"
+)
+
+// installationLabel labels the resources created for a ProfileInstallation with its name.
+const installationLabel = "weave.works/profile-installation"
+
+// ProfileInstallationReconciler reconciles a ProfileInstallation object
+type ProfileInstallationReconciler struct {
+	client.Client
+	log      logr.Logger
+	s        *runtime.Scheme
+	Profiles *catalog.Catalog
+	fetcher  installation.Fetcher
+	interval time.Duration
+}
+
+func NewProfileInstallationReconciler(c client.Client, log logr.Logger, scheme *runtime.Scheme, profiles *catalog.Catalog) *ProfileInstallationReconciler {
+	return &ProfileInstallationReconciler{
+		Client:   c,
+		log:      log,
+		s:        scheme,
+		Profiles: profiles,
+		fetcher:  installation.NewFetcher(http.DefaultClient),
+		interval: time.Second * 10,
+	}
+}
+
+// +kubebuilder:rbac:groups=weave.works,resources=profileinstallations,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=weave.works,resources=profileinstallations/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=weave.works,resources=profileinstallations/finalizers,verbs=update
+
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+
+// +kubebuilder:rbac:groups=source.toolkit.fluxcd.io,resources=helmrepositories,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=helm.toolkit.fluxcd.io,resources=helmreleases,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=kustomize.toolkit.fluxcd.io,resources=kustomizations,verbs=get;list;watch;create;update;patch;delete
+
+// Reconcile fetches the ProfileDefinition referenced by a ProfileInstallation
+// and creates the Flux resources required to deploy its artifacts.
+func (r *ProfileInstallationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := r.log.WithValues("profileinstallation", req.NamespacedName)
+
+	pi := profilesv1.ProfileInstallation{}
+	err := r.Client.Get(ctx, req.NamespacedName, &pi)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			logger.Info("resource has been deleted")
+			return ctrl.Result{}, nil
+		}
+		logger.Error(err, "failed to get resource")
+		return ctrl.Result{}, err
+	}
+
+	profileSource, err := r.resolveSource(logger, pi)
+	if err != nil {
+		return ctrl.Result{}, r.setFailed(ctx, pi, err)
+	}
+
+	gitRepo, source, err := r.reconcileSource(ctx, pi, profileSource)
+	if err != nil {
+		return ctrl.Result{}, r.setFailed(ctx, pi, err)
+	}
+
+	if gitRepo.Status.Artifact == nil {
+		logger.Info("waiting for source artifact", "gitrepository", client.ObjectKeyFromObject(gitRepo))
+		msg := fmt.Sprintf("waiting for gitrepository %s/%s to produce an artifact", gitRepo.Namespace, gitRepo.Name)
+		return ctrl.Result{RequeueAfter: r.interval}, r.setCondition(ctx, pi, metav1.ConditionFalse, profilesv1.ReasonSourceNotReady, msg)
+	}
+
+	def, err := r.fetcher.Fetch(gitRepo.Status.Artifact.URL, source.Path, source.ProfileFilename)
+	if err != nil {
+		return ctrl.Result{}, r.setFailed(ctx, pi, fmt.Errorf("failed to fetch profile definition: %w", err))
+	}
+
+	loader := &nestedProfileLoader{ctx: ctx, r: r, pi: pi}
+	profileArtifacts, nested, err := installation.Expand(*def, source, loader, installation.DefaultMaxDepth)
+	if errors.Is(err, installation.ErrSourceNotReady) {
+		logger.Info("waiting for nested profile sources", "error", err.Error())
+		return ctrl.Result{RequeueAfter: r.interval}, r.updateStatus(ctx, pi, func(status *profilesv1.ProfileInstallationStatus) {
+			status.NestedProfiles = nested
+			setReadyCondition(status, pi, metav1.ConditionFalse, profilesv1.ReasonSourceNotReady, err.Error())
+		})
+	}
+	if err != nil {
+		if statusErr := r.updateStatus(ctx, pi, func(status *profilesv1.ProfileInstallationStatus) {
+			status.NestedProfiles = nested
+		}); statusErr != nil {
+			return ctrl.Result{}, statusErr
+		}
+		return ctrl.Result{}, r.setFailed(ctx, pi, fmt.Errorf("failed to expand nested profiles: %w", err))
+	}
+
+	values, err := r.getValues(ctx, pi)
+	if err != nil {
+		return ctrl.Result{}, r.setValuesFailed(ctx, pi, err)
+	}
+
+	artifacts, err := installation.MakeArtifacts(pi, profileArtifacts, values)
+	var valuesErr *installation.ValuesError
+	if errors.As(err, &valuesErr) {
+		return ctrl.Result{}, r.setValuesFailed(ctx, pi, err)
+	}
+	if err != nil {
+		return ctrl.Result{}, r.setFailed(ctx, pi, fmt.Errorf("failed to make artifacts: %w", err))
+	}
+
+	for _, artifact := range artifacts {
+		if err := r.createOrUpdate(ctx, pi, artifact); err != nil {
+			return ctrl.Result{}, r.setFailed(ctx, pi, err)
+		}
+	}
+
+	desired := append(loader.created, artifacts...)
+	if metav1.IsControlledBy(gitRepo, &pi) {
+		desired = append(desired, gitRepo)
+	}
+	pruned, err := r.prune(ctx, pi, desired)
+	if err != nil {
+		return ctrl.Result{}, r.setFailed(ctx, pi, err)
+	}
+
+	logger.Info("artifacts created", "profile", def.Name, "count", len(artifacts), "pruned", pruned)
+	return ctrl.Result{}, r.updateStatus(ctx, pi, func(status *profilesv1.ProfileInstallationStatus) {
+		status.Source = source.Resolved()
+		status.NestedProfiles = nested
+		setStatusCondition(status, pi, profilesv1.ConditionTypeValuesReady, metav1.ConditionTrue, profilesv1.ReasonValuesMerged, "values merged")
+		setReadyCondition(status, pi, metav1.ConditionTrue, profilesv1.ReasonArtifactsCreated, fmt.Sprintf("created %d resources for profile %s", len(artifacts), def.Name))
+	})
+}
+
+// getValues returns the ConfigMap and Secret holding the helm values of the installation.
+func (r *ProfileInstallationReconciler) getValues(ctx context.Context, pi profilesv1.ProfileInstallation) (installation.Values, error) {
+	var values installation.Values
+	if pi.Spec.ConfigMap != "" {
+		configMap := &corev1.ConfigMap{}
+		if err := r.Client.Get(ctx, client.ObjectKey{Name: pi.Spec.ConfigMap, Namespace: pi.Namespace}, configMap); err != nil {
+			return values, fmt.Errorf("failed to get values configmap %s: %w", pi.Spec.ConfigMap, err)
+		}
+		values.ConfigMap = configMap
+	}
+	if pi.Spec.Secret != "" {
+		secret := &corev1.Secret{}
+		if err := r.Client.Get(ctx, client.ObjectKey{Name: pi.Spec.Secret, Namespace: pi.Namespace}, secret); err != nil {
+			return values, fmt.Errorf("failed to get values secret %s: %w", pi.Spec.Secret, err)
+		}
+		values.Secret = secret
+	}
+	return values, nil
+}
+
+// resolveSource returns the location of the profile, looking it up in the catalog
+// when the installation references a catalog entry instead of a source.
+func (r *ProfileInstallationReconciler) resolveSource(logger logr.Logger, pi profilesv1.ProfileInstallation) (*profilesv1.Source, error) {
+	if pi.Spec.Catalog == nil {
+		if pi.Spec.Source == nil {
+			return &profilesv1.Source{}, nil
+		}
+		return pi.Spec.Source, nil
+	}
+
+	c := pi.Spec.Catalog
+	if c.Catalog == "" || c.Profile == "" || c.Version == "" {
+		return nil, fmt.Errorf("spec.catalog must set catalog, profile and version")
+	}
+	entry := r.Profiles.GetWithVersion(logger, c.Catalog, c.Profile, c.Version)
+	if entry == nil {
+		return nil, fmt.Errorf("profile %q with version %q not found in catalog %q", c.Profile, c.Version, c.Catalog)
+	}
+	logger.Info("resolved catalog entry", "catalog", c.Catalog, "profile", c.Profile, "version", c.Version, "url", entry.URL, "tag", entry.Tag)
+
+	path := profilesv1.GetPathFromTag(entry.Tag, entry.TagPattern)
+	if pi.Spec.Source != nil && pi.Spec.Source.Path != "" {
+		path = pi.Spec.Source.Path
+	}
+	// the tag of a profile read from a tracked branch is its version, the scanned commit of the
+	// branch is installed instead
+	if entry.Branch != "" {
+		return &profilesv1.Source{
+			URL:             entry.URL,
+			Branch:          entry.Branch,
+			Commit:          entry.Commit,
+			Path:            path,
+			ProfileFilename: entry.ProfileFilename,
+		}, nil
+	}
+	return &profilesv1.Source{
+		URL:             entry.URL,
+		Tag:             entry.Tag,
+		Path:            path,
+		ProfileFilename: entry.ProfileFilename,
+	}, nil
+}
+
+// reconcileSource returns the GitRepository containing the profile. When the installation
+// does not reference an existing GitRepository one is created for the profile source.
+func (r *ProfileInstallationReconciler) reconcileSource(ctx context.Context, pi profilesv1.ProfileInstallation, profileSource *profilesv1.Source) (*sourcev1.GitRepository, installation.Source, error) {
+	if pi.Spec.GitRepository != nil && pi.Spec.GitRepository.Name != "" {
+		namespace := pi.Spec.GitRepository.Namespace
+		if namespace == "" {
+			namespace = pi.Namespace
+		}
+		gitRepo := &sourcev1.GitRepository{}
+		if err := r.Client.Get(ctx, client.ObjectKey{Name: pi.Spec.GitRepository.Name, Namespace: namespace}, gitRepo); err != nil {
+			return nil, installation.Source{}, fmt.Errorf("failed to get gitrepository %s/%s: %w", namespace, pi.Spec.GitRepository.Name, err)
+		}
+		return gitRepo, makeSource(gitRepo, profileSource), nil
+	}
+
+	if profileSource.URL == "" {
+		return nil, installation.Source{}, fmt.Errorf("one of spec.source.url, spec.catalog or spec.gitRepository must be set")
+	}
+
+	gitRepo := makeGitRepository(pi, pi.Name, profileSource)
+	if err := r.createOrUpdate(ctx, pi, gitRepo); err != nil {
+		return nil, installation.Source{}, err
+	}
+	return gitRepo, makeSource(gitRepo, profileSource), nil
+}
+
+func makeGitRepository(pi profilesv1.ProfileInstallation, name string, profileSource *profilesv1.Source) *sourcev1.GitRepository {
+	ref := &sourcev1.GitRepositoryRef{Branch: profileSource.Branch, Commit: profileSource.Commit}
+	if profileSource.Tag != "" {
+		ref = &sourcev1.GitRepositoryRef{Tag: profileSource.Tag}
+	}
+	return &sourcev1.GitRepository{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: pi.Namespace,
+		},
+		TypeMeta: metav1.TypeMeta{
+			Kind:       sourcev1.GitRepositoryKind,
+			APIVersion: sourcev1.GroupVersion.String(),
+		},
+		Spec: sourcev1.GitRepositorySpec{
+			URL:       profileSource.URL,
+			Reference: ref,
+			Interval:  metav1.Duration{Duration: time.Minute * 5},
+		},
+	}
+}
+
+// makeSource returns the installation source for the profile located by profileSource in the
+// GitRepository.
+func makeSource(gitRepo *sourcev1.GitRepository, profileSource *profilesv1.Source) installation.Source {
+	source := installation.Source{
+		Name:            gitRepo.Name,
+		Namespace:       gitRepo.Namespace,
+		Path:            profileSource.Path,
+		ProfileFilename: profileSource.ProfileFilename,
+		URL:             gitRepo.Spec.URL,
+	}
+	if ref := gitRepo.Spec.Reference; ref != nil {
+		source.Branch = ref.Branch
+		source.Tag = ref.Tag
+	}
+	if gitRepo.Status.Artifact != nil {
+		source.Commit = commitFromRevision(gitRepo.Status.Artifact.Revision)
+	}
+	return source
+}
+
+// commitFromRevision returns the commit SHA of a source-controller artifact revision,
+// which is in the format <branch|tag>/<sha>.
+func commitFromRevision(revision string) string {
+	return revision[strings.LastIndex(revision, "/")+1:]
+}
+
+// nestedProfileLoader loads the profiles included by the installed profile. Profiles
+// located in another repository get a GitRepository named after the including artifact.
+type nestedProfileLoader struct {
+	ctx context.Context
+	r   *ProfileInstallationReconciler
+	pi  profilesv1.ProfileInstallation
+	// created are the GitRepositories created for the loaded profiles.
+	created []client.Object
+}
+
+func (l *nestedProfileLoader) Load(parent installation.Source, name string, profileSource profilesv1.Source) (*profilesv1.ProfileDefinition, installation.Source, error) {
+	gitRepo := &sourcev1.GitRepository{}
+	if profileSource.URL == "" {
+		if err := l.r.Client.Get(l.ctx, client.ObjectKey{Name: parent.Name, Namespace: parent.Namespace}, gitRepo); err != nil {
+			return nil, installation.Source{}, fmt.Errorf("failed to get gitrepository %s/%s: %w", parent.Namespace, parent.Name, err)
+		}
+	} else {
+		// like the source of an installation, a nested source without a ref defaults to main
+		if profileSource.Branch == "" && profileSource.Tag == "" {
+			profileSource.Branch = "main"
+		}
+		gitRepo = makeGitRepository(l.pi, fmt.Sprintf("%s-%s", l.pi.Name, name), &profileSource)
+		if err := l.r.createOrUpdate(l.ctx, l.pi, gitRepo); err != nil {
+			return nil, installation.Source{}, err
+		}
+		l.created = append(l.created, gitRepo)
+	}
+
+	if gitRepo.Status.Artifact == nil {
+		return nil, installation.Source{}, fmt.Errorf("waiting for gitrepository %s/%s to produce an artifact: %w", gitRepo.Namespace, gitRepo.Name, installation.ErrSourceNotReady)
+	}
+
+	def, err := l.r.fetcher.Fetch(gitRepo.Status.Artifact.URL, profileSource.Path, profileSource.ProfileFilename)
+	if err != nil {
+		return nil, installation.Source{}, fmt.Errorf("failed to fetch profile definition: %w", err)
+	}
+	return def, makeSource(gitRepo, &profileSource), nil
+}
+
+// createOrUpdate creates obj, or updates the spec of the existing object, with the installation
+// as its controller. Only the spec, the installation label and the controller reference are
+// changed, so the finalizers and metadata added by the Flux controllers are kept. On return
+// obj reflects the state in the cluster.
+func (r *ProfileInstallationReconciler) createOrUpdate(ctx context.Context, pi profilesv1.ProfileInstallation, obj client.Object) error {
+	desired := obj.DeepCopyObject().(client.Object)
+	key, err := r.ownedKey(obj)
+	if err != nil {
+		return err
+	}
+
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, obj, func() error {
+		if err := setSpec(obj, desired); err != nil {
+			return err
+		}
+		labels := obj.GetLabels()
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		labels[installationLabel] = pi.Name
+		obj.SetLabels(labels)
+		return controllerutil.SetControllerReference(&pi, obj, r.s)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create or update %s: %w", key, err)
+	}
+	return nil
+}
+
+// setSpec sets the spec of obj to the spec of desired, which must be of the same kind.
+func setSpec(obj, desired client.Object) error {
+	switch o := obj.(type) {
+	case *sourcev1.GitRepository:
+		o.Spec = desired.(*sourcev1.GitRepository).Spec
+	case *sourcev1.HelmRepository:
+		o.Spec = desired.(*sourcev1.HelmRepository).Spec
+	case *helmv2.HelmRelease:
+		o.Spec = desired.(*helmv2.HelmRelease).Spec
+	case *kustomizev1.Kustomization:
+		o.Spec = desired.(*kustomizev1.Kustomization).Spec
+	default:
+		return fmt.Errorf("unsupported resource %T", obj)
+	}
+	return nil
+}
+
+// prune deletes the resources created for the installation which are not in desired, such
+// as those of a removed artifact or of a previously installed profile. It returns the
+// number of deleted resources.
+func (r *ProfileInstallationReconciler) prune(ctx context.Context, pi profilesv1.ProfileInstallation, desired []client.Object) (int, error) {
+	keep := map[string]bool{}
+	for _, obj := range desired {
+		key, err := r.ownedKey(obj)
+		if err != nil {
+			return 0, err
+		}
+		keep[key] = true
+	}
+
+	owned := []client.ObjectList{
+		&sourcev1.GitRepositoryList{},
+		&sourcev1.HelmRepositoryList{},
+		&helmv2.HelmReleaseList{},
+		&kustomizev1.KustomizationList{},
+	}
+	pruned := 0
+	for _, list := range owned {
+		if err := r.Client.List(ctx, list, client.InNamespace(pi.Namespace), client.MatchingLabels{installationLabel: pi.Name}); err != nil {
+			return pruned, fmt.Errorf("failed to list resources of installation %s: %w", pi.Name, err)
+		}
+		items, err := apimeta.ExtractList(list)
+		if err != nil {
+			return pruned, fmt.Errorf("failed to list resources of installation %s: %w", pi.Name, err)
+		}
+		for _, item := range items {
+			obj := item.(client.Object)
+			key, err := r.ownedKey(obj)
+			if err != nil {
+				return pruned, err
+			}
+			if keep[key] || !metav1.IsControlledBy(obj, &pi) {
+				continue
+			}
+			if err := r.Client.Delete(ctx, obj); client.IgnoreNotFound(err) != nil {
+				return pruned, fmt.Errorf("failed to delete %s: %w", key, err)
+			}
+			pruned++
+		}
+	}
+	return pruned, nil
+}
+
+// ownedKey identifies a resource created for an installation by its kind and name.
+func (r *ProfileInstallationReconciler) ownedKey(obj client.Object) (string, error) {
+	gvk, err := apiutil.GVKForObject(obj, r.s)
+	if err != nil {
+		return "", fmt.Errorf("failed to get kind of %s: %w", obj.GetName(), err)
+	}
+	return fmt.Sprintf("%s %s/%s", gvk.Kind, obj.GetNamespace(), obj.GetName()), nil
+}
+
+func (r *ProfileInstallationReconciler) setFailed(ctx context.Context, pi profilesv1.ProfileInstallation, reconcileErr error) error {
+	r.log.Error(reconcileErr, "failed to reconcile", "profileinstallation", client.ObjectKeyFromObject(&pi))
+	if err := r.setCondition(ctx, pi, metav1.ConditionFalse, profilesv1.ReasonFailed, reconcileErr.Error()); err != nil {
+		return err
+	}
+	return reconcileErr
+}
+
+func (r *ProfileInstallationReconciler) setValuesFailed(ctx context.Context, pi profilesv1.ProfileInstallation, valuesErr error) error {
+	r.log.Error(valuesErr, "invalid values", "profileinstallation", client.ObjectKeyFromObject(&pi))
+	if err := r.updateStatus(ctx, pi, func(s *profilesv1.ProfileInstallationStatus) {
+		setStatusCondition(s, pi, profilesv1.ConditionTypeValuesReady, metav1.ConditionFalse, profilesv1.ReasonValuesInvalid, valuesErr.Error())
+		setReadyCondition(s, pi, metav1.ConditionFalse, profilesv1.ReasonFailed, valuesErr.Error())
+	}); err != nil {
+		return err
+	}
+	return valuesErr
+}
+
+func (r *ProfileInstallationReconciler) setCondition(ctx context.Context, pi profilesv1.ProfileInstallation, status metav1.ConditionStatus, reason, message string) error {
+	return r.updateStatus(ctx, pi, func(s *profilesv1.ProfileInstallationStatus) {
+		setReadyCondition(s, pi, status, reason, message)
+	})
+}
+
+func setReadyCondition(s *profilesv1.ProfileInstallationStatus, pi profilesv1.ProfileInstallation, status metav1.ConditionStatus, reason, message string) {
+	setStatusCondition(s, pi, profilesv1.ConditionTypeReady, status, reason, message)
+}
+
+func setStatusCondition(s *profilesv1.ProfileInstallationStatus, pi profilesv1.ProfileInstallation, conditionType string, status metav1.ConditionStatus, reason, message string) {
+	apimeta.SetStatusCondition(&s.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		ObservedGeneration: pi.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+func (r *ProfileInstallationReconciler) updateStatus(ctx context.Context, pi profilesv1.ProfileInstallation, mutate func(*profilesv1.ProfileInstallationStatus)) error {
+	var latest profilesv1.ProfileInstallation
+	if err := r.Get(ctx, client.ObjectKeyFromObject(&pi), &latest); err != nil {
+		return err
+	}
+
+	patch := client.MergeFrom(latest.DeepCopy())
+	mutate(&latest.Status)
+
+	return r.Status().Patch(ctx, &latest, patch)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ProfileInstallationReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&profilesv1.ProfileInstallation{}).
+		Owns(&sourcev1.GitRepository{}).
+		Owns(&sourcev1.HelmRepository{}).
+		Owns(&helmv2.HelmRelease{}).
+		Owns(&kustomizev1.Kustomization{}).
+		Watches(&source.Kind{Type: &corev1.ConfigMap{}}, handler.EnqueueRequestsFromMapFunc(r.installationsForValues)).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, handler.EnqueueRequestsFromMapFunc(r.installationsForValues)).
+		Complete(r)
+}
+
+// installationsForValues returns the installations which read their values from obj.
+func (r *ProfileInstallationReconciler) installationsForValues(obj client.Object) []reconcile.Request {
+	var list profilesv1.ProfileInstallationList
+	if err := r.List(context.Background(), &list, client.InNamespace(obj.GetNamespace())); err != nil {
+		r.log.Error(err, "failed to list profileinstallations", "namespace", obj.GetNamespace())
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, pi := range list.Items {
+		name := pi.Spec.ConfigMap
+		if _, ok := obj.(*corev1.Secret); ok {
+			name = pi.Spec.Secret
+		}
+		if name != "" && name == obj.GetName() {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&pi)})
+		}
+	}
+	return requests
+}
@@ -0,0 +1,511 @@
+package controllers_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2beta1"
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
+	"github.com/google/uuid"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	profilesv1 "github.com/weaveworks/profiles/api/v1alpha1"
+)
+
+var _ = Describe("ProfileInstallationController", func() {
+	var (
+		namespace    string
+		ctx          = context.Background()
+		installation *profilesv1.ProfileInstallation
+	)
+
+	BeforeEach(func() {
+		namespace = uuid.New().String()
+		nsp := v1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: namespace,
+			},
+		}
+		Expect(k8sClient.Create(ctx, &nsp)).To(Succeed())
+
+		installation = &profilesv1.ProfileInstallation{
+			TypeMeta: metav1.TypeMeta{
+				Kind:       "ProfileInstallation",
+				APIVersion: "weave.works/v1alpha1",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "nginx",
+				Namespace: namespace,
+			},
+			Spec: profilesv1.ProfileInstallationSpec{
+				Source: &profilesv1.Source{
+					URL:    "https://github.com/weaveworks/profiles-examples",
+					Branch: "main",
+					Path:   "weaveworks-nginx",
+				},
+			},
+		}
+	})
+
+	readyCondition := func() *metav1.Condition {
+		pi := &profilesv1.ProfileInstallation{}
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(installation), pi)).To(Succeed())
+		return apimeta.FindStatusCondition(pi.Status.Conditions, profilesv1.ConditionTypeReady)
+	}
+
+	publishArtifact := func() {
+		gitRepo := &sourcev1.GitRepository{}
+		Eventually(func() error {
+			return k8sClient.Get(ctx, client.ObjectKey{Name: "nginx", Namespace: namespace}, gitRepo)
+		}, 2*time.Second).Should(Succeed())
+		gitRepo.Status.Artifact = &sourcev1.Artifact{
+			Path:           "gitrepository/nginx/sha.tar.gz",
+			URL:            "http://source-controller/gitrepository/nginx/sha.tar.gz",
+			Revision:       "main/sha",
+			Checksum:       "checksum",
+			LastUpdateTime: metav1.Now(),
+		}
+		Expect(k8sClient.Status().Update(ctx, gitRepo)).To(Succeed())
+	}
+
+	When("the profile definition can be fetched", func() {
+		BeforeEach(func() {
+			fakeFetcher.FetchReturns(&profilesv1.ProfileDefinition{
+				ObjectMeta: metav1.ObjectMeta{Name: "weaveworks-nginx"},
+				Spec: profilesv1.ProfileDefinitionSpec{
+					Artifacts: []profilesv1.Artifact{
+						{
+							Name:  "nginx-server",
+							Chart: &profilesv1.Chart{Path: "nginx/chart"},
+						},
+						{
+							Name:      "nginx-deployment",
+							Kustomize: &profilesv1.Kustomize{Path: "nginx/deployment"},
+						},
+					},
+				},
+			}, nil)
+		})
+
+		It("creates the flux resources for each artifact", func() {
+			Expect(k8sClient.Create(ctx, installation)).To(Succeed())
+
+			By("creating a gitrepository for the profile source")
+			gitRepo := &sourcev1.GitRepository{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Name: "nginx", Namespace: namespace}, gitRepo)
+			}, 2*time.Second).Should(Succeed())
+			Expect(gitRepo.Spec.URL).To(Equal("https://github.com/weaveworks/profiles-examples"))
+			Expect(gitRepo.Spec.Reference.Branch).To(Equal("main"))
+			Expect(gitRepo.OwnerReferences).To(HaveLen(1))
+			Expect(gitRepo.OwnerReferences[0].Name).To(Equal("nginx"))
+
+			By("waiting for the source to become ready")
+			Eventually(readyCondition, 2*time.Second).ShouldNot(BeNil())
+			Expect(readyCondition().Reason).To(Equal(profilesv1.ReasonSourceNotReady))
+
+			publishArtifact()
+
+			By("creating the artifacts")
+			release := &helmv2.HelmRelease{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Name: "nginx-nginx-server", Namespace: namespace}, release)
+			}, 2*time.Second).Should(Succeed())
+			Expect(release.Spec.Chart.Spec.Chart).To(Equal("weaveworks-nginx/nginx/chart"))
+			Expect(release.OwnerReferences[0].Name).To(Equal("nginx"))
+
+			kustomization := &kustomizev1.Kustomization{}
+			Expect(k8sClient.Get(ctx, client.ObjectKey{Name: "nginx-nginx-deployment", Namespace: namespace}, kustomization)).To(Succeed())
+			Expect(kustomization.Spec.Path).To(Equal("weaveworks-nginx/nginx/deployment"))
+
+			url, path, filename := fakeFetcher.FetchArgsForCall(fakeFetcher.FetchCallCount() - 1)
+			Expect(url).To(Equal("http://source-controller/gitrepository/nginx/sha.tar.gz"))
+			Expect(path).To(Equal("weaveworks-nginx"))
+			Expect(filename).To(BeEmpty())
+
+			Eventually(func() string {
+				return readyCondition().Reason
+			}, 2*time.Second).Should(Equal(profilesv1.ReasonArtifactsCreated))
+			Expect(readyCondition().Status).To(Equal(metav1.ConditionTrue))
+		})
+
+		It("keeps the metadata added to the flux resources by the flux controllers", func() {
+			Expect(k8sClient.Create(ctx, installation)).To(Succeed())
+			publishArtifact()
+
+			kustomization := &kustomizev1.Kustomization{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Name: "nginx-nginx-deployment", Namespace: namespace}, kustomization)
+			}, 2*time.Second).Should(Succeed())
+			kustomization.Finalizers = append(kustomization.Finalizers, "finalizers.fluxcd.io")
+			kustomization.Labels["kustomize.toolkit.fluxcd.io/name"] = "nginx-nginx-deployment"
+			Expect(k8sClient.Update(ctx, kustomization)).To(Succeed())
+
+			By("reconciling the installation again")
+			pi := &profilesv1.ProfileInstallation{}
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(installation), pi)).To(Succeed())
+			pi.Annotations = map[string]string{"reconcile": "now"}
+			Expect(k8sClient.Update(ctx, pi)).To(Succeed())
+			fetches := fakeFetcher.FetchCallCount()
+			Eventually(fakeFetcher.FetchCallCount, 2*time.Second).Should(BeNumerically(">", fetches))
+
+			Consistently(func() error {
+				if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(kustomization), kustomization); err != nil {
+					return err
+				}
+				if len(kustomization.Finalizers) != 1 || kustomization.Labels["kustomize.toolkit.fluxcd.io/name"] == "" {
+					return fmt.Errorf("metadata removed: %v %v", kustomization.Finalizers, kustomization.Labels)
+				}
+				return nil
+			}, time.Second).Should(Succeed())
+			Expect(kustomization.Labels).To(HaveKeyWithValue("weave.works/profile-installation", "nginx"))
+			Expect(kustomization.OwnerReferences[0].Name).To(Equal("nginx"))
+		})
+
+		It("deletes the flux resources of a removed artifact", func() {
+			Expect(k8sClient.Create(ctx, installation)).To(Succeed())
+			publishArtifact()
+
+			release := &helmv2.HelmRelease{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Name: "nginx-nginx-server", Namespace: namespace}, release)
+			}, 2*time.Second).Should(Succeed())
+			Expect(release.Labels).To(HaveKeyWithValue("weave.works/profile-installation", "nginx"))
+
+			By("removing the chart artifact from the profile")
+			fakeFetcher.FetchReturns(&profilesv1.ProfileDefinition{
+				ObjectMeta: metav1.ObjectMeta{Name: "weaveworks-nginx"},
+				Spec: profilesv1.ProfileDefinitionSpec{
+					Artifacts: []profilesv1.Artifact{
+						{
+							Name:      "nginx-deployment",
+							Kustomize: &profilesv1.Kustomize{Path: "nginx/deployment"},
+						},
+					},
+				},
+			}, nil)
+			pi := &profilesv1.ProfileInstallation{}
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(installation), pi)).To(Succeed())
+			pi.Annotations = map[string]string{"reconcile": "now"}
+			Expect(k8sClient.Update(ctx, pi)).To(Succeed())
+
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, client.ObjectKey{Name: "nginx-nginx-server", Namespace: namespace}, &helmv2.HelmRelease{})
+				return apierrors.IsNotFound(err)
+			}, 2*time.Second).Should(BeTrue())
+			Expect(k8sClient.Get(ctx, client.ObjectKey{Name: "nginx-nginx-deployment", Namespace: namespace}, &kustomizev1.Kustomization{})).To(Succeed())
+			Expect(k8sClient.Get(ctx, client.ObjectKey{Name: "nginx", Namespace: namespace}, &sourcev1.GitRepository{})).To(Succeed())
+		})
+	})
+
+	When("the profile definition cannot be fetched", func() {
+		BeforeEach(func() {
+			fakeFetcher.FetchReturns(nil, fmt.Errorf("not found"))
+		})
+
+		It("reports the failure in the status", func() {
+			Expect(k8sClient.Create(ctx, installation)).To(Succeed())
+			publishArtifact()
+
+			Eventually(func() string {
+				if c := readyCondition(); c != nil {
+					return c.Reason
+				}
+				return ""
+			}, 2*time.Second).Should(Equal(profilesv1.ReasonFailed))
+			Expect(readyCondition().Status).To(Equal(metav1.ConditionFalse))
+			Expect(readyCondition().Message).To(Equal("failed to fetch profile definition: not found"))
+		})
+	})
+
+	When("the installation references a catalog entry", func() {
+		BeforeEach(func() {
+			profiles.AddOrReplace("installation-catalog", profilesv1.ProfileCatalogEntry{
+				Name: "weaveworks-nginx",
+				Tag:  "weaveworks-nginx/v0.1.0",
+				URL:  "https://github.com/weaveworks/profiles-examples",
+			})
+			fakeFetcher.FetchReturns(&profilesv1.ProfileDefinition{
+				ObjectMeta: metav1.ObjectMeta{Name: "weaveworks-nginx"},
+				Spec: profilesv1.ProfileDefinitionSpec{
+					Artifacts: []profilesv1.Artifact{
+						{
+							Name:      "nginx-deployment",
+							Kustomize: &profilesv1.Kustomize{Path: "nginx/deployment"},
+						},
+					},
+				},
+			}, nil)
+			installation.Spec.Source = nil
+			installation.Spec.Catalog = &profilesv1.Catalog{
+				Catalog: "installation-catalog",
+				Profile: "weaveworks-nginx",
+				Version: "latest",
+			}
+		})
+
+		AfterEach(func() {
+			profiles.Remove("installation-catalog")
+		})
+
+		It("installs the profile from the resolved tag", func() {
+			Expect(k8sClient.Create(ctx, installation)).To(Succeed())
+
+			gitRepo := &sourcev1.GitRepository{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Name: "nginx", Namespace: namespace}, gitRepo)
+			}, 2*time.Second).Should(Succeed())
+			Expect(gitRepo.Spec.URL).To(Equal("https://github.com/weaveworks/profiles-examples"))
+			Expect(gitRepo.Spec.Reference.Tag).To(Equal("weaveworks-nginx/v0.1.0"))
+
+			publishArtifact()
+
+			Eventually(func() string {
+				return readyCondition().Reason
+			}, 2*time.Second).Should(Equal(profilesv1.ReasonArtifactsCreated))
+
+			pi := &profilesv1.ProfileInstallation{}
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(installation), pi)).To(Succeed())
+			Expect(pi.Status.Source).To(Equal(&profilesv1.ResolvedSource{
+				URL:    "https://github.com/weaveworks/profiles-examples",
+				Tag:    "weaveworks-nginx/v0.1.0",
+				Path:   "weaveworks-nginx",
+				Commit: "sha",
+			}))
+		})
+
+		When("the profile has a custom definition file", func() {
+			BeforeEach(func() {
+				profiles.AddOrReplace("installation-catalog", profilesv1.ProfileCatalogEntry{
+					Name:            "weaveworks-nginx",
+					Tag:             "weaveworks-nginx/v0.1.0",
+					URL:             "https://github.com/weaveworks/profiles-examples",
+					ProfileFilename: "definition.yaml",
+				})
+			})
+
+			It("fetches the profile from the definition file", func() {
+				Expect(k8sClient.Create(ctx, installation)).To(Succeed())
+				publishArtifact()
+
+				Eventually(func() string {
+					if c := readyCondition(); c != nil {
+						return c.Reason
+					}
+					return ""
+				}, 2*time.Second).Should(Equal(profilesv1.ReasonArtifactsCreated))
+				_, path, filename := fakeFetcher.FetchArgsForCall(fakeFetcher.FetchCallCount() - 1)
+				Expect(path).To(Equal("weaveworks-nginx"))
+				Expect(filename).To(Equal("definition.yaml"))
+			})
+		})
+
+		When("the profile is a pre-release read from a tracked branch", func() {
+			BeforeEach(func() {
+				profiles.AddOrReplace("installation-catalog", profilesv1.ProfileCatalogEntry{
+					Name:       "weaveworks-nginx",
+					Tag:        "weaveworks-nginx/0.0.0-main.abcdef0",
+					URL:        "https://github.com/weaveworks/profiles-examples",
+					TagPattern: profilesv1.BranchTagPattern,
+					Branch:     "main",
+					Commit:     "abcdef0123456789abcdef0123456789abcdef01",
+				})
+				installation.Spec.Catalog.Version = "0.0.0-main.abcdef0"
+			})
+
+			It("checks out the scanned commit of the branch", func() {
+				Expect(k8sClient.Create(ctx, installation)).To(Succeed())
+
+				gitRepo := &sourcev1.GitRepository{}
+				Eventually(func() error {
+					return k8sClient.Get(ctx, client.ObjectKey{Name: "nginx", Namespace: namespace}, gitRepo)
+				}, 2*time.Second).Should(Succeed())
+				Expect(gitRepo.Spec.Reference).To(Equal(&sourcev1.GitRepositoryRef{
+					Branch: "main",
+					Commit: "abcdef0123456789abcdef0123456789abcdef01",
+				}))
+			})
+		})
+
+		When("the profile is not in the catalog", func() {
+			It("reports the failure in the status", func() {
+				installation.Spec.Catalog.Version = "v1.0.0"
+				Expect(k8sClient.Create(ctx, installation)).To(Succeed())
+
+				Eventually(func() string {
+					if c := readyCondition(); c != nil {
+						return c.Message
+					}
+					return ""
+				}, 2*time.Second).Should(Equal(`profile "weaveworks-nginx" with version "v1.0.0" not found in catalog "installation-catalog"`))
+			})
+		})
+	})
+
+	When("the profile includes nested profiles", func() {
+		BeforeEach(func() {
+			definitions := map[string]*profilesv1.ProfileDefinition{
+				"weaveworks-nginx": {
+					ObjectMeta: metav1.ObjectMeta{Name: "weaveworks-nginx"},
+					Spec: profilesv1.ProfileDefinitionSpec{
+						Artifacts: []profilesv1.Artifact{
+							{
+								Name:    "base",
+								Profile: &profilesv1.Profile{Source: &profilesv1.Source{Path: "base"}},
+							},
+							{
+								Name:      "nginx-deployment",
+								Kustomize: &profilesv1.Kustomize{Path: "nginx/deployment"},
+								DependsOn: []profilesv1.DependsOn{{Name: "base"}},
+							},
+						},
+					},
+				},
+				"base": {
+					ObjectMeta: metav1.ObjectMeta{Name: "base"},
+					Spec: profilesv1.ProfileDefinitionSpec{
+						Artifacts: []profilesv1.Artifact{
+							{
+								Name:      "namespace",
+								Kustomize: &profilesv1.Kustomize{Path: "namespace"},
+							},
+						},
+					},
+				},
+			}
+			fakeFetcher.FetchStub = func(url, path, filename string) (*profilesv1.ProfileDefinition, error) {
+				return definitions[path], nil
+			}
+		})
+
+		AfterEach(func() {
+			fakeFetcher.FetchStub = nil
+		})
+
+		It("creates the artifacts of the nested profiles", func() {
+			Expect(k8sClient.Create(ctx, installation)).To(Succeed())
+			publishArtifact()
+
+			kustomization := &kustomizev1.Kustomization{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Name: "nginx-base-namespace", Namespace: namespace}, kustomization)
+			}, 2*time.Second).Should(Succeed())
+			Expect(kustomization.Spec.Path).To(Equal("base/namespace"))
+			Expect(kustomization.Spec.SourceRef.Name).To(Equal("nginx"))
+
+			Expect(k8sClient.Get(ctx, client.ObjectKey{Name: "nginx-nginx-deployment", Namespace: namespace}, kustomization)).To(Succeed())
+			Expect(kustomization.Spec.DependsOn).To(HaveLen(1))
+			Expect(kustomization.Spec.DependsOn[0].Name).To(Equal("nginx-base-namespace"))
+
+			Eventually(func() string {
+				return readyCondition().Reason
+			}, 2*time.Second).Should(Equal(profilesv1.ReasonArtifactsCreated))
+
+			pi := &profilesv1.ProfileInstallation{}
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(installation), pi)).To(Succeed())
+			Expect(pi.Status.NestedProfiles).To(Equal([]profilesv1.NestedProfileStatus{
+				{
+					Name:  "base",
+					Depth: 1,
+					Ready: true,
+					Source: &profilesv1.ResolvedSource{
+						URL:    "https://github.com/weaveworks/profiles-examples",
+						Branch: "main",
+						Path:   "base",
+						Commit: "sha",
+					},
+				},
+			}))
+		})
+	})
+
+	When("the profile includes a nested profile from another repository without a ref", func() {
+		BeforeEach(func() {
+			fakeFetcher.FetchReturns(&profilesv1.ProfileDefinition{
+				ObjectMeta: metav1.ObjectMeta{Name: "weaveworks-nginx"},
+				Spec: profilesv1.ProfileDefinitionSpec{
+					Artifacts: []profilesv1.Artifact{
+						{
+							Name:    "remote",
+							Profile: &profilesv1.Profile{Source: &profilesv1.Source{URL: "https://github.com/weaveworks/other-profiles", Path: "base"}},
+						},
+					},
+				},
+			}, nil)
+		})
+
+		It("reads the nested profile from the main branch", func() {
+			Expect(k8sClient.Create(ctx, installation)).To(Succeed())
+			publishArtifact()
+
+			gitRepo := &sourcev1.GitRepository{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Name: "nginx-remote", Namespace: namespace}, gitRepo)
+			}, 2*time.Second).Should(Succeed())
+			Expect(gitRepo.Spec.URL).To(Equal("https://github.com/weaveworks/other-profiles"))
+			Expect(gitRepo.Spec.Reference).To(Equal(&sourcev1.GitRepositoryRef{Branch: "main"}))
+		})
+	})
+
+	When("the installation has values", func() {
+		BeforeEach(func() {
+			fakeFetcher.FetchReturns(&profilesv1.ProfileDefinition{
+				ObjectMeta: metav1.ObjectMeta{Name: "weaveworks-nginx"},
+				Spec: profilesv1.ProfileDefinitionSpec{
+					Artifacts: []profilesv1.Artifact{
+						{
+							Name:  "nginx-server",
+							Chart: &profilesv1.Chart{Path: "nginx/chart", DefaultValues: "replicas: 1\nservice:\n  port: 80"},
+						},
+					},
+				},
+			}, nil)
+			installation.Spec.ConfigMap = "nginx-values"
+		})
+
+		It("merges the configmap values over the default values", func() {
+			Expect(k8sClient.Create(ctx, &v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "nginx-values", Namespace: namespace},
+				Data:       map[string]string{"nginx-server": "service:\n  port: 8080"},
+			})).To(Succeed())
+			Expect(k8sClient.Create(ctx, installation)).To(Succeed())
+			publishArtifact()
+
+			release := &helmv2.HelmRelease{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{Name: "nginx-nginx-server", Namespace: namespace}, release)
+			}, 2*time.Second).Should(Succeed())
+			Expect(string(release.Spec.Values.Raw)).To(MatchJSON(`{"replicas": 1, "service": {"port": 8080}}`))
+		})
+
+		When("the values are invalid", func() {
+			It("reports the failure in the status", func() {
+				Expect(k8sClient.Create(ctx, &v1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{Name: "nginx-values", Namespace: namespace},
+					Data:       map[string]string{"nginx-server": "service: [8080"},
+				})).To(Succeed())
+				Expect(k8sClient.Create(ctx, installation)).To(Succeed())
+				publishArtifact()
+
+				valuesCondition := func() *metav1.Condition {
+					pi := &profilesv1.ProfileInstallation{}
+					Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(installation), pi)).To(Succeed())
+					return apimeta.FindStatusCondition(pi.Status.Conditions, profilesv1.ConditionTypeValuesReady)
+				}
+				Eventually(valuesCondition, 2*time.Second).ShouldNot(BeNil())
+				Expect(valuesCondition().Status).To(Equal(metav1.ConditionFalse))
+				Expect(valuesCondition().Reason).To(Equal(profilesv1.ReasonValuesInvalid))
+				Expect(valuesCondition().Message).To(ContainSubstring(`artifact "nginx-server": failed to parse values from configmap nginx-values`))
+				Expect(readyCondition().Reason).To(Equal(profilesv1.ReasonFailed))
+			})
+		})
+	})
+})
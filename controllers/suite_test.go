@@ -37,6 +37,7 @@ import (
 	profilesv1 "github.com/weaveworks/profiles/api/v1alpha1"
 	"github.com/weaveworks/profiles/controllers"
 	"github.com/weaveworks/profiles/pkg/catalog"
+	installationfakes "github.com/weaveworks/profiles/pkg/installation/fakes"
 	"github.com/weaveworks/profiles/pkg/scanner/fakes"
 	// +kubebuilder:scaffold:imports
 )
@@ -47,6 +48,8 @@ var (
 	testEnv           *envtest.Environment
 	catalogReconciler *controllers.ProfileCatalogSourceReconciler
 	fakeRepoScanner   *fakes.FakeRepoScanner
+	fakeFetcher       *installationfakes.FakeFetcher
+	profiles          *catalog.Catalog
 )
 
 func TestAPIs(t *testing.T) {
@@ -89,7 +92,7 @@ var _ = BeforeSuite(func() {
 	})
 	Expect(err).ToNot(HaveOccurred())
 
-	profiles := catalog.New()
+	profiles = catalog.New()
 	catalogReconciler = controllers.NewCatalogSourceReconciler(
 		k8sManager.GetClient(),
 		ctrl.Log.WithName("controllers").WithName("profilecatalog"),
@@ -100,6 +103,18 @@ var _ = BeforeSuite(func() {
 	err = catalogReconciler.SetupWithManager(k8sManager)
 	Expect(err).ToNot(HaveOccurred())
 
+	fakeFetcher = new(installationfakes.FakeFetcher)
+	installationReconciler := controllers.NewProfileInstallationReconciler(
+		k8sManager.GetClient(),
+		ctrl.Log.WithName("controllers").WithName("profileinstallation"),
+		scheme.Scheme,
+		profiles,
+	)
+	installationReconciler.SetFetcher(fakeFetcher)
+
+	err = installationReconciler.SetupWithManager(k8sManager)
+	Expect(err).ToNot(HaveOccurred())
+
 	go func() {
 		err = k8sManager.Start(ctrl.SetupSignalHandler())
 		Expect(err).ToNot(HaveOccurred())
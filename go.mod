@@ -8,6 +8,7 @@ require (
 	github.com/fluxcd/helm-controller/api v0.12.0
 	github.com/fluxcd/kustomize-controller/api v0.16.0
 	github.com/fluxcd/pkg/apis/meta v0.10.1
+	github.com/fluxcd/pkg/runtime v0.12.0
 	github.com/fluxcd/pkg/version v0.1.0
 	github.com/fluxcd/source-controller v0.16.0
 	github.com/fluxcd/source-controller/api v0.17.1
@@ -28,6 +29,7 @@ require (
 	google.golang.org/grpc/cmd/protoc-gen-go-grpc v1.1.0
 	google.golang.org/protobuf v1.27.1
 	k8s.io/api v0.22.2
+	k8s.io/apiextensions-apiserver v0.22.2
 	k8s.io/apimachinery v0.22.2
 	k8s.io/client-go v0.22.2
 	sigs.k8s.io/controller-runtime v0.10.2
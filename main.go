@@ -32,6 +32,8 @@ import (
 	pgrpc "github.com/weaveworks/profiles/pkg/grpc"
 	"github.com/weaveworks/profiles/pkg/interrupt"
 	"github.com/weaveworks/profiles/pkg/manager"
+	"github.com/weaveworks/profiles/pkg/scanner"
+	"github.com/weaveworks/profiles/pkg/webhook"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -46,6 +48,15 @@ import (
 	// +kubebuilder:scaffold:imports
 )
 
+// webhookSecretEnv is the environment variable holding the secret webhook payloads are verified with.
+const webhookSecretEnv = "PROFILES_WEBHOOK_SECRET"
+
+// values of the scanner flag.
+const (
+	scannerGitRepository = "gitrepository"
+	scannerGit           = "git"
+)
+
 var (
 	scheme   = runtime.NewScheme()
 	setupLog = ctrl.Log.WithName("setup")
@@ -63,11 +74,21 @@ func init() {
 
 func main() {
 	var enableLeaderElection bool
-	var metricsAddr, probeAddr, apiAddr, grpcAddr string
+	var maxConcurrentReconciles, scanConcurrency int
+	var metricsAddr, probeAddr, apiAddr, grpcAddr, webhookAddr, scannerType, conflictPolicy string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.StringVar(&apiAddr, "profiles-api-bind-address", ":8000", "The address the profiles catalog api binds to.")
 	flag.StringVar(&grpcAddr, "profiles-grpc-bind-address", ":50051", "The address the profiles catalog grpc server binds to.")
+	flag.StringVar(&webhookAddr, "webhook-bind-address", "", "The address the webhook receiver binds to. "+
+		"The receiver is disabled when empty and requires the "+webhookSecretEnv+" environment variable to be set.")
+	flag.StringVar(&scannerType, "scanner", scannerGitRepository, "How repositories are scanned for profiles. "+
+		"One of "+scannerGitRepository+" (through Flux GitRepository resources) or "+scannerGit+" (directly with git).")
+	flag.StringVar(&conflictPolicy, "conflict-policy", string(catalog.KeepExisting), "Which profile a catalog source keeps when "+
+		"two of its repositories publish the same profile version. One of "+string(catalog.KeepExisting)+" or "+string(catalog.ReplaceExisting)+".")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 1, "The number of ProfileCatalogSources reconciled in parallel.")
+	flag.IntVar(&scanConcurrency, "scan-concurrency", scanner.DefaultConcurrency, "The number of repositories listed "+
+		"and tags scanned in parallel, shared by all the repositories of all ProfileCatalogSources.")
 
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
@@ -95,16 +116,43 @@ func main() {
 	}
 
 	profileCatalog := catalog.New()
+	switch policy := catalog.ConflictPolicy(conflictPolicy); policy {
+	case catalog.KeepExisting, catalog.ReplaceExisting:
+		profileCatalog.ConflictPolicy = policy
+	default:
+		setupLog.Error(fmt.Errorf("unknown conflict policy %q", conflictPolicy), "invalid flag", "flag", "conflict-policy")
+		os.Exit(1)
+	}
 
-	if err = controllers.NewCatalogSourceReconciler(
+	catalogReconciler := controllers.NewCatalogSourceReconciler(
 		mgr.GetClient(),
 		ctrl.Log.WithName("controllers").WithName("ProfileCatalogSource"),
 		mgr.GetScheme(),
 		profileCatalog,
-	).SetupWithManager(mgr); err != nil {
+	)
+	catalogReconciler.MaxConcurrentReconciles = maxConcurrentReconciles
+	catalogReconciler.ScanConcurrency = scanConcurrency
+	switch scannerType {
+	case scannerGitRepository:
+	case scannerGit:
+		catalogReconciler.UseDirectScanner()
+	default:
+		setupLog.Error(fmt.Errorf("unknown scanner %q", scannerType), "invalid flag", "flag", "scanner")
+		os.Exit(1)
+	}
+	if err = catalogReconciler.SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "ProfileCatalogSource")
 		os.Exit(1)
 	}
+	if err = controllers.NewProfileInstallationReconciler(
+		mgr.GetClient(),
+		ctrl.Log.WithName("controllers").WithName("ProfileInstallation"),
+		mgr.GetScheme(),
+		profileCatalog,
+	).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ProfileInstallation")
+		os.Exit(1)
+	}
 	// +kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("health", healthz.Ping); err != nil {
@@ -125,7 +173,18 @@ func main() {
 	setupLog.Info("starting manager")
 	managerServer := manager.NewServer(setupLog, mgr)
 
-	handler := interrupt.NewInterruptHandler(setupLog, grpcServer, gatewayServer, managerServer)
+	services := []interrupt.Service{grpcServer, gatewayServer, managerServer}
+	if webhookAddr != "" {
+		secret := os.Getenv(webhookSecretEnv)
+		if secret == "" {
+			setupLog.Error(fmt.Errorf("%s is not set", webhookSecretEnv), "unable to start webhook receiver")
+			os.Exit(1)
+		}
+		receiver := webhook.NewReceiver(setupLog, mgr.GetClient(), []byte(secret))
+		services = append(services, webhook.NewServer(setupLog, webhookAddr, receiver))
+	}
+
+	handler := interrupt.NewInterruptHandler(setupLog, services...)
 	if err := handler.ListenAndGracefulShutdown(); err != nil {
 		setupLog.Error(err, "failed to listen and graceful shutdown services")
 	}
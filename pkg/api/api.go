@@ -4,17 +4,20 @@ import (
 	"context"
 	"fmt"
 
+	fluxversion "github.com/fluxcd/pkg/version"
 	"github.com/go-logr/logr"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
 	profilesv1 "github.com/weaveworks/profiles/api/v1alpha1"
+	"github.com/weaveworks/profiles/pkg/catalog"
 	"github.com/weaveworks/profiles/pkg/protos"
 )
 
+// Catalog is an interface for the Catalog
+//
 //go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 -generate
 //counterfeiter:generate -o fakes/fake_catalog.go . Catalog
-// Catalog is an interface for the Catalog
 type Catalog interface {
 	// Get will return a specific profile from the catalog
 	Get(sourceName, profileName string) *profilesv1.ProfileCatalogEntry
@@ -22,10 +25,14 @@ type Catalog interface {
 	GetWithVersion(logger logr.Logger, sourceName, profileName, version string) *profilesv1.ProfileCatalogEntry
 	// ProfilesGreaterThanVersion returns all profiles which are of a greater version for a given profile with a version.
 	ProfilesGreaterThanVersion(logger logr.Logger, sourceName, profileName, version string) []profilesv1.ProfileCatalogEntry
-	// Search will return a list of profiles which match query
-	Search(query string) []profilesv1.ProfileCatalogEntry
-	// SearchAll will return a list of all profiles
-	SearchAll() []profilesv1.ProfileCatalogEntry
+	// SearchWithFilter will return a list of profiles which match the filter, ranked by relevance
+	SearchWithFilter(filter catalog.Filter) ([]catalog.Match, error)
+	// ListVersions will return all versions of a profile in descending order
+	ListVersions(sourceName, profileName string) []catalog.Version
+	// Sources will return all catalog sources
+	Sources() []catalog.Source
+	// GetSource will return a specific catalog source
+	GetSource(sourceName string) (catalog.Source, bool)
 }
 
 // CatalogAPI defines the GRPC profiles catalog service API.
@@ -99,8 +106,15 @@ func (p *ProfilesCatalogService) ProfilesGreaterThanVersion(ctx context.Context,
 		logger.Error(errMsg, "catalog, profile and/or version not set")
 		return nil, status.Errorf(codes.InvalidArgument, errMsg.Error())
 	}
+	if version != "latest" {
+		if _, err := fluxversion.ParseVersion(version); err != nil {
+			logger.Error(err, "invalid version")
+			return nil, status.Errorf(codes.InvalidArgument, "invalid version %q: %s", version, err)
+		}
+	}
 	result := p.profileCatalog.ProfilesGreaterThanVersion(logger, sourceName, profileName, version)
-	if len(result) == 0 {
+	// a profile without greater versions is up to date, only an unknown profile is not found
+	if len(result) == 0 && p.profileCatalog.Get(sourceName, profileName) == nil {
 		return nil, status.Errorf(codes.NotFound, "profile not found")
 	}
 	logger.Info("profile found", "profile", result)
@@ -109,21 +123,106 @@ func (p *ProfilesCatalogService) ProfilesGreaterThanVersion(ctx context.Context,
 	}, nil
 }
 
-// Search will return a list of profiles which match query
+// ListVersions will return all versions of a profile in descending order
+func (p *ProfilesCatalogService) ListVersions(ctx context.Context, request *protos.ListVersionsRequest) (*protos.ListVersionsResponse, error) {
+	sourceName := request.GetSourceName()
+	profileName := request.GetProfileName()
+	logger := p.logger.WithValues("func", "ListVersions", "catalog", sourceName, "profile", profileName)
+	if sourceName == "" || profileName == "" {
+		errMsg := fmt.Errorf("missing query param: sourceName: %q, profileName: %q", sourceName, profileName)
+		logger.Error(errMsg, "profile and/or catalog not set")
+		return nil, status.Errorf(codes.InvalidArgument, errMsg.Error())
+	}
+	versions := p.profileCatalog.ListVersions(sourceName, profileName)
+	if versions == nil {
+		return nil, status.Errorf(codes.NotFound, "profile not found")
+	}
+	var items []*protos.ProfileVersion
+	for _, v := range versions {
+		items = append(items, &protos.ProfileVersion{
+			Version:    v.Version,
+			Prerelease: v.Prerelease,
+			Item:       protos.TransformCatalogEntry(&v.Profile),
+		})
+	}
+	return &protos.ListVersionsResponse{
+		Items: items,
+	}, nil
+}
+
+// Search will return a list of profiles which match query, ranked by relevance
 func (p *ProfilesCatalogService) Search(ctx context.Context, request *protos.SearchRequest) (*protos.SearchResponse, error) {
-	query := request.GetName()
-	logger := p.logger.WithValues("func", "Search", "name", query)
-	var result []profilesv1.ProfileCatalogEntry
-	if query == "" {
-		logger.Info("Searching for all available profiles")
-		result = p.profileCatalog.SearchAll()
-	} else {
-		logger.Info("Searching for profiles matching name", "name", query)
-		result = p.profileCatalog.Search(query)
-	}
-
-	logger.Info("found profiles", "profiles", result)
+	filter := catalog.Filter{
+		Name:          request.GetName(),
+		Fuzzy:         request.GetFuzzy(),
+		Description:   request.GetDescription(),
+		Maintainer:    request.GetMaintainer(),
+		Prerequisites: request.GetPrerequisites(),
+		SourceName:    request.GetSourceName(),
+		VersionRange:  request.GetVersionRange(),
+		SortBy:        catalog.SortBy(request.GetSortBy()),
+		LatestOnly:    request.GetLatestOnly(),
+	}
+	logger := p.logger.WithValues("func", "Search", "filter", filter, "pageSize", request.GetPageSize(), "pageToken", request.GetPageToken())
+	logger.Info("Searching for profiles matching filter")
+	result, err := p.profileCatalog.SearchWithFilter(filter)
+	if err != nil {
+		logger.Error(err, "invalid filter")
+		return nil, status.Errorf(codes.InvalidArgument, err.Error())
+	}
+	page, nextPageToken, err := paginate(result, request.GetPageSize(), request.GetPageToken())
+	if err != nil {
+		logger.Error(err, "invalid page")
+		return nil, status.Errorf(codes.InvalidArgument, err.Error())
+	}
+
+	logger.Info("found profiles", "profiles", page, "total", len(result))
 	return &protos.SearchResponse{
-		Items: protos.TransformCatalogEntryList(result),
+		Items:         transformMatches(page),
+		TotalSize:     int32(len(result)),
+		NextPageToken: nextPageToken,
 	}, nil
 }
+
+// ListCatalogSources will return all catalog sources with their profile counts and scan status
+func (p *ProfilesCatalogService) ListCatalogSources(ctx context.Context, request *protos.ListCatalogSourcesRequest) (*protos.ListCatalogSourcesResponse, error) {
+	logger := p.logger.WithValues("func", "ListCatalogSources")
+	sources := p.profileCatalog.Sources()
+	logger.Info("found catalog sources", "sources", len(sources))
+	var items []*protos.CatalogSource
+	for _, source := range sources {
+		items = append(items, transformSource(source))
+	}
+	return &protos.ListCatalogSourcesResponse{
+		Items: items,
+	}, nil
+}
+
+// GetCatalogSource will return a specific catalog source with its profile counts and scan status
+func (p *ProfilesCatalogService) GetCatalogSource(ctx context.Context, request *protos.GetCatalogSourceRequest) (*protos.GetCatalogSourceResponse, error) {
+	sourceName := request.GetSourceName()
+	logger := p.logger.WithValues("func", "GetCatalogSource", "catalog", sourceName)
+	if sourceName == "" {
+		errMsg := fmt.Errorf("missing query param: sourceName: %q", sourceName)
+		logger.Error(errMsg, "catalog not set")
+		return nil, status.Errorf(codes.InvalidArgument, errMsg.Error())
+	}
+	source, ok := p.profileCatalog.GetSource(sourceName)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "catalog source not found")
+	}
+	return &protos.GetCatalogSourceResponse{
+		Item: transformSource(source),
+	}, nil
+}
+
+// transformMatches creates proto catalog entries out of the profiles found by a search.
+func transformMatches(matches []catalog.Match) []*protos.ProfileCatalogEntry {
+	var result []*protos.ProfileCatalogEntry
+	for _, m := range matches {
+		entry := protos.TransformCatalogEntry(&m.ProfileCatalogEntry)
+		entry.AvailableVersions = m.Versions
+		result = append(result, entry)
+	}
+	return result
+}
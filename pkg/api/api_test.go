@@ -2,16 +2,21 @@ package api_test
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"github.com/go-logr/logr"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	profilesv1 "github.com/weaveworks/profiles/api/v1alpha1"
 	"github.com/weaveworks/profiles/pkg/api"
 	catfakes "github.com/weaveworks/profiles/pkg/api/fakes"
+	"github.com/weaveworks/profiles/pkg/catalog"
 	"github.com/weaveworks/profiles/pkg/protos"
 )
 
@@ -128,7 +133,7 @@ var _ = Describe("API", func() {
 				result, err := catalogAPI.GetWithVersion(context.Background(), &protos.GetWithVersionRequest{
 					ProfileName: "invalid",
 					SourceName:  "invalid",
-					Version:     "invalid",
+					Version:     "v0.0.1",
 				})
 				grpcErr, ok := status.FromError(err)
 				Expect(ok).To(BeTrue())
@@ -137,6 +142,37 @@ var _ = Describe("API", func() {
 				Expect(result).To(BeNil())
 			})
 		})
+		When("the version is invalid", func() {
+			It("returns an invalid argument error", func() {
+				result, err := catalogAPI.ProfilesGreaterThanVersion(context.Background(), &protos.ProfilesGreaterThanVersionRequest{
+					ProfileName: "nginx-1",
+					SourceName:  "foo",
+					Version:     "invalid",
+				})
+				grpcErr, ok := status.FromError(err)
+				Expect(ok).To(BeTrue())
+				Expect(grpcErr.Message()).To(HavePrefix("invalid version \"invalid\""))
+				Expect(grpcErr.Code()).To(Equal(codes.InvalidArgument))
+				Expect(result).To(BeNil())
+				Expect(fakeCatalog.ProfilesGreaterThanVersionCallCount()).To(BeZero())
+			})
+		})
+		When("the version is latest", func() {
+			BeforeEach(func() {
+				fakeCatalog.ProfilesGreaterThanVersionReturns([]profilesv1.ProfileCatalogEntry{{Name: "nginx-1", CatalogSource: "foo", Tag: "v0.1.0"}})
+			})
+			It("returns all versions of the profile", func() {
+				result, err := catalogAPI.ProfilesGreaterThanVersion(context.Background(), &protos.ProfilesGreaterThanVersionRequest{
+					ProfileName: "nginx-1",
+					SourceName:  "foo",
+					Version:     "latest",
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.Items).To(HaveLen(1))
+				_, _, _, version := fakeCatalog.ProfilesGreaterThanVersionArgsForCall(0)
+				Expect(version).To(Equal("latest"))
+			})
+		})
 		When("source name empty", func() {
 			It("returns a proper error", func() {
 				result, err := catalogAPI.GetWithVersion(context.Background(), &protos.GetWithVersionRequest{
@@ -181,21 +217,40 @@ var _ = Describe("API", func() {
 	Context("Search", func() {
 		When("a query matches some profiles", func() {
 			BeforeEach(func() {
-				fakeCatalog.SearchReturns([]profilesv1.ProfileCatalogEntry{
+				fakeCatalog.SearchWithFilterReturns([]catalog.Match{
 					{
-						ProfileDescription: profilesv1.ProfileDescription{
-							Description: "nginx 1",
+						ProfileCatalogEntry: profilesv1.ProfileCatalogEntry{
+							ProfileDescription: profilesv1.ProfileDescription{
+								Description: "nginx 1",
+							},
+							Name:          "nginx-1",
+							CatalogSource: "foo",
 						},
-						Name:          "nginx-1",
-						CatalogSource: "foo",
 					},
-				})
+				}, nil)
 			})
 			It("returns valid results", func() {
 				result, err := catalogAPI.Search(context.Background(), &protos.SearchRequest{
-					Name: "nginx",
+					Name:          "nginx",
+					Fuzzy:         true,
+					Description:   "web server",
+					Maintainer:    "weaveworks",
+					Prerequisites: []string{"kubernetes 1.19"},
+					SourceName:    "foo",
+					VersionRange:  ">=1.0.0",
+					SortBy:        protos.SortBy_VERSION,
 				})
 				Expect(err).NotTo(HaveOccurred())
+				Expect(fakeCatalog.SearchWithFilterArgsForCall(0)).To(Equal(catalog.Filter{
+					Name:          "nginx",
+					Fuzzy:         true,
+					Description:   "web server",
+					Maintainer:    "weaveworks",
+					Prerequisites: []string{"kubernetes 1.19"},
+					SourceName:    "foo",
+					VersionRange:  ">=1.0.0",
+					SortBy:        catalog.SortByVersion,
+				}))
 				expected := &protos.SearchResponse{
 					Items: []*protos.ProfileCatalogEntry{
 						{
@@ -204,28 +259,33 @@ var _ = Describe("API", func() {
 							Description:   "nginx 1",
 						},
 					},
+					TotalSize: 1,
 				}
 				Expect(result).To(Equal(expected))
 			})
 		})
 		When("no query is provided", func() {
 			BeforeEach(func() {
-				fakeCatalog.SearchAllReturns([]profilesv1.ProfileCatalogEntry{
+				fakeCatalog.SearchWithFilterReturns([]catalog.Match{
 					{
-						ProfileDescription: profilesv1.ProfileDescription{
-							Description: "nginx 1",
+						ProfileCatalogEntry: profilesv1.ProfileCatalogEntry{
+							ProfileDescription: profilesv1.ProfileDescription{
+								Description: "nginx 1",
+							},
+							Name:          "nginx-1",
+							CatalogSource: "foo",
 						},
-						Name:          "nginx-1",
-						CatalogSource: "foo",
 					},
 					{
-						ProfileDescription: profilesv1.ProfileDescription{
-							Description: "redis 1",
+						ProfileCatalogEntry: profilesv1.ProfileCatalogEntry{
+							ProfileDescription: profilesv1.ProfileDescription{
+								Description: "redis 1",
+							},
+							Name:          "redis-1",
+							CatalogSource: "foo",
 						},
-						Name:          "redis-1",
-						CatalogSource: "foo",
 					},
-				})
+				}, nil)
 			})
 			It("returns all profiles", func() {
 				result, err := catalogAPI.Search(context.Background(), &protos.SearchRequest{})
@@ -243,10 +303,69 @@ var _ = Describe("API", func() {
 							Description:   "redis 1",
 						},
 					},
+					TotalSize: 2,
 				}
 				Expect(result).To(Equal(expected))
 			})
 		})
+		When("a page size is provided", func() {
+			BeforeEach(func() {
+				fakeCatalog.SearchWithFilterReturns([]catalog.Match{
+					{ProfileCatalogEntry: profilesv1.ProfileCatalogEntry{Name: "nginx-1", CatalogSource: "foo"}},
+					{ProfileCatalogEntry: profilesv1.ProfileCatalogEntry{Name: "nginx-2", CatalogSource: "foo"}},
+					{ProfileCatalogEntry: profilesv1.ProfileCatalogEntry{Name: "nginx-3", CatalogSource: "foo"}},
+				}, nil)
+			})
+			It("returns the profiles page by page", func() {
+				var names []string
+				pageToken := ""
+				for pages := 1; ; pages++ {
+					result, err := catalogAPI.Search(context.Background(), &protos.SearchRequest{PageSize: 2, PageToken: pageToken})
+					Expect(err).NotTo(HaveOccurred())
+					Expect(result.TotalSize).To(Equal(int32(3)))
+					Expect(len(result.Items)).To(BeNumerically("<=", 2))
+					for _, item := range result.Items {
+						names = append(names, item.Name)
+					}
+					if result.NextPageToken == "" {
+						Expect(pages).To(Equal(2))
+						break
+					}
+					pageToken = result.NextPageToken
+				}
+				Expect(names).To(Equal([]string{"nginx-1", "nginx-2", "nginx-3"}))
+			})
+			It("rejects invalid page tokens", func() {
+				_, err := catalogAPI.Search(context.Background(), &protos.SearchRequest{PageSize: 2, PageToken: "nope"})
+				Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+				_, err = catalogAPI.Search(context.Background(), &protos.SearchRequest{PageSize: -1})
+				Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+			})
+		})
+		When("only the latest versions are requested", func() {
+			BeforeEach(func() {
+				fakeCatalog.SearchWithFilterReturns([]catalog.Match{
+					{
+						ProfileCatalogEntry: profilesv1.ProfileCatalogEntry{Name: "nginx-1", CatalogSource: "foo", Tag: "v0.2.0"},
+						Versions:            []string{"v0.2.0", "v0.1.0"},
+					},
+				}, nil)
+			})
+			It("returns the available versions of the profiles", func() {
+				result, err := catalogAPI.Search(context.Background(), &protos.SearchRequest{Name: "nginx", LatestOnly: true})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(fakeCatalog.SearchWithFilterArgsForCall(0)).To(Equal(catalog.Filter{Name: "nginx", LatestOnly: true}))
+				Expect(result.Items).To(Equal([]*protos.ProfileCatalogEntry{
+					{
+						CatalogSource:     "foo",
+						Name:              "nginx-1",
+						Tag:               "v0.2.0",
+						AvailableVersions: []string{"v0.2.0", "v0.1.0"},
+					},
+				}))
+				Expect(result.TotalSize).To(Equal(int32(1)))
+			})
+		})
 		When("there are no profiles", func() {
 			It("returns an empty response", func() {
 				result, err := catalogAPI.Search(context.Background(), &protos.SearchRequest{})
@@ -254,6 +373,111 @@ var _ = Describe("API", func() {
 				Expect(result.Items).To(BeEmpty())
 			})
 		})
+		When("the filter is invalid", func() {
+			BeforeEach(func() {
+				fakeCatalog.SearchWithFilterReturns(nil, fmt.Errorf("invalid version range %q", "nope"))
+			})
+			It("returns an invalid argument error", func() {
+				result, err := catalogAPI.Search(context.Background(), &protos.SearchRequest{VersionRange: "nope"})
+				Expect(err).To(HaveOccurred())
+				grpcErr, ok := status.FromError(err)
+				Expect(ok).To(BeTrue())
+				Expect(grpcErr.Code()).To(Equal(codes.InvalidArgument))
+				Expect(result).To(BeNil())
+			})
+		})
+	})
+
+	Context("ListCatalogSources", func() {
+		BeforeEach(func() {
+			scanTime := metav1.NewTime(time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC))
+			fakeCatalog.SourcesReturns([]catalog.Source{
+				{
+					Name:     "foo",
+					Profiles: 2,
+					Versions: 5,
+					Status: profilesv1.ProfileCatalogSourceStatus{
+						LastScanTime: &scanTime,
+						Conditions: []metav1.Condition{
+							{Type: profilesv1.ConditionTypeFailed, Status: metav1.ConditionTrue, Message: "1 of 2 repositories failed to scan: github.com/org/broken"},
+						},
+						ScannedRepositories: []profilesv1.ScannedRepository{
+							{
+								URL:          "github.com/org/repo",
+								Tags:         []string{"foo/v0.1.0", "foo/v0.2.0"},
+								LastScanTime: &scanTime,
+								FailedTags:   []profilesv1.FailedTag{{Tag: "bar/v0.1.0"}},
+								Conditions: []metav1.Condition{
+									{Type: profilesv1.ConditionTypeFailed, Status: metav1.ConditionFalse, Message: "found 2 new tags"},
+								},
+							},
+							{
+								URL: "github.com/org/broken",
+								Conditions: []metav1.Condition{
+									{Type: profilesv1.ConditionTypeFailed, Status: metav1.ConditionTrue, Message: "authentication required"},
+								},
+							},
+						},
+					},
+				},
+				{Name: "static", Profiles: 1, Versions: 1},
+			})
+		})
+
+		It("returns the catalog sources with their profile counts and scan status", func() {
+			result, err := catalogAPI.ListCatalogSources(context.Background(), &protos.ListCatalogSourcesRequest{})
+			Expect(err).NotTo(HaveOccurred())
+			scanTime := timestamppb.New(time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC))
+			Expect(result).To(Equal(&protos.ListCatalogSourcesResponse{
+				Items: []*protos.CatalogSource{
+					{
+						Name:         "foo",
+						ProfileCount: 2,
+						VersionCount: 5,
+						LastScanTime: scanTime,
+						Failed:       true,
+						Error:        "1 of 2 repositories failed to scan: github.com/org/broken",
+						ScannedRepositories: []*protos.ScannedRepository{
+							{Url: "github.com/org/repo", TagCount: 2, LastScanTime: scanTime, FailedTags: []string{"bar/v0.1.0"}},
+							{Url: "github.com/org/broken", Failed: true, Error: "authentication required"},
+						},
+					},
+					{Name: "static", ProfileCount: 1, VersionCount: 1},
+				},
+			}))
+		})
+	})
+
+	Context("GetCatalogSource", func() {
+		When("the catalog source exists", func() {
+			BeforeEach(func() {
+				fakeCatalog.GetSourceReturns(catalog.Source{Name: "foo", Profiles: 1, Versions: 2}, true)
+			})
+
+			It("returns the catalog source", func() {
+				result, err := catalogAPI.GetCatalogSource(context.Background(), &protos.GetCatalogSourceRequest{SourceName: "foo"})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(fakeCatalog.GetSourceArgsForCall(0)).To(Equal("foo"))
+				Expect(result).To(Equal(&protos.GetCatalogSourceResponse{
+					Item: &protos.CatalogSource{Name: "foo", ProfileCount: 1, VersionCount: 2},
+				}))
+			})
+		})
+
+		When("the catalog source does not exist", func() {
+			It("returns a not found error", func() {
+				result, err := catalogAPI.GetCatalogSource(context.Background(), &protos.GetCatalogSourceRequest{SourceName: "foo"})
+				Expect(status.Code(err)).To(Equal(codes.NotFound))
+				Expect(result).To(BeNil())
+			})
+		})
+
+		When("the catalog source name is empty", func() {
+			It("returns an invalid argument error", func() {
+				_, err := catalogAPI.GetCatalogSource(context.Background(), &protos.GetCatalogSourceRequest{})
+				Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+			})
+		})
 	})
 
 	Context("ProfilesGreaterThanVersion", func() {
@@ -292,7 +516,7 @@ var _ = Describe("API", func() {
 				result, err := catalogAPI.ProfilesGreaterThanVersion(context.Background(), &protos.ProfilesGreaterThanVersionRequest{
 					ProfileName: "invalid",
 					SourceName:  "invalid",
-					Version:     "invalid",
+					Version:     "v0.0.1",
 				})
 				grpcErr, ok := status.FromError(err)
 				Expect(ok).To(BeTrue())
@@ -301,6 +525,51 @@ var _ = Describe("API", func() {
 				Expect(result).To(BeNil())
 			})
 		})
+		When("the version is invalid", func() {
+			It("returns an invalid argument error", func() {
+				result, err := catalogAPI.ProfilesGreaterThanVersion(context.Background(), &protos.ProfilesGreaterThanVersionRequest{
+					ProfileName: "nginx-1",
+					SourceName:  "foo",
+					Version:     "invalid",
+				})
+				grpcErr, ok := status.FromError(err)
+				Expect(ok).To(BeTrue())
+				Expect(grpcErr.Message()).To(HavePrefix("invalid version \"invalid\""))
+				Expect(grpcErr.Code()).To(Equal(codes.InvalidArgument))
+				Expect(result).To(BeNil())
+				Expect(fakeCatalog.ProfilesGreaterThanVersionCallCount()).To(BeZero())
+			})
+		})
+		When("the version is latest", func() {
+			BeforeEach(func() {
+				fakeCatalog.ProfilesGreaterThanVersionReturns([]profilesv1.ProfileCatalogEntry{{Name: "nginx-1", CatalogSource: "foo", Tag: "v0.1.0"}})
+			})
+			It("returns all versions of the profile", func() {
+				result, err := catalogAPI.ProfilesGreaterThanVersion(context.Background(), &protos.ProfilesGreaterThanVersionRequest{
+					ProfileName: "nginx-1",
+					SourceName:  "foo",
+					Version:     "latest",
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.Items).To(HaveLen(1))
+				_, _, _, version := fakeCatalog.ProfilesGreaterThanVersionArgsForCall(0)
+				Expect(version).To(Equal("latest"))
+			})
+		})
+		When("the profile has no higher versions", func() {
+			BeforeEach(func() {
+				fakeCatalog.GetReturns(&profilesv1.ProfileCatalogEntry{Name: "nginx-1", CatalogSource: "foo", Tag: "v0.1.0"})
+			})
+			It("returns no profiles", func() {
+				result, err := catalogAPI.ProfilesGreaterThanVersion(context.Background(), &protos.ProfilesGreaterThanVersionRequest{
+					ProfileName: "nginx-1",
+					SourceName:  "foo",
+					Version:     "v0.1.0",
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.Items).To(BeEmpty())
+			})
+		})
 		When("source name empty", func() {
 			It("returns a proper error", func() {
 				result, err := catalogAPI.ProfilesGreaterThanVersion(context.Background(), &protos.ProfilesGreaterThanVersionRequest{
@@ -341,4 +610,47 @@ var _ = Describe("API", func() {
 			})
 		})
 	})
+
+	Context("ListVersions", func() {
+		When("the profile exists", func() {
+			BeforeEach(func() {
+				fakeCatalog.ListVersionsReturns([]catalog.Version{
+					{Version: "v0.2.0-rc.1", Prerelease: true, Profile: profilesv1.ProfileCatalogEntry{Name: "nginx-1", CatalogSource: "foo", Tag: "v0.2.0-rc.1"}},
+					{Version: "v0.1.0", Profile: profilesv1.ProfileCatalogEntry{Name: "nginx-1", CatalogSource: "foo", Tag: "v0.1.0"}},
+				})
+			})
+			It("returns all versions of the profile", func() {
+				result, err := catalogAPI.ListVersions(context.Background(), &protos.ListVersionsRequest{
+					ProfileName: "nginx-1",
+					SourceName:  "foo",
+				})
+				Expect(err).NotTo(HaveOccurred())
+				source, profile := fakeCatalog.ListVersionsArgsForCall(0)
+				Expect(source).To(Equal("foo"))
+				Expect(profile).To(Equal("nginx-1"))
+				Expect(result).To(Equal(&protos.ListVersionsResponse{
+					Items: []*protos.ProfileVersion{
+						{Version: "v0.2.0-rc.1", Prerelease: true, Item: &protos.ProfileCatalogEntry{Name: "nginx-1", CatalogSource: "foo", Tag: "v0.2.0-rc.1"}},
+						{Version: "v0.1.0", Item: &protos.ProfileCatalogEntry{Name: "nginx-1", CatalogSource: "foo", Tag: "v0.1.0"}},
+					},
+				}))
+			})
+		})
+		When("there is no matching profile", func() {
+			It("returns a not found error", func() {
+				result, err := catalogAPI.ListVersions(context.Background(), &protos.ListVersionsRequest{
+					ProfileName: "invalid",
+					SourceName:  "invalid",
+				})
+				Expect(status.Code(err)).To(Equal(codes.NotFound))
+				Expect(result).To(BeNil())
+			})
+		})
+		When("profile name empty", func() {
+			It("returns an invalid argument error", func() {
+				_, err := catalogAPI.ListVersions(context.Background(), &protos.ListVersionsRequest{SourceName: "foo"})
+				Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+			})
+		})
+	})
 })
@@ -7,6 +7,7 @@ import (
 	"github.com/go-logr/logr"
 	"github.com/weaveworks/profiles/api/v1alpha1"
 	"github.com/weaveworks/profiles/pkg/api"
+	"github.com/weaveworks/profiles/pkg/catalog"
 )
 
 type FakeCatalog struct {
@@ -22,6 +23,19 @@ type FakeCatalog struct {
 	getReturnsOnCall map[int]struct {
 		result1 *v1alpha1.ProfileCatalogEntry
 	}
+	GetSourceStub        func(string) (catalog.Source, bool)
+	getSourceMutex       sync.RWMutex
+	getSourceArgsForCall []struct {
+		arg1 string
+	}
+	getSourceReturns struct {
+		result1 catalog.Source
+		result2 bool
+	}
+	getSourceReturnsOnCall map[int]struct {
+		result1 catalog.Source
+		result2 bool
+	}
 	GetWithVersionStub        func(logr.Logger, string, string, string) *v1alpha1.ProfileCatalogEntry
 	getWithVersionMutex       sync.RWMutex
 	getWithVersionArgsForCall []struct {
@@ -36,6 +50,18 @@ type FakeCatalog struct {
 	getWithVersionReturnsOnCall map[int]struct {
 		result1 *v1alpha1.ProfileCatalogEntry
 	}
+	ListVersionsStub        func(string, string) []catalog.Version
+	listVersionsMutex       sync.RWMutex
+	listVersionsArgsForCall []struct {
+		arg1 string
+		arg2 string
+	}
+	listVersionsReturns struct {
+		result1 []catalog.Version
+	}
+	listVersionsReturnsOnCall map[int]struct {
+		result1 []catalog.Version
+	}
 	ProfilesGreaterThanVersionStub        func(logr.Logger, string, string, string) []v1alpha1.ProfileCatalogEntry
 	profilesGreaterThanVersionMutex       sync.RWMutex
 	profilesGreaterThanVersionArgsForCall []struct {
@@ -50,26 +76,28 @@ type FakeCatalog struct {
 	profilesGreaterThanVersionReturnsOnCall map[int]struct {
 		result1 []v1alpha1.ProfileCatalogEntry
 	}
-	SearchStub        func(string) []v1alpha1.ProfileCatalogEntry
-	searchMutex       sync.RWMutex
-	searchArgsForCall []struct {
-		arg1 string
+	SearchWithFilterStub        func(catalog.Filter) ([]catalog.Match, error)
+	searchWithFilterMutex       sync.RWMutex
+	searchWithFilterArgsForCall []struct {
+		arg1 catalog.Filter
 	}
-	searchReturns struct {
-		result1 []v1alpha1.ProfileCatalogEntry
+	searchWithFilterReturns struct {
+		result1 []catalog.Match
+		result2 error
 	}
-	searchReturnsOnCall map[int]struct {
-		result1 []v1alpha1.ProfileCatalogEntry
+	searchWithFilterReturnsOnCall map[int]struct {
+		result1 []catalog.Match
+		result2 error
 	}
-	SearchAllStub        func() []v1alpha1.ProfileCatalogEntry
-	searchAllMutex       sync.RWMutex
-	searchAllArgsForCall []struct {
+	SourcesStub        func() []catalog.Source
+	sourcesMutex       sync.RWMutex
+	sourcesArgsForCall []struct {
 	}
-	searchAllReturns struct {
-		result1 []v1alpha1.ProfileCatalogEntry
+	sourcesReturns struct {
+		result1 []catalog.Source
 	}
-	searchAllReturnsOnCall map[int]struct {
-		result1 []v1alpha1.ProfileCatalogEntry
+	sourcesReturnsOnCall map[int]struct {
+		result1 []catalog.Source
 	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
@@ -137,6 +165,70 @@ func (fake *FakeCatalog) GetReturnsOnCall(i int, result1 *v1alpha1.ProfileCatalo
 	}{result1}
 }
 
+func (fake *FakeCatalog) GetSource(arg1 string) (catalog.Source, bool) {
+	fake.getSourceMutex.Lock()
+	ret, specificReturn := fake.getSourceReturnsOnCall[len(fake.getSourceArgsForCall)]
+	fake.getSourceArgsForCall = append(fake.getSourceArgsForCall, struct {
+		arg1 string
+	}{arg1})
+	stub := fake.GetSourceStub
+	fakeReturns := fake.getSourceReturns
+	fake.recordInvocation("GetSource", []interface{}{arg1})
+	fake.getSourceMutex.Unlock()
+	if stub != nil {
+		return stub(arg1)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeCatalog) GetSourceCallCount() int {
+	fake.getSourceMutex.RLock()
+	defer fake.getSourceMutex.RUnlock()
+	return len(fake.getSourceArgsForCall)
+}
+
+func (fake *FakeCatalog) GetSourceCalls(stub func(string) (catalog.Source, bool)) {
+	fake.getSourceMutex.Lock()
+	defer fake.getSourceMutex.Unlock()
+	fake.GetSourceStub = stub
+}
+
+func (fake *FakeCatalog) GetSourceArgsForCall(i int) string {
+	fake.getSourceMutex.RLock()
+	defer fake.getSourceMutex.RUnlock()
+	argsForCall := fake.getSourceArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *FakeCatalog) GetSourceReturns(result1 catalog.Source, result2 bool) {
+	fake.getSourceMutex.Lock()
+	defer fake.getSourceMutex.Unlock()
+	fake.GetSourceStub = nil
+	fake.getSourceReturns = struct {
+		result1 catalog.Source
+		result2 bool
+	}{result1, result2}
+}
+
+func (fake *FakeCatalog) GetSourceReturnsOnCall(i int, result1 catalog.Source, result2 bool) {
+	fake.getSourceMutex.Lock()
+	defer fake.getSourceMutex.Unlock()
+	fake.GetSourceStub = nil
+	if fake.getSourceReturnsOnCall == nil {
+		fake.getSourceReturnsOnCall = make(map[int]struct {
+			result1 catalog.Source
+			result2 bool
+		})
+	}
+	fake.getSourceReturnsOnCall[i] = struct {
+		result1 catalog.Source
+		result2 bool
+	}{result1, result2}
+}
+
 func (fake *FakeCatalog) GetWithVersion(arg1 logr.Logger, arg2 string, arg3 string, arg4 string) *v1alpha1.ProfileCatalogEntry {
 	fake.getWithVersionMutex.Lock()
 	ret, specificReturn := fake.getWithVersionReturnsOnCall[len(fake.getWithVersionArgsForCall)]
@@ -201,6 +293,68 @@ func (fake *FakeCatalog) GetWithVersionReturnsOnCall(i int, result1 *v1alpha1.Pr
 	}{result1}
 }
 
+func (fake *FakeCatalog) ListVersions(arg1 string, arg2 string) []catalog.Version {
+	fake.listVersionsMutex.Lock()
+	ret, specificReturn := fake.listVersionsReturnsOnCall[len(fake.listVersionsArgsForCall)]
+	fake.listVersionsArgsForCall = append(fake.listVersionsArgsForCall, struct {
+		arg1 string
+		arg2 string
+	}{arg1, arg2})
+	stub := fake.ListVersionsStub
+	fakeReturns := fake.listVersionsReturns
+	fake.recordInvocation("ListVersions", []interface{}{arg1, arg2})
+	fake.listVersionsMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeCatalog) ListVersionsCallCount() int {
+	fake.listVersionsMutex.RLock()
+	defer fake.listVersionsMutex.RUnlock()
+	return len(fake.listVersionsArgsForCall)
+}
+
+func (fake *FakeCatalog) ListVersionsCalls(stub func(string, string) []catalog.Version) {
+	fake.listVersionsMutex.Lock()
+	defer fake.listVersionsMutex.Unlock()
+	fake.ListVersionsStub = stub
+}
+
+func (fake *FakeCatalog) ListVersionsArgsForCall(i int) (string, string) {
+	fake.listVersionsMutex.RLock()
+	defer fake.listVersionsMutex.RUnlock()
+	argsForCall := fake.listVersionsArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeCatalog) ListVersionsReturns(result1 []catalog.Version) {
+	fake.listVersionsMutex.Lock()
+	defer fake.listVersionsMutex.Unlock()
+	fake.ListVersionsStub = nil
+	fake.listVersionsReturns = struct {
+		result1 []catalog.Version
+	}{result1}
+}
+
+func (fake *FakeCatalog) ListVersionsReturnsOnCall(i int, result1 []catalog.Version) {
+	fake.listVersionsMutex.Lock()
+	defer fake.listVersionsMutex.Unlock()
+	fake.ListVersionsStub = nil
+	if fake.listVersionsReturnsOnCall == nil {
+		fake.listVersionsReturnsOnCall = make(map[int]struct {
+			result1 []catalog.Version
+		})
+	}
+	fake.listVersionsReturnsOnCall[i] = struct {
+		result1 []catalog.Version
+	}{result1}
+}
+
 func (fake *FakeCatalog) ProfilesGreaterThanVersion(arg1 logr.Logger, arg2 string, arg3 string, arg4 string) []v1alpha1.ProfileCatalogEntry {
 	fake.profilesGreaterThanVersionMutex.Lock()
 	ret, specificReturn := fake.profilesGreaterThanVersionReturnsOnCall[len(fake.profilesGreaterThanVersionArgsForCall)]
@@ -265,76 +419,79 @@ func (fake *FakeCatalog) ProfilesGreaterThanVersionReturnsOnCall(i int, result1
 	}{result1}
 }
 
-func (fake *FakeCatalog) Search(arg1 string) []v1alpha1.ProfileCatalogEntry {
-	fake.searchMutex.Lock()
-	ret, specificReturn := fake.searchReturnsOnCall[len(fake.searchArgsForCall)]
-	fake.searchArgsForCall = append(fake.searchArgsForCall, struct {
-		arg1 string
+func (fake *FakeCatalog) SearchWithFilter(arg1 catalog.Filter) ([]catalog.Match, error) {
+	fake.searchWithFilterMutex.Lock()
+	ret, specificReturn := fake.searchWithFilterReturnsOnCall[len(fake.searchWithFilterArgsForCall)]
+	fake.searchWithFilterArgsForCall = append(fake.searchWithFilterArgsForCall, struct {
+		arg1 catalog.Filter
 	}{arg1})
-	stub := fake.SearchStub
-	fakeReturns := fake.searchReturns
-	fake.recordInvocation("Search", []interface{}{arg1})
-	fake.searchMutex.Unlock()
+	stub := fake.SearchWithFilterStub
+	fakeReturns := fake.searchWithFilterReturns
+	fake.recordInvocation("SearchWithFilter", []interface{}{arg1})
+	fake.searchWithFilterMutex.Unlock()
 	if stub != nil {
 		return stub(arg1)
 	}
 	if specificReturn {
-		return ret.result1
+		return ret.result1, ret.result2
 	}
-	return fakeReturns.result1
+	return fakeReturns.result1, fakeReturns.result2
 }
 
-func (fake *FakeCatalog) SearchCallCount() int {
-	fake.searchMutex.RLock()
-	defer fake.searchMutex.RUnlock()
-	return len(fake.searchArgsForCall)
+func (fake *FakeCatalog) SearchWithFilterCallCount() int {
+	fake.searchWithFilterMutex.RLock()
+	defer fake.searchWithFilterMutex.RUnlock()
+	return len(fake.searchWithFilterArgsForCall)
 }
 
-func (fake *FakeCatalog) SearchCalls(stub func(string) []v1alpha1.ProfileCatalogEntry) {
-	fake.searchMutex.Lock()
-	defer fake.searchMutex.Unlock()
-	fake.SearchStub = stub
+func (fake *FakeCatalog) SearchWithFilterCalls(stub func(catalog.Filter) ([]catalog.Match, error)) {
+	fake.searchWithFilterMutex.Lock()
+	defer fake.searchWithFilterMutex.Unlock()
+	fake.SearchWithFilterStub = stub
 }
 
-func (fake *FakeCatalog) SearchArgsForCall(i int) string {
-	fake.searchMutex.RLock()
-	defer fake.searchMutex.RUnlock()
-	argsForCall := fake.searchArgsForCall[i]
+func (fake *FakeCatalog) SearchWithFilterArgsForCall(i int) catalog.Filter {
+	fake.searchWithFilterMutex.RLock()
+	defer fake.searchWithFilterMutex.RUnlock()
+	argsForCall := fake.searchWithFilterArgsForCall[i]
 	return argsForCall.arg1
 }
 
-func (fake *FakeCatalog) SearchReturns(result1 []v1alpha1.ProfileCatalogEntry) {
-	fake.searchMutex.Lock()
-	defer fake.searchMutex.Unlock()
-	fake.SearchStub = nil
-	fake.searchReturns = struct {
-		result1 []v1alpha1.ProfileCatalogEntry
-	}{result1}
-}
-
-func (fake *FakeCatalog) SearchReturnsOnCall(i int, result1 []v1alpha1.ProfileCatalogEntry) {
-	fake.searchMutex.Lock()
-	defer fake.searchMutex.Unlock()
-	fake.SearchStub = nil
-	if fake.searchReturnsOnCall == nil {
-		fake.searchReturnsOnCall = make(map[int]struct {
-			result1 []v1alpha1.ProfileCatalogEntry
+func (fake *FakeCatalog) SearchWithFilterReturns(result1 []catalog.Match, result2 error) {
+	fake.searchWithFilterMutex.Lock()
+	defer fake.searchWithFilterMutex.Unlock()
+	fake.SearchWithFilterStub = nil
+	fake.searchWithFilterReturns = struct {
+		result1 []catalog.Match
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeCatalog) SearchWithFilterReturnsOnCall(i int, result1 []catalog.Match, result2 error) {
+	fake.searchWithFilterMutex.Lock()
+	defer fake.searchWithFilterMutex.Unlock()
+	fake.SearchWithFilterStub = nil
+	if fake.searchWithFilterReturnsOnCall == nil {
+		fake.searchWithFilterReturnsOnCall = make(map[int]struct {
+			result1 []catalog.Match
+			result2 error
 		})
 	}
-	fake.searchReturnsOnCall[i] = struct {
-		result1 []v1alpha1.ProfileCatalogEntry
-	}{result1}
+	fake.searchWithFilterReturnsOnCall[i] = struct {
+		result1 []catalog.Match
+		result2 error
+	}{result1, result2}
 }
 
-func (fake *FakeCatalog) SearchAll() []v1alpha1.ProfileCatalogEntry {
-	fake.searchAllMutex.Lock()
-	ret, specificReturn := fake.searchAllReturnsOnCall[len(fake.searchAllArgsForCall)]
-	fake.searchAllArgsForCall = append(fake.searchAllArgsForCall, struct {
+func (fake *FakeCatalog) Sources() []catalog.Source {
+	fake.sourcesMutex.Lock()
+	ret, specificReturn := fake.sourcesReturnsOnCall[len(fake.sourcesArgsForCall)]
+	fake.sourcesArgsForCall = append(fake.sourcesArgsForCall, struct {
 	}{})
-	stub := fake.SearchAllStub
-	fakeReturns := fake.searchAllReturns
-	fake.recordInvocation("SearchAll", []interface{}{})
-	fake.searchAllMutex.Unlock()
+	stub := fake.SourcesStub
+	fakeReturns := fake.sourcesReturns
+	fake.recordInvocation("Sources", []interface{}{})
+	fake.sourcesMutex.Unlock()
 	if stub != nil {
 		return stub()
 	}
@@ -344,38 +501,38 @@ func (fake *FakeCatalog) SearchAll() []v1alpha1.ProfileCatalogEntry {
 	return fakeReturns.result1
 }
 
-func (fake *FakeCatalog) SearchAllCallCount() int {
-	fake.searchAllMutex.RLock()
-	defer fake.searchAllMutex.RUnlock()
-	return len(fake.searchAllArgsForCall)
+func (fake *FakeCatalog) SourcesCallCount() int {
+	fake.sourcesMutex.RLock()
+	defer fake.sourcesMutex.RUnlock()
+	return len(fake.sourcesArgsForCall)
 }
 
-func (fake *FakeCatalog) SearchAllCalls(stub func() []v1alpha1.ProfileCatalogEntry) {
-	fake.searchAllMutex.Lock()
-	defer fake.searchAllMutex.Unlock()
-	fake.SearchAllStub = stub
+func (fake *FakeCatalog) SourcesCalls(stub func() []catalog.Source) {
+	fake.sourcesMutex.Lock()
+	defer fake.sourcesMutex.Unlock()
+	fake.SourcesStub = stub
 }
 
-func (fake *FakeCatalog) SearchAllReturns(result1 []v1alpha1.ProfileCatalogEntry) {
-	fake.searchAllMutex.Lock()
-	defer fake.searchAllMutex.Unlock()
-	fake.SearchAllStub = nil
-	fake.searchAllReturns = struct {
-		result1 []v1alpha1.ProfileCatalogEntry
+func (fake *FakeCatalog) SourcesReturns(result1 []catalog.Source) {
+	fake.sourcesMutex.Lock()
+	defer fake.sourcesMutex.Unlock()
+	fake.SourcesStub = nil
+	fake.sourcesReturns = struct {
+		result1 []catalog.Source
 	}{result1}
 }
 
-func (fake *FakeCatalog) SearchAllReturnsOnCall(i int, result1 []v1alpha1.ProfileCatalogEntry) {
-	fake.searchAllMutex.Lock()
-	defer fake.searchAllMutex.Unlock()
-	fake.SearchAllStub = nil
-	if fake.searchAllReturnsOnCall == nil {
-		fake.searchAllReturnsOnCall = make(map[int]struct {
-			result1 []v1alpha1.ProfileCatalogEntry
+func (fake *FakeCatalog) SourcesReturnsOnCall(i int, result1 []catalog.Source) {
+	fake.sourcesMutex.Lock()
+	defer fake.sourcesMutex.Unlock()
+	fake.SourcesStub = nil
+	if fake.sourcesReturnsOnCall == nil {
+		fake.sourcesReturnsOnCall = make(map[int]struct {
+			result1 []catalog.Source
 		})
 	}
-	fake.searchAllReturnsOnCall[i] = struct {
-		result1 []v1alpha1.ProfileCatalogEntry
+	fake.sourcesReturnsOnCall[i] = struct {
+		result1 []catalog.Source
 	}{result1}
 }
 
@@ -384,14 +541,18 @@ func (fake *FakeCatalog) Invocations() map[string][][]interface{} {
 	defer fake.invocationsMutex.RUnlock()
 	fake.getMutex.RLock()
 	defer fake.getMutex.RUnlock()
+	fake.getSourceMutex.RLock()
+	defer fake.getSourceMutex.RUnlock()
 	fake.getWithVersionMutex.RLock()
 	defer fake.getWithVersionMutex.RUnlock()
+	fake.listVersionsMutex.RLock()
+	defer fake.listVersionsMutex.RUnlock()
 	fake.profilesGreaterThanVersionMutex.RLock()
 	defer fake.profilesGreaterThanVersionMutex.RUnlock()
-	fake.searchMutex.RLock()
-	defer fake.searchMutex.RUnlock()
-	fake.searchAllMutex.RLock()
-	defer fake.searchAllMutex.RUnlock()
+	fake.searchWithFilterMutex.RLock()
+	defer fake.searchWithFilterMutex.RUnlock()
+	fake.sourcesMutex.RLock()
+	defer fake.sourcesMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
 	for key, value := range fake.invocations {
 		copiedInvocations[key] = value
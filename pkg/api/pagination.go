@@ -0,0 +1,36 @@
+package api
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+
+	"github.com/weaveworks/profiles/pkg/catalog"
+)
+
+// paginate returns the page of the profiles starting at the page token, and the token of the
+// next page. Page tokens encode the offset of the page, and all profiles are returned when the
+// page size is 0.
+func paginate(profiles []catalog.Match, pageSize int32, pageToken string) ([]catalog.Match, string, error) {
+	if pageSize < 0 {
+		return nil, "", fmt.Errorf("invalid page size %d", pageSize)
+	}
+	offset := 0
+	if pageToken != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(pageToken)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid page token %q", pageToken)
+		}
+		if offset, err = strconv.Atoi(string(decoded)); err != nil || offset < 0 {
+			return nil, "", fmt.Errorf("invalid page token %q", pageToken)
+		}
+	}
+	if offset >= len(profiles) {
+		return nil, "", nil
+	}
+	if pageSize == 0 || offset+int(pageSize) >= len(profiles) {
+		return profiles[offset:], "", nil
+	}
+	end := offset + int(pageSize)
+	return profiles[offset:end], base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(end))), nil
+}
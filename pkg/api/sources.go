@@ -0,0 +1,51 @@
+package api
+
+import (
+	"google.golang.org/protobuf/types/known/timestamppb"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	profilesv1 "github.com/weaveworks/profiles/api/v1alpha1"
+	"github.com/weaveworks/profiles/pkg/catalog"
+	"github.com/weaveworks/profiles/pkg/protos"
+)
+
+// transformSource creates a proto catalog source out of a catalog source and its status. The
+// error state of the source and its repositories is read from their Failed condition.
+func transformSource(source catalog.Source) *protos.CatalogSource {
+	result := &protos.CatalogSource{
+		Name:         source.Name,
+		ProfileCount: int32(source.Profiles),
+		VersionCount: int32(source.Versions),
+		LastScanTime: transformTime(source.Status.LastScanTime),
+	}
+	result.Failed, result.Error = failure(source.Status.Conditions)
+	for _, repo := range source.Status.ScannedRepositories {
+		scanned := &protos.ScannedRepository{
+			Url:          repo.URL,
+			TagCount:     int32(len(repo.Tags)),
+			LastScanTime: transformTime(repo.LastScanTime),
+		}
+		scanned.Failed, scanned.Error = failure(repo.Conditions)
+		for _, failedTag := range repo.FailedTags {
+			scanned.FailedTags = append(scanned.FailedTags, failedTag.Tag)
+		}
+		result.ScannedRepositories = append(result.ScannedRepositories, scanned)
+	}
+	return result
+}
+
+// failure returns whether the Failed condition is true, and its message.
+func failure(conditions []metav1.Condition) (bool, string) {
+	if !apimeta.IsStatusConditionTrue(conditions, profilesv1.ConditionTypeFailed) {
+		return false, ""
+	}
+	return true, apimeta.FindStatusCondition(conditions, profilesv1.ConditionTypeFailed).Message
+}
+
+func transformTime(t *metav1.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(t.Time)
+}
@@ -1,90 +1,193 @@
 package catalog
 
 import (
-	"sort"
-	"strings"
 	"sync"
 
-	"github.com/Masterminds/semver/v3"
 	"github.com/fluxcd/pkg/version"
 	"github.com/go-logr/logr"
 
 	profilesv1 "github.com/weaveworks/profiles/api/v1alpha1"
 )
 
+// ConflictPolicy decides which profile a catalog source keeps when two of its repositories
+// publish a profile with the same name and version.
+type ConflictPolicy string
+
+const (
+	// KeepExisting keeps the profile already in the catalog and drops the new one.
+	KeepExisting ConflictPolicy = "keep-existing"
+	// ReplaceExisting replaces the profile already in the catalog with the new one.
+	ReplaceExisting ConflictPolicy = "replace-existing"
+)
+
 // Catalog provides an in-memory cache of profiles from the cluster which can be queried easily.
-//type Catalog map[string][]profilesv1.ProfileCatalogEntry
+// The profiles of each catalog source are stored in an indexed snapshot which is replaced as a
+// whole on every change, so that queries never wait for scans to update the catalog.
 type Catalog struct {
+	// m maps the name of a catalog source to its *snapshot
 	m sync.Map
+	// statuses maps the name of a catalog source to its *profilesv1.ProfileCatalogSourceStatus
+	statuses sync.Map
+	// mu serialises the changes to the catalog
+	mu sync.Mutex
+	// ConflictPolicy is applied by Append to profiles with the same name and version from
+	// different repositories. Defaults to KeepExisting.
+	ConflictPolicy ConflictPolicy
 }
 
 // New creates a new, empty catalog.
 func New() *Catalog {
-	return &Catalog{
-		m: sync.Map{},
-	}
+	return &Catalog{}
 }
 
-// Append the existing profiles with new profiles
-func (c *Catalog) Append(sourceName string, profiles ...profilesv1.ProfileCatalogEntry) {
-	existingProfiles, ok := c.m.Load(sourceName)
+func (c *Catalog) load(sourceName string) (*snapshot, bool) {
+	s, ok := c.m.Load(sourceName)
 	if !ok {
-		c.AddOrReplace(sourceName, profiles...)
-		return
+		return nil, false
+	}
+	return s.(*snapshot), true
+}
+
+// Append adds new profiles to the existing profiles. A profile with the same name, tag and
+// url as an existing profile replaces it. A profile with the same name and version as an
+// existing profile from another repository is a conflict resolved with the ConflictPolicy,
+// and the dropped profiles are returned. Valid semver versions are compared parsed, so that
+// "v1.0.0" and "1.0.0" conflict.
+func (c *Catalog) Append(sourceName string, profiles ...profilesv1.ProfileCatalogEntry) []profilesv1.ProfileCatalogEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	existing, _ := c.load(sourceName)
+	var (
+		names   []string
+		changed = make(map[string]*versions)
+		dropped []profilesv1.ProfileCatalogEntry
+	)
+	for _, p := range profiles {
+		v, ok := changed[p.Name]
+		if !ok {
+			names = append(names, p.Name)
+			if existing != nil {
+				v = existing.profiles[p.Name].clone()
+			} else {
+				v = newVersions(nil)
+			}
+			changed[p.Name] = v
+		}
+		dropped = c.upsert(v, newEntry(sourceName, p), dropped)
+	}
+	for _, v := range changed {
+		v.sort()
+	}
+	c.m.Store(sourceName, existing.with(names, changed))
+	return dropped
+}
+
+// upsert adds an entry to the versions of a profile, replacing the entry with the same tag
+// and url. Conflicting entries are resolved with the ConflictPolicy and appended to dropped.
+func (c *Catalog) upsert(v *versions, e entry, dropped []profilesv1.ProfileCatalogEntry) []profilesv1.ProfileCatalogEntry {
+	if i, ok := v.byKey[keyOf(e.profile)]; ok {
+		v.set(i, e)
+		return dropped
+	}
+	for _, i := range v.bySameVersion[e.sameVersion()] {
+		if v.entries[i].profile.URL != e.profile.URL {
+			if c.ConflictPolicy == ReplaceExisting {
+				dropped = append(dropped, v.entries[i].profile)
+				v.set(i, e)
+			} else {
+				dropped = append(dropped, e.profile)
+			}
+			return dropped
+		}
 	}
-	c.AddOrReplace(sourceName, append(existingProfiles.([]profilesv1.ProfileCatalogEntry), profiles...)...)
+	v.add(e)
+	return dropped
 }
 
 // AddOrReplace replaces the catalog by replacing existing profiles with new profiles if it exists
 // otherwise it creates it
 func (c *Catalog) AddOrReplace(sourceName string, profiles ...profilesv1.ProfileCatalogEntry) {
-	for i := range profiles {
-		profiles[i].CatalogSource = sourceName
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.m.Store(sourceName, newSnapshotOf(sourceName, profiles))
+}
+
+// AddIfNotExists adds the profiles to the catalog unless it already exists, reporting
+// whether they were added.
+func (c *Catalog) AddIfNotExists(sourceName string, profiles ...profilesv1.ProfileCatalogEntry) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.load(sourceName); ok {
+		return false
 	}
-	c.m.Store(sourceName, profiles)
+	c.m.Store(sourceName, newSnapshotOf(sourceName, profiles))
+	return true
 }
 
-// Remove removes the specified catalog.
-func (c *Catalog) Remove(sourceName string) {
-	c.m.Delete(sourceName)
+// List returns a copy of the profiles in the specified catalog.
+func (c *Catalog) List(sourceName string) []profilesv1.ProfileCatalogEntry {
+	s, ok := c.load(sourceName)
+	if !ok {
+		return nil
+	}
+	return s.list()
 }
 
-// Search returns profile descriptions that contain `name` in their names.
-func (c *Catalog) Search(name string) []profilesv1.ProfileCatalogEntry {
-	var ret []profilesv1.ProfileCatalogEntry
-	c.m.Range(func(key, value interface{}) bool {
-		for _, p := range value.([]profilesv1.ProfileCatalogEntry) {
-			if strings.Contains(p.Name, name) {
-				ret = append(ret, p)
+// RemoveIf removes the profiles of the specified catalog for which remove returns true,
+// and returns them.
+func (c *Catalog) RemoveIf(sourceName string, remove func(profilesv1.ProfileCatalogEntry) bool) []profilesv1.ProfileCatalogEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.load(sourceName)
+	if !ok {
+		return nil
+	}
+	var (
+		names   []string
+		changed = make(map[string]*versions)
+		removed []profilesv1.ProfileCatalogEntry
+	)
+	for _, name := range s.names {
+		var kept []entry
+		for _, e := range s.entries(name) {
+			if remove(e.profile) {
+				removed = append(removed, e.profile)
+			} else {
+				kept = append(kept, e)
 			}
 		}
-		return true
-	})
-	return ret
+		if len(kept) < len(s.entries(name)) {
+			names = append(names, name)
+			changed[name] = newVersions(kept)
+		}
+	}
+	if len(removed) > 0 {
+		c.m.Store(sourceName, s.with(names, changed))
+	}
+	return removed
 }
 
-// SearchAll returns `all` profile descriptions.
-func (c *Catalog) SearchAll() []profilesv1.ProfileCatalogEntry {
-	var ret []profilesv1.ProfileCatalogEntry
-	c.m.Range(func(key, value interface{}) bool {
-		ret = append(ret, value.([]profilesv1.ProfileCatalogEntry)...)
-		return true
-	})
-	return ret
+// Remove removes the specified catalog and its status.
+func (c *Catalog) Remove(sourceName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.m.Delete(sourceName)
+	c.statuses.Delete(sourceName)
 }
 
 // Get returns the profile description `profileName`.
 func (c *Catalog) Get(sourceName, profileName string) *profilesv1.ProfileCatalogEntry {
-	profiles, ok := c.m.Load(sourceName)
+	s, ok := c.load(sourceName)
 	if !ok {
 		return nil
 	}
-	for _, p := range profiles.([]profilesv1.ProfileCatalogEntry) {
-		if p.Name == profileName && p.CatalogSource == sourceName {
-			return &p
-		}
+	entries := s.entries(profileName)
+	if len(entries) == 0 {
+		return nil
 	}
-	return nil
+	p := entries[0].profile
+	return &p
 }
 
 // CatalogExists checks if the catalog exists
@@ -95,37 +198,35 @@ func (c *Catalog) CatalogExists(sourceName string) bool {
 
 // GetWithVersion returns the profile description `profileName` with the given version.
 func (c *Catalog) GetWithVersion(logger logr.Logger, sourceName, profileName, profileVersion string) *profilesv1.ProfileCatalogEntry {
-	profiles, ok := c.m.Load(sourceName)
+	s, ok := c.load(sourceName)
+	if !ok {
+		return nil
+	}
+	v, ok := s.profiles[profileName]
 	if !ok {
 		return nil
 	}
 
+	var i int
 	if profileVersion == "latest" {
-		versions := c.ProfilesGreaterThanVersion(logger, sourceName, profileName, profileVersion)
-		if len(versions) == 0 {
+		logInvalidVersions(logger, v)
+		if len(v.sorted) == 0 {
 			return nil
 		}
-		return &versions[0]
-	}
-
-	for _, p := range profiles.([]profilesv1.ProfileCatalogEntry) {
-		if p.Name == profileName && p.CatalogSource == sourceName && profilesv1.GetVersionFromTag(p.Tag) == profileVersion {
-			return &p
-		}
+		i = v.sorted[0]
+	} else if indexes, ok := v.byVersion[profileVersion]; ok {
+		i = indexes[0]
+	} else {
+		return nil
 	}
-	return nil
-}
-
-type profileDescriptionWithVersion struct {
-	profileDescription profilesv1.ProfileCatalogEntry
-	semverVersion      *semver.Version
+	p := v.entries[i].profile
+	return &p
 }
 
 // ProfilesGreaterThanVersion returns all profiles which are of a greater version for a given profile with a version.
 // If set to "latest" all versions are returned. Versions are ordered in descending order
 func (c *Catalog) ProfilesGreaterThanVersion(logger logr.Logger, sourceName, profileName, profileVersion string) []profilesv1.ProfileCatalogEntry {
-	var profilesWithValidVersion []profileDescriptionWithVersion
-	profiles, ok := c.m.Load(sourceName)
+	s, ok := c.load(sourceName)
 	if !ok {
 		return nil
 	}
@@ -133,30 +234,61 @@ func (c *Catalog) ProfilesGreaterThanVersion(logger logr.Logger, sourceName, pro
 	if err != nil && profileVersion != "latest" {
 		return nil
 	}
-	for _, p := range profiles.([]profilesv1.ProfileCatalogEntry) {
-		tag := profilesv1.GetVersionFromTag(p.Tag)
-		v, err := version.ParseVersion(tag)
-		if err != nil {
-			logger.Error(err, "failed to parse profile version", "profile", p, "tag", tag, "pTag", p.Tag)
-			continue
-		}
-		if p.Name == profileName {
-			if profileVersion == "latest" || v.GreaterThan(cv) {
-				profilesWithValidVersion = append(profilesWithValidVersion, profileDescriptionWithVersion{profileDescription: p, semverVersion: v})
-			}
+	v, ok := s.profiles[profileName]
+	if !ok {
+		return nil
+	}
+	logInvalidVersions(logger, v)
+
+	var result []profilesv1.ProfileCatalogEntry
+	for _, i := range v.sorted {
+		if profileVersion != "latest" && !v.entries[i].semver.GreaterThan(cv) {
+			break
 		}
+		result = append(result, v.entries[i].profile)
 	}
+	return result
+}
+
+// Version is a version of a profile.
+type Version struct {
+	// Version is the version of the profile as found in its tag
+	Version string
+	// Prerelease is whether the version is a semver pre-release
+	Prerelease bool
+	// Profile is the profile with the version
+	Profile profilesv1.ProfileCatalogEntry
+}
 
-	if len(profilesWithValidVersion) == 0 {
+// ListVersions returns all versions of the profile `profileName` in descending order. Versions
+// which are not valid semver come last, in the order they were added. Nil is returned when the
+// profile is not in the catalog.
+func (c *Catalog) ListVersions(sourceName, profileName string) []Version {
+	s, ok := c.load(sourceName)
+	if !ok {
 		return nil
 	}
-
-	sort.SliceStable(profilesWithValidVersion, func(i, j int) bool {
-		return profilesWithValidVersion[j].semverVersion.LessThan(profilesWithValidVersion[i].semverVersion)
-	})
-	var result []profilesv1.ProfileCatalogEntry
-	for _, p := range profilesWithValidVersion {
-		result = append(result, p.profileDescription)
+	v, ok := s.profiles[profileName]
+	if !ok {
+		return nil
+	}
+	result := make([]Version, 0, len(v.entries))
+	for _, i := range v.sorted {
+		e := v.entries[i]
+		result = append(result, Version{Version: e.version, Prerelease: e.semver.Prerelease() != "", Profile: e.profile})
+	}
+	for _, e := range v.entries {
+		if e.semver == nil {
+			result = append(result, Version{Version: e.version, Profile: e.profile})
+		}
 	}
 	return result
 }
+
+func logInvalidVersions(logger logr.Logger, v *versions) {
+	for _, e := range v.entries {
+		if e.err != nil {
+			logger.Error(e.err, "failed to parse profile version", "profile", e.profile, "tag", e.version, "pTag", e.profile.Tag)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package catalog_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/go-logr/logr"
+
+	profilesv1 "github.com/weaveworks/profiles/api/v1alpha1"
+	"github.com/weaveworks/profiles/pkg/catalog"
+)
+
+const (
+	benchmarkProfiles = 200
+	benchmarkVersions = 25
+)
+
+// newBenchmarkCatalog returns a catalog source with benchmarkProfiles profiles, each with
+// benchmarkVersions versions.
+func newBenchmarkCatalog() *catalog.Catalog {
+	var profiles []profilesv1.ProfileCatalogEntry
+	for i := 0; i < benchmarkProfiles; i++ {
+		for v := 0; v < benchmarkVersions; v++ {
+			profiles = append(profiles, profilesv1.ProfileCatalogEntry{
+				Name: fmt.Sprintf("profile-%d", i),
+				Tag:  fmt.Sprintf("profile-%d/v0.%d.0", i, v),
+				URL:  "https://github.com/org/repo",
+			})
+		}
+	}
+	c := catalog.New()
+	c.Append("source", profiles...)
+	return c
+}
+
+func BenchmarkGet(b *testing.B) {
+	c := newBenchmarkCatalog()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if c.Get("source", fmt.Sprintf("profile-%d", i%benchmarkProfiles)) == nil {
+			b.Fatal("profile not found")
+		}
+	}
+}
+
+func BenchmarkGetWithVersion(b *testing.B) {
+	c := newBenchmarkCatalog()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if c.GetWithVersion(logr.Discard(), "source", fmt.Sprintf("profile-%d", i%benchmarkProfiles), "v0.10.0") == nil {
+			b.Fatal("profile not found")
+		}
+	}
+}
+
+func BenchmarkGetWithLatestVersion(b *testing.B) {
+	c := newBenchmarkCatalog()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if c.GetWithVersion(logr.Discard(), "source", fmt.Sprintf("profile-%d", i%benchmarkProfiles), "latest") == nil {
+			b.Fatal("profile not found")
+		}
+	}
+}
+
+func BenchmarkProfilesGreaterThanVersion(b *testing.B) {
+	c := newBenchmarkCatalog()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if len(c.ProfilesGreaterThanVersion(logr.Discard(), "source", fmt.Sprintf("profile-%d", i%benchmarkProfiles), "v0.20.0")) != 4 {
+			b.Fatal("unexpected profiles")
+		}
+	}
+}
+
+func BenchmarkSearchWithFilter(b *testing.B) {
+	c := newBenchmarkCatalog()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		matches, err := c.SearchWithFilter(catalog.Filter{Name: "profile-19"})
+		if err != nil || len(matches) == 0 {
+			b.Fatal("profile not found")
+		}
+	}
+}
+
+func BenchmarkAppend(b *testing.B) {
+	c := newBenchmarkCatalog()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Append("source", profilesv1.ProfileCatalogEntry{
+			Name: fmt.Sprintf("profile-%d", i%benchmarkProfiles),
+			Tag:  fmt.Sprintf("profile-%d/v1.0.0", i%benchmarkProfiles),
+			URL:  "https://github.com/org/repo",
+		})
+	}
+}
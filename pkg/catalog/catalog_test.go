@@ -1,9 +1,12 @@
 package catalog_test
 
 import (
+	"fmt"
+
 	"github.com/go-logr/logr"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	profilesv1 "github.com/weaveworks/profiles/api/v1alpha1"
 	"github.com/weaveworks/profiles/pkg/catalog"
@@ -33,18 +36,12 @@ var _ = Describe("Catalog", func() {
 		Expect(c.CatalogExists(catName)).To(BeTrue())
 
 		By("returning all the profiles available")
-		Expect(c.SearchAll()).To(ConsistOf(
+		Expect(c.List(catName)).To(ConsistOf(
 			profilesv1.ProfileCatalogEntry{Name: "foo", CatalogSource: catName},
 			profilesv1.ProfileCatalogEntry{Name: "bar", CatalogSource: catName},
 			profilesv1.ProfileCatalogEntry{Name: "alsofoo", CatalogSource: catName},
 		))
 
-		By("returning all matching profiles based on query string")
-		Expect(c.Search("foo")).To(ConsistOf(
-			profilesv1.ProfileCatalogEntry{Name: "foo", CatalogSource: catName},
-			profilesv1.ProfileCatalogEntry{Name: "alsofoo", CatalogSource: catName},
-		))
-
 		By("getting details for a specific named profile in a catalog")
 		Expect(c.Get(catName, "foo")).To(Equal(
 			&profilesv1.ProfileCatalogEntry{Name: "foo", CatalogSource: catName},
@@ -56,21 +53,160 @@ var _ = Describe("Catalog", func() {
 			{Name: "bar"},
 		}
 		c.AddOrReplace(catName, profiles...)
-		Expect(c.Search("foo")).To(ConsistOf(profilesv1.ProfileCatalogEntry{Name: "foo", CatalogSource: catName}))
+		Expect(c.List(catName)).To(ConsistOf(
+			profilesv1.ProfileCatalogEntry{Name: "foo", CatalogSource: catName},
+			profilesv1.ProfileCatalogEntry{Name: "bar", CatalogSource: catName},
+		))
 
 		By("appending profiles in a catalog source")
 		profiles = []profilesv1.ProfileCatalogEntry{
 			{Name: "bar-2"},
 		}
 		c.Append(catName, profiles...)
-		Expect(c.Search("bar")).To(ConsistOf(
+		Expect(c.List(catName)).To(ConsistOf(
+			profilesv1.ProfileCatalogEntry{Name: "foo", CatalogSource: catName},
 			profilesv1.ProfileCatalogEntry{Name: "bar", CatalogSource: catName},
 			profilesv1.ProfileCatalogEntry{Name: "bar-2", CatalogSource: catName},
 		))
+		Expect(c.List("nope")).To(BeNil())
 
 		By("removing a catalog source")
 		c.Remove(catName)
-		Expect(c.Search("foo")).To(BeEmpty())
+		Expect(c.CatalogExists(catName)).To(BeFalse())
+		Expect(c.Get(catName, "foo")).To(BeNil())
+	})
+
+	Describe("Append", func() {
+		It("replaces the profiles with the same name, tag and url", func() {
+			c.Append(catName,
+				profilesv1.ProfileCatalogEntry{Name: "foo", Tag: "v0.1.0", URL: "https://github.com/org/repo"},
+				profilesv1.ProfileCatalogEntry{Name: "foo", Tag: "v0.2.0", URL: "https://github.com/org/repo"},
+			)
+			dropped := c.Append(catName,
+				profilesv1.ProfileCatalogEntry{Name: "foo", Tag: "v0.1.0", URL: "https://github.com/org/repo", ProfileDescription: profilesv1.ProfileDescription{Description: "rescanned"}},
+			)
+			Expect(dropped).To(BeEmpty())
+			Expect(c.List(catName)).To(ConsistOf(
+				profilesv1.ProfileCatalogEntry{Name: "foo", Tag: "v0.1.0", URL: "https://github.com/org/repo", CatalogSource: catName, ProfileDescription: profilesv1.ProfileDescription{Description: "rescanned"}},
+				profilesv1.ProfileCatalogEntry{Name: "foo", Tag: "v0.2.0", URL: "https://github.com/org/repo", CatalogSource: catName},
+			))
+		})
+
+		When("two repositories publish the same profile version", func() {
+			var (
+				existing = profilesv1.ProfileCatalogEntry{Name: "foo", Tag: "v0.1.0", URL: "https://github.com/org/repo"}
+				conflict = profilesv1.ProfileCatalogEntry{Name: "foo", Tag: "foo/v0.1.0", URL: "https://github.com/org/fork"}
+				other    = profilesv1.ProfileCatalogEntry{Name: "foo", Tag: "v0.2.0", URL: "https://github.com/org/fork"}
+			)
+
+			It("keeps the existing profile by default", func() {
+				c.Append(catName, existing)
+				dropped := c.Append(catName, conflict, other)
+				Expect(dropped).To(ConsistOf(withSource(conflict, catName)))
+				Expect(c.List(catName)).To(ConsistOf(withSource(existing, catName), withSource(other, catName)))
+			})
+
+			It("replaces the existing profile with the ReplaceExisting policy", func() {
+				c.ConflictPolicy = catalog.ReplaceExisting
+				c.Append(catName, existing)
+				dropped := c.Append(catName, conflict, other)
+				Expect(dropped).To(ConsistOf(withSource(existing, catName)))
+				Expect(c.List(catName)).To(ConsistOf(withSource(conflict, catName), withSource(other, catName)))
+				replaced := withSource(conflict, catName)
+				Expect(c.GetWithVersion(logger, catName, "foo", "v0.1.0")).To(Equal(&replaced))
+
+				By("replacing the profile again when the existing repository publishes it")
+				dropped = c.Append(catName, existing)
+				Expect(dropped).To(ConsistOf(withSource(conflict, catName)))
+				Expect(c.List(catName)).To(ConsistOf(withSource(existing, catName), withSource(other, catName)))
+			})
+
+			It("compares the parsed versions", func() {
+				c.Append(catName, existing)
+				unprefixed := profilesv1.ProfileCatalogEntry{Name: "foo", Tag: "0.1.0+build.1", URL: "https://github.com/org/fork"}
+				invalid := profilesv1.ProfileCatalogEntry{Name: "foo", Tag: "main", URL: "https://github.com/org/repo"}
+				sameInvalid := profilesv1.ProfileCatalogEntry{Name: "foo", Tag: "main", URL: "https://github.com/org/fork"}
+				dropped := c.Append(catName, unprefixed, invalid, sameInvalid)
+				Expect(dropped).To(ConsistOf(withSource(unprefixed, catName), withSource(sameInvalid, catName)))
+				Expect(c.List(catName)).To(ConsistOf(withSource(existing, catName), withSource(invalid, catName)))
+			})
+		})
+	})
+
+	When("profiles are changed while the catalog is queried", func() {
+		It("serves the profiles before or after the change", func() {
+			c.Append(catName, profilesv1.ProfileCatalogEntry{Name: "foo", Tag: "v0.1.0"})
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				defer close(done)
+				for i := 0; i < 100; i++ {
+					c.Append(catName, profilesv1.ProfileCatalogEntry{Name: "foo", Tag: fmt.Sprintf("v1.%d.0", i)})
+					c.RemoveIf(catName, func(p profilesv1.ProfileCatalogEntry) bool { return p.Tag == fmt.Sprintf("v1.%d.0", i) })
+				}
+			}()
+			Consistently(func() []profilesv1.ProfileCatalogEntry {
+				return c.ProfilesGreaterThanVersion(logger, catName, "foo", "v0.0.1")
+			}, "100ms", "1ms").Should(Or(HaveLen(1), HaveLen(2)))
+			Eventually(done).Should(BeClosed())
+			Expect(c.List(catName)).To(ConsistOf(profilesv1.ProfileCatalogEntry{Name: "foo", Tag: "v0.1.0", CatalogSource: catName}))
+		})
+	})
+
+	Describe("AddIfNotExists", func() {
+		It("only adds the profiles when the catalog does not exist", func() {
+			Expect(c.AddIfNotExists(catName, profilesv1.ProfileCatalogEntry{Name: "foo"})).To(BeTrue())
+			Expect(c.AddIfNotExists(catName, profilesv1.ProfileCatalogEntry{Name: "bar"})).To(BeFalse())
+			Expect(c.List(catName)).To(ConsistOf(profilesv1.ProfileCatalogEntry{Name: "foo", CatalogSource: catName}))
+		})
+	})
+
+	Describe("RemoveIf", func() {
+		It("removes and returns the matching profiles", func() {
+			c.AddOrReplace(catName, profilesv1.ProfileCatalogEntry{Name: "foo"}, profilesv1.ProfileCatalogEntry{Name: "bar"})
+
+			removed := c.RemoveIf(catName, func(p profilesv1.ProfileCatalogEntry) bool { return p.Name == "foo" })
+			Expect(removed).To(ConsistOf(profilesv1.ProfileCatalogEntry{Name: "foo", CatalogSource: catName}))
+			Expect(c.List(catName)).To(ConsistOf(profilesv1.ProfileCatalogEntry{Name: "bar", CatalogSource: catName}))
+			Expect(c.RemoveIf("nope", func(profilesv1.ProfileCatalogEntry) bool { return true })).To(BeNil())
+		})
+	})
+
+	Describe("Sources", func() {
+		It("lists the catalog sources with their profile counts and status", func() {
+			now := metav1.Now()
+			c.AddOrReplace(catName, profilesv1.ProfileCatalogEntry{Name: "foo", Tag: "v0.1.0"}, profilesv1.ProfileCatalogEntry{Name: "foo", Tag: "v0.2.0"}, profilesv1.ProfileCatalogEntry{Name: "bar", Tag: "v0.1.0"})
+			c.SetStatus(catName, profilesv1.ProfileCatalogSourceStatus{LastScanTime: &now})
+			c.AddOrReplace("failing")
+			c.SetStatus("failing", profilesv1.ProfileCatalogSourceStatus{ScannedRepositories: []profilesv1.ScannedRepository{{URL: "github.com/org/repo"}}})
+
+			Expect(c.Sources()).To(Equal([]catalog.Source{
+				{Name: "failing", Status: profilesv1.ProfileCatalogSourceStatus{ScannedRepositories: []profilesv1.ScannedRepository{{URL: "github.com/org/repo"}}}},
+				{Name: catName, Profiles: 2, Versions: 3, Status: profilesv1.ProfileCatalogSourceStatus{LastScanTime: &now}},
+			}))
+			source, ok := c.GetSource(catName)
+			Expect(ok).To(BeTrue())
+			Expect(source).To(Equal(catalog.Source{Name: catName, Profiles: 2, Versions: 3, Status: profilesv1.ProfileCatalogSourceStatus{LastScanTime: &now}}))
+
+			c.Remove("failing")
+			_, ok = c.GetSource("failing")
+			Expect(ok).To(BeFalse())
+			Expect(c.Sources()).To(HaveLen(1))
+		})
+
+		When("the catalog source is not in the catalog", func() {
+			It("does not record its status", func() {
+				c.SetStatus("removed", profilesv1.ProfileCatalogSourceStatus{ObservedGeneration: 1})
+				_, ok := c.GetSource("removed")
+				Expect(ok).To(BeFalse())
+
+				By("not restoring the status when the catalog source is added again")
+				c.AddOrReplace("removed")
+				source, ok := c.GetSource("removed")
+				Expect(ok).To(BeTrue())
+				Expect(source).To(Equal(catalog.Source{Name: "removed"}))
+			})
+		})
 	})
 
 	Describe("GetWithVersion", func() {
@@ -88,6 +224,23 @@ var _ = Describe("Catalog", func() {
 			))
 		})
 
+		When("the profiles were matched with a tag pattern", func() {
+			It("returns the profile with the matching version", func() {
+				profiles := []profilesv1.ProfileCatalogEntry{
+					{Name: "foo", Tag: "profiles/foo/v0.1.0", TagPattern: "{path}/v{version}"},
+					{Name: "foo", Tag: "profiles/foo/v0.2.0", TagPattern: "{path}/v{version}"},
+				}
+				c.AddOrReplace(catName, profiles...)
+
+				Expect(c.GetWithVersion(logger, catName, "foo", "0.1.0")).To(Equal(
+					&profilesv1.ProfileCatalogEntry{Name: "foo", Tag: "profiles/foo/v0.1.0", TagPattern: "{path}/v{version}", CatalogSource: catName},
+				))
+				Expect(c.GetWithVersion(logger, catName, "foo", "latest")).To(Equal(
+					&profilesv1.ProfileCatalogEntry{Name: "foo", Tag: "profiles/foo/v0.2.0", TagPattern: "{path}/v{version}", CatalogSource: catName},
+				))
+			})
+		})
+
 		When("version is set to latest", func() {
 			It("returns the latest version", func() {
 				profiles := []profilesv1.ProfileCatalogEntry{{Name: "foo", Tag: "foo/v0.1.0"}, {Name: "foo", Tag: "foo/0.2.0"}, {Name: "bar", Tag: "bar/0.3.0"}, {Name: "foo"}}
@@ -115,6 +268,160 @@ var _ = Describe("Catalog", func() {
 		})
 	})
 
+	Describe("SearchWithFilter", func() {
+		// tags returns the catalog source, name and tag of the profiles
+		tags := func(profiles []catalog.Match) []string {
+			var result []string
+			for _, p := range profiles {
+				result = append(result, fmt.Sprintf("%s/%s:%s", p.CatalogSource, p.Name, p.Tag))
+			}
+			return result
+		}
+
+		BeforeEach(func() {
+			c.Append(catName,
+				profilesv1.ProfileCatalogEntry{Name: "bitnami-nginx", Tag: "v0.1.0", ProfileDescription: profilesv1.ProfileDescription{
+					Description: "Deploys the Bitnami NGINX chart", Maintainer: "Bitnami", Prerequisites: []string{"Kubernetes 1.19"},
+				}},
+				profilesv1.ProfileCatalogEntry{Name: "nginx", Tag: "v0.1.0", ProfileDescription: profilesv1.ProfileDescription{
+					Description: "Deploys NGINX", Maintainer: "weaveworks",
+				}},
+				profilesv1.ProfileCatalogEntry{Name: "nginx", Tag: "v1.0.0", ProfileDescription: profilesv1.ProfileDescription{
+					Description: "Deploys NGINX", Maintainer: "weaveworks", Prerequisites: []string{"kubernetes 1.19", "flux"},
+				}},
+				profilesv1.ProfileCatalogEntry{Name: "nginx-ingress", Tag: "v2.0.0"},
+				profilesv1.ProfileCatalogEntry{Name: "no-ingress-gateway", Tag: "v0.1.0"},
+			)
+			c.Append("other", profilesv1.ProfileCatalogEntry{Name: "NGINX", Tag: "v3.0.0"})
+		})
+
+		It("ranks the profiles by how well their name matches", func() {
+			Expect(c.SearchWithFilter(catalog.Filter{Name: "Nginx"})).To(WithTransform(tags, Equal([]string{
+				"other/NGINX:v3.0.0",
+				"whiskers/nginx:v1.0.0",
+				"whiskers/nginx:v0.1.0",
+				"whiskers/nginx-ingress:v2.0.0",
+				"whiskers/bitnami-nginx:v0.1.0",
+			})))
+		})
+
+		It("matches the names fuzzily", func() {
+			Expect(c.SearchWithFilter(catalog.Filter{Name: "ngx", Fuzzy: true})).To(WithTransform(tags, Equal([]string{
+				"other/NGINX:v3.0.0",
+				"whiskers/nginx:v1.0.0",
+				"whiskers/nginx:v0.1.0",
+				"whiskers/nginx-ingress:v2.0.0",
+				"whiskers/bitnami-nginx:v0.1.0",
+			})))
+			Expect(c.SearchWithFilter(catalog.Filter{Name: "ngx"})).To(BeEmpty())
+		})
+
+		It("filters the profiles by description, maintainer and prerequisites", func() {
+			Expect(c.SearchWithFilter(catalog.Filter{Description: "bitnami"})).To(WithTransform(tags, ConsistOf("whiskers/bitnami-nginx:v0.1.0")))
+			Expect(c.SearchWithFilter(catalog.Filter{Maintainer: "WEAVEWORKS"})).To(WithTransform(tags, ConsistOf("whiskers/nginx:v0.1.0", "whiskers/nginx:v1.0.0")))
+			Expect(c.SearchWithFilter(catalog.Filter{Prerequisites: []string{"kubernetes 1.19"}})).To(WithTransform(tags, ConsistOf("whiskers/bitnami-nginx:v0.1.0", "whiskers/nginx:v1.0.0")))
+			Expect(c.SearchWithFilter(catalog.Filter{Prerequisites: []string{"kubernetes 1.19", "flux"}})).To(WithTransform(tags, ConsistOf("whiskers/nginx:v1.0.0")))
+		})
+
+		It("filters the profiles by catalog source and version range", func() {
+			Expect(c.SearchWithFilter(catalog.Filter{Name: "nginx", SourceName: "other"})).To(WithTransform(tags, ConsistOf("other/NGINX:v3.0.0")))
+			Expect(c.SearchWithFilter(catalog.Filter{Name: "nginx", VersionRange: ">=1.0.0 <3.0.0"})).To(WithTransform(tags, Equal([]string{
+				"whiskers/nginx:v1.0.0",
+				"whiskers/nginx-ingress:v2.0.0",
+			})))
+		})
+
+		It("sorts the profiles by name, version or catalog source", func() {
+			Expect(c.SearchWithFilter(catalog.Filter{Name: "nginx", SortBy: catalog.SortByName})).To(WithTransform(tags, Equal([]string{
+				"whiskers/bitnami-nginx:v0.1.0",
+				"other/NGINX:v3.0.0",
+				"whiskers/nginx:v1.0.0",
+				"whiskers/nginx:v0.1.0",
+				"whiskers/nginx-ingress:v2.0.0",
+			})))
+			Expect(c.SearchWithFilter(catalog.Filter{Name: "nginx", SortBy: catalog.SortByVersion})).To(WithTransform(tags, Equal([]string{
+				"other/NGINX:v3.0.0",
+				"whiskers/nginx-ingress:v2.0.0",
+				"whiskers/nginx:v1.0.0",
+				"whiskers/bitnami-nginx:v0.1.0",
+				"whiskers/nginx:v0.1.0",
+			})))
+			Expect(c.SearchWithFilter(catalog.Filter{Name: "nginx", SortBy: catalog.SortBySource})).To(WithTransform(tags, Equal([]string{
+				"other/NGINX:v3.0.0",
+				"whiskers/bitnami-nginx:v0.1.0",
+				"whiskers/nginx:v1.0.0",
+				"whiskers/nginx:v0.1.0",
+				"whiskers/nginx-ingress:v2.0.0",
+			})))
+			_, err := c.SearchWithFilter(catalog.Filter{SortBy: catalog.SortBy(42)})
+			Expect(err).To(MatchError("invalid sort order 42"))
+		})
+
+		It("returns the latest version of each profile with the versions available", func() {
+			c.Append(catName, profilesv1.ProfileCatalogEntry{Name: "nginx", Tag: "v0.2.0-rc.1"}, profilesv1.ProfileCatalogEntry{Name: "nginx", Tag: "main"})
+
+			Expect(c.SearchWithFilter(catalog.Filter{Name: "nginx", LatestOnly: true})).To(Equal([]catalog.Match{
+				{
+					ProfileCatalogEntry: profilesv1.ProfileCatalogEntry{Name: "NGINX", Tag: "v3.0.0", CatalogSource: "other"},
+					Versions:            []string{"v3.0.0"},
+				},
+				{
+					ProfileCatalogEntry: profilesv1.ProfileCatalogEntry{Name: "nginx", Tag: "v1.0.0", CatalogSource: catName, ProfileDescription: profilesv1.ProfileDescription{
+						Description: "Deploys NGINX", Maintainer: "weaveworks", Prerequisites: []string{"kubernetes 1.19", "flux"},
+					}},
+					Versions: []string{"v1.0.0", "v0.2.0-rc.1", "v0.1.0", "main"},
+				},
+				{
+					ProfileCatalogEntry: profilesv1.ProfileCatalogEntry{Name: "nginx-ingress", Tag: "v2.0.0", CatalogSource: catName},
+					Versions:            []string{"v2.0.0"},
+				},
+				{
+					ProfileCatalogEntry: profilesv1.ProfileCatalogEntry{Name: "bitnami-nginx", Tag: "v0.1.0", CatalogSource: catName, ProfileDescription: profilesv1.ProfileDescription{
+						Description: "Deploys the Bitnami NGINX chart", Maintainer: "Bitnami", Prerequisites: []string{"Kubernetes 1.19"},
+					}},
+					Versions: []string{"v0.1.0"},
+				},
+			}))
+			Expect(c.SearchWithFilter(catalog.Filter{Name: "nginx", SourceName: catName, VersionRange: "<1.0.0", LatestOnly: true})).To(WithTransform(tags, Equal([]string{
+				"whiskers/nginx:v0.1.0",
+				"whiskers/bitnami-nginx:v0.1.0",
+			})))
+		})
+
+		When("the version range is invalid", func() {
+			It("returns an error", func() {
+				_, err := c.SearchWithFilter(catalog.Filter{VersionRange: "not a range"})
+				Expect(err).To(MatchError(ContainSubstring(`invalid version range "not a range"`)))
+			})
+		})
+	})
+
+	Describe("ListVersions", func() {
+		It("lists all versions in descending order with the invalid versions last", func() {
+			c.AddOrReplace(catName,
+				profilesv1.ProfileCatalogEntry{Name: "foo", Tag: "v0.1.0"},
+				profilesv1.ProfileCatalogEntry{Name: "foo", Tag: "main"},
+				profilesv1.ProfileCatalogEntry{Name: "foo", Tag: "v0.2.0-rc.1"},
+				profilesv1.ProfileCatalogEntry{Name: "foo", Tag: "v0.2.0"},
+				profilesv1.ProfileCatalogEntry{Name: "bar", Tag: "v1.0.0"},
+			)
+
+			Expect(c.ListVersions(catName, "foo")).To(Equal([]catalog.Version{
+				{Version: "v0.2.0", Profile: profilesv1.ProfileCatalogEntry{Name: "foo", Tag: "v0.2.0", CatalogSource: catName}},
+				{Version: "v0.2.0-rc.1", Prerelease: true, Profile: profilesv1.ProfileCatalogEntry{Name: "foo", Tag: "v0.2.0-rc.1", CatalogSource: catName}},
+				{Version: "v0.1.0", Profile: profilesv1.ProfileCatalogEntry{Name: "foo", Tag: "v0.1.0", CatalogSource: catName}},
+				{Version: "main", Profile: profilesv1.ProfileCatalogEntry{Name: "foo", Tag: "main", CatalogSource: catName}},
+			}))
+		})
+
+		When("the profile is not in the catalog", func() {
+			It("returns nil", func() {
+				Expect(c.ListVersions(catName, "foo")).To(BeNil())
+				Expect(c.ListVersions("nope", "foo")).To(BeNil())
+			})
+		})
+	})
+
 	Describe("ProfilesGreaterThanVersion", func() {
 		It("lists all available versions which are greater than the current version in descending order", func() {
 
@@ -141,3 +448,8 @@ var _ = Describe("Catalog", func() {
 		})
 	})
 })
+
+func withSource(p profilesv1.ProfileCatalogEntry, sourceName string) profilesv1.ProfileCatalogEntry {
+	p.CatalogSource = sourceName
+	return p
+}
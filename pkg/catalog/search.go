@@ -0,0 +1,309 @@
+package catalog
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+
+	profilesv1 "github.com/weaveworks/profiles/api/v1alpha1"
+)
+
+// Filter selects the profiles returned by SearchWithFilter. Text is matched case-insensitively
+// and empty fields match all profiles.
+type Filter struct {
+	// Name is contained in the name of the profiles
+	Name string
+	// Fuzzy also matches the names containing the characters of Name in order
+	Fuzzy bool
+	// Description is contained in the description of the profiles
+	Description string
+	// Maintainer is contained in the maintainer of the profiles
+	Maintainer string
+	// Prerequisites are all prerequisites of the profiles
+	Prerequisites []string
+	// SourceName is the name of the catalog source listing the profiles
+	SourceName string
+	// VersionRange is a semver constraint the versions of the profiles satisfy, such as ">=1.0.0 <2.0.0"
+	VersionRange string
+	// SortBy is the order of the profiles, defaults to SortByRelevance
+	SortBy SortBy
+	// LatestOnly returns each profile of a catalog source once, with its highest version
+	// matching the filter and the list of those versions
+	LatestOnly bool
+}
+
+// SortBy is the order of the profiles returned by SearchWithFilter. Profiles which are equal
+// in all the fields of an order are ordered by tag and url.
+type SortBy int
+
+const (
+	// SortByRelevance orders the profiles whose name best matches Filter.Name first, then by
+	// catalog source, name and descending version.
+	SortByRelevance SortBy = iota
+	// SortByName orders the profiles by name, then catalog source and descending version.
+	SortByName
+	// SortByVersion orders the profiles by descending version, then name and catalog source.
+	// Profiles without a valid version come last.
+	SortByVersion
+	// SortBySource orders the profiles by catalog source, then name and descending version.
+	SortBySource
+)
+
+// scores of the matches of a profile name, the better the match the higher the score.
+const (
+	scoreAny = iota
+	scoreFuzzy
+	scoreContains
+	scorePrefix
+	scoreExact
+)
+
+// scored is an entry matching a filter with the score of its name.
+type scored struct {
+	entry
+	score int
+	// gaps are the characters skipped in the name by a fuzzy match
+	gaps int
+}
+
+// Match is a profile found by SearchWithFilter.
+type Match struct {
+	profilesv1.ProfileCatalogEntry
+	// Versions are the versions of the profile matching the filter, highest first, when the
+	// filter selects the latest version of each profile
+	Versions []string
+}
+
+// SearchWithFilter returns the profiles matching the filter in the order of filter.SortBy. By
+// relevance, exact name matches come first, then names starting with, containing and fuzzily
+// matching the filter's name.
+func (c *Catalog) SearchWithFilter(filter Filter) ([]Match, error) {
+	less := orders[filter.SortBy]
+	if less == nil {
+		return nil, fmt.Errorf("invalid sort order %d", filter.SortBy)
+	}
+	var constraint *semver.Constraints
+	if filter.VersionRange != "" {
+		var err error
+		if constraint, err = semver.NewConstraint(filter.VersionRange); err != nil {
+			return nil, fmt.Errorf("invalid version range %q: %w", filter.VersionRange, err)
+		}
+	}
+
+	var (
+		matches  []scored
+		versions = make(map[profileKey][]string)
+	)
+	c.m.Range(func(key, value interface{}) bool {
+		if filter.SourceName != "" && key.(string) != filter.SourceName {
+			return true
+		}
+		s := value.(*snapshot)
+		for _, name := range s.names {
+			score, gaps, ok := matchName(name, filter.Name, filter.Fuzzy)
+			if !ok {
+				continue
+			}
+			var found []entry
+			for _, e := range s.entries(name) {
+				if constraint != nil && (e.semver == nil || !constraint.Check(e.semver)) {
+					continue
+				}
+				if !matchDescription(e.profile.ProfileDescription, filter) {
+					continue
+				}
+				found = append(found, e)
+			}
+			if filter.LatestOnly && len(found) > 0 {
+				found = latestOf(found, versions)
+			}
+			for _, e := range found {
+				matches = append(matches, scored{entry: e, score: score, gaps: gaps})
+			}
+		}
+		return true
+	})
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return less(matches[i], matches[j])
+	})
+	var result []Match
+	for _, m := range matches {
+		result = append(result, Match{
+			ProfileCatalogEntry: m.profile,
+			Versions:            versions[profileKey{source: m.profile.CatalogSource, name: m.profile.Name}],
+		})
+	}
+	return result, nil
+}
+
+// profileKey identifies a profile of a catalog source.
+type profileKey struct {
+	source, name string
+}
+
+// latestOf returns the entry of a profile with the highest version, ordered as by
+// ProfilesGreaterThanVersion, and records the versions of the entries highest first.
+// Versions which are not valid semver come last, in the order they were added.
+func latestOf(entries []entry, versions map[profileKey][]string) []entry {
+	sorted := append([]entry(nil), entries...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return newerThan(sorted[i], sorted[j])
+	})
+	key := profileKey{source: sorted[0].profile.CatalogSource, name: sorted[0].profile.Name}
+	seen := make(map[string]bool, len(sorted))
+	for _, e := range sorted {
+		if !seen[e.version] {
+			seen[e.version] = true
+			versions[key] = append(versions[key], e.version)
+		}
+	}
+	return sorted[:1]
+}
+
+// matchName returns the score of a profile name for the query, the characters skipped by a
+// fuzzy match, and whether the name matches.
+func matchName(name, query string, fuzzy bool) (int, int, bool) {
+	if query == "" {
+		return scoreAny, 0, true
+	}
+	name, query = strings.ToLower(name), strings.ToLower(query)
+	switch {
+	case name == query:
+		return scoreExact, 0, true
+	case strings.HasPrefix(name, query):
+		return scorePrefix, 0, true
+	case strings.Contains(name, query):
+		return scoreContains, 0, true
+	case fuzzy:
+		if gaps, ok := fuzzyMatch(name, query); ok {
+			return scoreFuzzy, gaps, true
+		}
+	}
+	return 0, 0, false
+}
+
+// fuzzyMatch returns whether the characters of the query are found in order in the name, and
+// how many characters of the name are skipped between the first and the last of them.
+func fuzzyMatch(name, query string) (int, bool) {
+	queryRunes := []rune(query)
+	var (
+		next, gaps int
+		started    bool
+	)
+	for _, r := range name {
+		if next == len(queryRunes) {
+			break
+		}
+		if r == queryRunes[next] {
+			next++
+			started = true
+		} else if started {
+			gaps++
+		}
+	}
+	return gaps, next == len(queryRunes)
+}
+
+// matchDescription returns whether the description of a profile matches the filter.
+func matchDescription(d profilesv1.ProfileDescription, filter Filter) bool {
+	if !containsFold(d.Description, filter.Description) || !containsFold(d.Maintainer, filter.Maintainer) {
+		return false
+	}
+	for _, required := range filter.Prerequisites {
+		found := false
+		for _, prerequisite := range d.Prerequisites {
+			if strings.EqualFold(prerequisite, required) {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+// newerThan returns whether a has a higher version than b. Profiles without a valid version
+// come last.
+func newerThan(a, b entry) bool {
+	if a.semver == nil || b.semver == nil {
+		return a.semver != nil
+	}
+	return a.semver.GreaterThan(b.semver)
+}
+
+// comparison compares two matches, returning -1, 0 or 1.
+type comparison func(a, b scored) int
+
+func byScore(a, b scored) int {
+	switch {
+	case a.score != b.score:
+		return compareInts(b.score, a.score)
+	default:
+		return compareInts(a.gaps, b.gaps)
+	}
+}
+
+func bySource(a, b scored) int {
+	return strings.Compare(a.profile.CatalogSource, b.profile.CatalogSource)
+}
+
+func byName(a, b scored) int {
+	if c := strings.Compare(strings.ToLower(a.profile.Name), strings.ToLower(b.profile.Name)); c != 0 {
+		return c
+	}
+	return strings.Compare(a.profile.Name, b.profile.Name)
+}
+
+func byVersion(a, b scored) int {
+	switch {
+	case newerThan(a.entry, b.entry):
+		return -1
+	case newerThan(b.entry, a.entry):
+		return 1
+	}
+	return 0
+}
+
+func byTag(a, b scored) int {
+	if c := strings.Compare(a.profile.Tag, b.profile.Tag); c != 0 {
+		return c
+	}
+	return strings.Compare(a.profile.URL, b.profile.URL)
+}
+
+// orderBy returns a less function comparing matches with each comparison in turn.
+func orderBy(comparisons ...comparison) func(a, b scored) bool {
+	return func(a, b scored) bool {
+		for _, compare := range comparisons {
+			if c := compare(a, b); c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	}
+}
+
+var orders = map[SortBy]func(a, b scored) bool{
+	SortByRelevance: orderBy(byScore, bySource, byName, byVersion, byTag),
+	SortByName:      orderBy(byName, bySource, byVersion, byTag),
+	SortByVersion:   orderBy(byVersion, byName, bySource, byTag),
+	SortBySource:    orderBy(bySource, byName, byVersion, byTag),
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
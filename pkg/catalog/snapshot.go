@@ -0,0 +1,256 @@
+package catalog
+
+import (
+	"sort"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/fluxcd/pkg/version"
+
+	profilesv1 "github.com/weaveworks/profiles/api/v1alpha1"
+)
+
+// entry is a profile of the catalog with its version parsed once when it is added.
+type entry struct {
+	profile profilesv1.ProfileCatalogEntry
+	// version is the version of the profile as found in its tag
+	version string
+	// semver is the parsed version, nil when the version is not valid semver
+	semver *semver.Version
+	// err is the error parsing the version
+	err error
+}
+
+func newEntry(sourceName string, p profilesv1.ProfileCatalogEntry) entry {
+	p.CatalogSource = sourceName
+	e := entry{profile: p, version: profilesv1.GetVersionFromTag(p.Tag, p.TagPattern)}
+	e.semver, e.err = version.ParseVersion(e.version)
+	return e
+}
+
+// sameVersion returns the version used to detect conflicts between entries: the parsed
+// version without build metadata when it is valid semver, so that "v1.0.0" and "1.0.0" are
+// the same version, or the version as found in its tag.
+func (e entry) sameVersion() string {
+	if e.semver == nil {
+		return e.version
+	}
+	v, _ := e.semver.SetMetadata("")
+	return v.String()
+}
+
+// entryKey identifies the entries of a profile.
+type entryKey struct {
+	tag, url string
+}
+
+func keyOf(p profilesv1.ProfileCatalogEntry) entryKey {
+	return entryKey{tag: p.Tag, url: p.URL}
+}
+
+// snapshot holds the profiles of a catalog source indexed by name. Snapshots are never
+// modified once stored in the catalog: writers build a new snapshot, sharing the versions of
+// the profiles they did not change, and replace the stored one, so readers never block or
+// see a partial update.
+type snapshot struct {
+	// names are the names of the profiles in the order they were added
+	names    []string
+	profiles map[string]*versions
+}
+
+// versions holds the entries of a profile with indexes to look them up.
+type versions struct {
+	// entries are the entries in the order they were added
+	entries []entry
+	// byVersion indexes the entries of each version in the order they were added
+	byVersion map[string][]int
+	// bySameVersion indexes the entries of each version compared with sameVersion
+	bySameVersion map[string][]int
+	// byKey indexes the entries by tag and url
+	byKey map[entryKey]int
+	// sorted indexes the entries with a valid version, highest version first
+	sorted []int
+}
+
+func newVersions(entries []entry) *versions {
+	v := &versions{
+		byVersion:     make(map[string][]int, len(entries)),
+		bySameVersion: make(map[string][]int, len(entries)),
+		byKey:         make(map[entryKey]int, len(entries)),
+	}
+	for _, e := range entries {
+		v.add(e)
+	}
+	v.sort()
+	return v
+}
+
+// clone returns a copy of the versions which can be changed without changing v. A nil v
+// is cloned to empty versions.
+func (v *versions) clone() *versions {
+	if v == nil {
+		return newVersions(nil)
+	}
+	next := &versions{
+		entries:       append(make([]entry, 0, len(v.entries)+1), v.entries...),
+		byVersion:     cloneIndex(v.byVersion),
+		bySameVersion: cloneIndex(v.bySameVersion),
+		byKey:         make(map[entryKey]int, len(v.byKey)),
+	}
+	for key, i := range v.byKey {
+		next.byKey[key] = i
+	}
+	return next
+}
+
+// add appends an entry. sort must be called once all entries are added.
+func (v *versions) add(e entry) {
+	i := len(v.entries)
+	v.entries = append(v.entries, e)
+	if _, ok := v.byKey[keyOf(e.profile)]; !ok {
+		v.byKey[keyOf(e.profile)] = i
+	}
+	v.byVersion[e.version] = append(v.byVersion[e.version], i)
+	v.bySameVersion[e.sameVersion()] = append(v.bySameVersion[e.sameVersion()], i)
+}
+
+// set replaces the entry at index i. sort must be called once all entries are set.
+func (v *versions) set(i int, e entry) {
+	old := v.entries[i]
+	v.entries[i] = e
+	if keyOf(old.profile) != keyOf(e.profile) {
+		delete(v.byKey, keyOf(old.profile))
+		if _, ok := v.byKey[keyOf(e.profile)]; !ok {
+			v.byKey[keyOf(e.profile)] = i
+		}
+	}
+	moveIndex(v.byVersion, old.version, e.version, i)
+	moveIndex(v.bySameVersion, old.sameVersion(), e.sameVersion(), i)
+}
+
+// sort indexes the entries with a valid version, highest version first.
+func (v *versions) sort() {
+	v.sorted = v.sorted[:0]
+	for i, e := range v.entries {
+		if e.semver != nil {
+			v.sorted = append(v.sorted, i)
+		}
+	}
+	sort.SliceStable(v.sorted, func(i, j int) bool {
+		return v.entries[v.sorted[j]].semver.LessThan(v.entries[v.sorted[i]].semver)
+	})
+}
+
+func cloneIndex(index map[string][]int) map[string][]int {
+	next := make(map[string][]int, len(index))
+	for key, indexes := range index {
+		next[key] = append([]int(nil), indexes...)
+	}
+	return next
+}
+
+// moveIndex moves the entry at index i from the `from` key to the `to` key of the index,
+// keeping the indexes of each key in order.
+func moveIndex(index map[string][]int, from, to string, i int) {
+	if from == to {
+		return
+	}
+	indexes := index[from]
+	for j := range indexes {
+		if indexes[j] == i {
+			indexes = append(indexes[:j], indexes[j+1:]...)
+			break
+		}
+	}
+	if len(indexes) == 0 {
+		delete(index, from)
+	} else {
+		index[from] = indexes
+	}
+	indexes = index[to]
+	j := sort.SearchInts(indexes, i)
+	indexes = append(indexes, 0)
+	copy(indexes[j+1:], indexes[j:])
+	indexes[j] = i
+	index[to] = indexes
+}
+
+func newSnapshotOf(sourceName string, profiles []profilesv1.ProfileCatalogEntry) *snapshot {
+	var (
+		names   []string
+		changed = make(map[string]*versions)
+	)
+	for _, p := range profiles {
+		if _, ok := changed[p.Name]; !ok {
+			names = append(names, p.Name)
+			changed[p.Name] = newVersions(nil)
+		}
+		changed[p.Name].add(newEntry(sourceName, p))
+	}
+	for _, v := range changed {
+		v.sort()
+	}
+	return (*snapshot)(nil).with(names, changed)
+}
+
+// with returns a copy of the snapshot in which the versions of the given profiles are replaced.
+// Profiles without entries are removed.
+func (s *snapshot) with(names []string, changed map[string]*versions) *snapshot {
+	var size int
+	if s != nil {
+		size = len(s.names)
+	}
+	next := &snapshot{profiles: make(map[string]*versions, size+len(names))}
+	reuseNames := s != nil
+	for _, name := range names {
+		if reuseNames && (len(changed[name].entries) == 0 || s.profiles[name] == nil) {
+			reuseNames = false
+		}
+	}
+	if reuseNames {
+		// the profiles are unchanged, and names is never modified once stored
+		next.names = s.names
+	} else if s != nil {
+		next.names = make([]string, 0, size+len(names))
+		for _, name := range s.names {
+			if v, ok := changed[name]; !ok || len(v.entries) > 0 {
+				next.names = append(next.names, name)
+			}
+		}
+	}
+	if s != nil {
+		for name, v := range s.profiles {
+			next.profiles[name] = v
+		}
+	}
+	for _, name := range names {
+		v := changed[name]
+		if len(v.entries) == 0 {
+			delete(next.profiles, name)
+			continue
+		}
+		if !reuseNames && (s == nil || s.profiles[name] == nil) {
+			next.names = append(next.names, name)
+		}
+		next.profiles[name] = v
+	}
+	return next
+}
+
+// entries returns the entries of a profile, or nil.
+func (s *snapshot) entries(name string) []entry {
+	if v, ok := s.profiles[name]; ok {
+		return v.entries
+	}
+	return nil
+}
+
+// list returns a copy of the profiles of the snapshot.
+func (s *snapshot) list() []profilesv1.ProfileCatalogEntry {
+	var profiles []profilesv1.ProfileCatalogEntry
+	for _, name := range s.names {
+		for _, e := range s.profiles[name].entries {
+			profiles = append(profiles, e.profile)
+		}
+	}
+	return profiles
+}
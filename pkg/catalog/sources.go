@@ -0,0 +1,62 @@
+package catalog
+
+import (
+	"sort"
+
+	profilesv1 "github.com/weaveworks/profiles/api/v1alpha1"
+)
+
+// Source is a catalog source with the number of its profiles and the status of its last scan.
+type Source struct {
+	// Name is the name of the catalog source
+	Name string
+	// Profiles is the number of profiles listed by the catalog source
+	Profiles int
+	// Versions is the number of versions of all the profiles
+	Versions int
+	// Status is the status of the catalog source last recorded with SetStatus
+	Status profilesv1.ProfileCatalogSourceStatus
+}
+
+// SetStatus records the status of a catalog source, returned with its profile counts by
+// Sources and GetSource. The status of a catalog source which is not in the catalog is not
+// recorded, so that a status set while the catalog source is removed is not kept.
+func (c *Catalog) SetStatus(sourceName string, status profilesv1.ProfileCatalogSourceStatus) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.load(sourceName); !ok {
+		return
+	}
+	c.statuses.Store(sourceName, status.DeepCopy())
+}
+
+// Sources returns the catalog sources, ordered by name.
+func (c *Catalog) Sources() []Source {
+	var sources []Source
+	c.m.Range(func(key, _ interface{}) bool {
+		if source, ok := c.GetSource(key.(string)); ok {
+			sources = append(sources, source)
+		}
+		return true
+	})
+	sort.Slice(sources, func(i, j int) bool {
+		return sources[i].Name < sources[j].Name
+	})
+	return sources
+}
+
+// GetSource returns the catalog source `sourceName`, and whether it is in the catalog.
+func (c *Catalog) GetSource(sourceName string) (Source, bool) {
+	s, ok := c.load(sourceName)
+	if !ok {
+		return Source{}, false
+	}
+	source := Source{Name: sourceName, Profiles: len(s.names)}
+	for _, name := range s.names {
+		source.Versions += len(s.profiles[name].entries)
+	}
+	if status, ok := c.statuses.Load(sourceName); ok {
+		source.Status = *status.(*profilesv1.ProfileCatalogSourceStatus).DeepCopy()
+	}
+	return source, true
+}
@@ -1,54 +1,223 @@
 package git
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"path/filepath"
+	"time"
 
 	"github.com/fluxcd/source-controller/pkg/git/gogit"
 	extgogit "github.com/go-git/go-git/v5"
-	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
 	"github.com/go-git/go-git/v5/storage/memory"
 	corev1 "k8s.io/api/core/v1"
 )
 
+// listTimeout is the timeout to list the references of a repository
+const listTimeout = 10 * time.Second
+
 //Client git client
 type Client struct{}
 
-//ListTags returns a list of tags for a given repository
-func (c *Client) ListTags(url string, secret *corev1.Secret) ([]string, error) {
-	rem := extgogit.NewRemote(memory.NewStorage(), &config.RemoteConfig{
-		Name: "origin",
-		URLs: []string{url},
-	})
+//ListTags returns the commit each tag of a given repository refers to. Annotated tags are
+//peeled to the commit they point to.
+func (c *Client) ListTags(url string, secret *corev1.Secret) (map[string]string, error) {
+	refs, peeled, err := listRefs(url, secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags: %w", err)
+	}
 
-	var auth transport.AuthMethod
-	if secret != nil {
-		authStrategy, err := gogit.AuthSecretStrategyForURL(url)
+	tags := make(map[string]string)
+	for _, ref := range refs {
+		if ref.Name().IsTag() {
+			hash := ref.Hash()
+			if commit, ok := peeled[ref.Name().String()]; ok {
+				hash = commit
+			}
+			tags[ref.Name().Short()] = hash.String()
+		}
+	}
 
-		if err != nil {
-			return nil, fmt.Errorf("failed to create auth strateg from URL %q : %w", url, err)
+	return tags, nil
+}
+
+//ListBranches returns the head commit of each branch of a given repository
+func (c *Client) ListBranches(url string, secret *corev1.Secret) (map[string]string, error) {
+	refs, _, err := listRefs(url, secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list branches: %w", err)
+	}
+
+	branches := make(map[string]string)
+	for _, ref := range refs {
+		if ref.Name().IsBranch() {
+			branches[ref.Name().Short()] = ref.Hash().String()
 		}
-		authMethod, err := authStrategy.Method(*secret)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get auth method: %w", err)
+	}
+
+	return branches, nil
+}
+
+// listRefs returns the references advertised by a repository, and the commits the annotated
+// tags point to by reference name.
+func listRefs(url string, secret *corev1.Secret) ([]*plumbing.Reference, map[string]plumbing.Hash, error) {
+	auth, err := authMethod(url, secret)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ep, err := transport.NewEndpoint(url)
+	if err != nil {
+		return nil, nil, err
+	}
+	cl, err := client.NewClient(ep)
+	if err != nil {
+		return nil, nil, err
+	}
+	session, err := cl.NewUploadPackSession(ep, auth)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer session.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), listTimeout)
+	defer cancel()
+	ar, err := session.AdvertisedReferencesContext(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	allRefs, err := ar.AllReferences()
+	if err != nil {
+		return nil, nil, err
+	}
+	var refs []*plumbing.Reference
+	for _, ref := range allRefs {
+		refs = append(refs, ref)
+	}
+	return refs, ar.Peeled, nil
+}
+
+//ReadFile returns the contents of the file at path in the given tag of a repository, or nil
+//if the file does not exist, and the directories below the directory of the file, relative
+//to it. The tag is cloned shallowly into memory.
+func (c *Client) ReadFile(url, tag, path string, secret *corev1.Secret) ([]byte, []string, error) {
+	return readFile(url, plumbing.NewTagReferenceName(tag), "tag", "", path, secret)
+}
+
+//ReadBranchFile returns the contents of the file at path in the given commit of a branch of
+//a repository, like ReadFile. The history of the branch is only cloned when the head of the
+//branch has moved past the commit.
+func (c *Client) ReadBranchFile(url, branch, commit, path string, secret *corev1.Secret) ([]byte, []string, error) {
+	return readFile(url, plumbing.NewBranchReferenceName(branch), "branch", commit, path, secret)
+}
+
+func readFile(url string, ref plumbing.ReferenceName, kind, commitHash, path string, secret *corev1.Secret) ([]byte, []string, error) {
+	auth, err := authMethod(url, secret)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	name := ref.Short()
+	repo, err := clone(url, auth, ref, 1)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to clone %s %q: %w", kind, name, err)
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to resolve %s %q: %w", kind, name, err)
+	}
+	hash := head.Hash()
+	if commitHash != "" && hash.String() != commitHash {
+		if repo, err = clone(url, auth, ref, 0); err != nil {
+			return nil, nil, fmt.Errorf("failed to clone the history of %s %q: %w", kind, name, err)
+		}
+		hash = plumbing.NewHash(commitHash)
+	}
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get commit %s for %s %q: %w", hash, kind, name, err)
+	}
+	file, err := commit.File(path)
+	if err != nil {
+		if errors.Is(err, object.ErrFileNotFound) {
+			return nil, nil, nil
 		}
-		auth = authMethod.AuthMethod
+		return nil, nil, fmt.Errorf("failed to get %q in %s %q: %w", path, kind, name, err)
+	}
+	contents, err := file.Contents()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read %q in %s %q: %w", path, kind, name, err)
 	}
 
-	refs, err := rem.List(&extgogit.ListOptions{
-		Auth: auth,
+	dirs, err := listDirs(commit, filepath.Dir(path))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list directories in %s %q: %w", kind, name, err)
+	}
+	return []byte(contents), dirs, nil
+}
+
+// clone clones a branch or tag of a repository into memory, without checking it out. A depth
+// of zero clones the whole history.
+func clone(url string, auth transport.AuthMethod, ref plumbing.ReferenceName, depth int) (*extgogit.Repository, error) {
+	return extgogit.Clone(memory.NewStorage(), nil, &extgogit.CloneOptions{
+		URL:           url,
+		Auth:          auth,
+		ReferenceName: ref,
+		SingleBranch:  true,
+		Depth:         depth,
+		NoCheckout:    true,
+		Tags:          extgogit.NoTags,
 	})
+}
 
+// listDirs returns the directories below dir in the tree of the commit, relative to dir.
+func listDirs(commit *object.Commit, dir string) ([]string, error) {
+	tree, err := commit.Tree()
 	if err != nil {
-		return nil, fmt.Errorf("failed to list tags: %w", err)
+		return nil, err
+	}
+	if dir != "." {
+		if tree, err = tree.Tree(dir); err != nil {
+			return nil, err
+		}
 	}
 
-	var tags []string
-	for _, ref := range refs {
-		if ref.Name().IsTag() {
-			tags = append(tags, ref.Name().Short())
+	var dirs []string
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+	for {
+		name, entry, err := walker.Next()
+		if err == io.EOF {
+			return dirs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if entry.Mode == filemode.Dir {
+			dirs = append(dirs, name)
 		}
 	}
+}
 
-	return tags, nil
+func authMethod(url string, secret *corev1.Secret) (transport.AuthMethod, error) {
+	if secret == nil {
+		return nil, nil
+	}
+
+	authStrategy, err := gogit.AuthSecretStrategyForURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create auth strateg from URL %q : %w", url, err)
+	}
+	authMethod, err := authStrategy.Method(*secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get auth method: %w", err)
+	}
+	return authMethod.AuthMethod, nil
 }
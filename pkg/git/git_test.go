@@ -0,0 +1,88 @@
+package git_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	extgogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/weaveworks/profiles/pkg/git"
+)
+
+var _ = Describe("Client", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "profiles-git")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	commitFile := func(repo *extgogit.Repository, contents string) plumbing.Hash {
+		Expect(ioutil.WriteFile(filepath.Join(dir, "profile.yaml"), []byte(contents), 0644)).To(Succeed())
+		worktree, err := repo.Worktree()
+		Expect(err).NotTo(HaveOccurred())
+		_, err = worktree.Add("profile.yaml")
+		Expect(err).NotTo(HaveOccurred())
+		signature := &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}
+		commit, err := worktree.Commit("update profile", &extgogit.CommitOptions{Author: signature})
+		Expect(err).NotTo(HaveOccurred())
+		return commit
+	}
+
+	Describe("ReadBranchFile", func() {
+		It("reads the file at the given commit of the branch", func() {
+			repo, err := extgogit.PlainInit(dir, false)
+			Expect(err).NotTo(HaveOccurred())
+			first := commitFile(repo, "kind: first")
+			head := commitFile(repo, "kind: head")
+
+			data, _, err := (&git.Client{}).ReadBranchFile(dir, "master", head.String(), "profile.yaml", nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("kind: head"))
+
+			By("reading a commit the head of the branch has moved past")
+			data, _, err = (&git.Client{}).ReadBranchFile(dir, "master", first.String(), "profile.yaml", nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("kind: first"))
+		})
+	})
+
+	Describe("ListTags", func() {
+		It("returns the commit of lightweight and annotated tags", func() {
+			repo, err := extgogit.PlainInit(dir, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ioutil.WriteFile(filepath.Join(dir, "profile.yaml"), []byte("kind: Profile"), 0644)).To(Succeed())
+			worktree, err := repo.Worktree()
+			Expect(err).NotTo(HaveOccurred())
+			_, err = worktree.Add("profile.yaml")
+			Expect(err).NotTo(HaveOccurred())
+			signature := &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}
+			commit, err := worktree.Commit("add profile", &extgogit.CommitOptions{Author: signature})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = repo.CreateTag("v0.1.0", commit, nil)
+			Expect(err).NotTo(HaveOccurred())
+			annotated, err := repo.CreateTag("v0.2.0", commit, &extgogit.CreateTagOptions{Tagger: signature, Message: "v0.2.0"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(annotated.Hash()).NotTo(Equal(commit))
+
+			tags, err := (&git.Client{}).ListTags(dir, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tags).To(Equal(map[string]string{
+				"v0.1.0": commit.String(),
+				"v0.2.0": commit.String(),
+			}))
+		})
+	})
+})
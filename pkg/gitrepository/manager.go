@@ -2,13 +2,18 @@ package gitrepository
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 
 	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
 	profilesv1 "github.com/weaveworks/profiles/api/v1alpha1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
@@ -21,10 +26,13 @@ type Manager struct {
 	interval  time.Duration
 }
 
-//Instance contains a tag and path of profile.yaml
+//Instance contains a tag and path of the profile definition file. Instances of tracked
+//branches have the branch and commit to check out, and the version of the branch as tag.
 type Instance struct {
-	Tag  string
-	Path string
+	Tag    string
+	Path   string
+	Branch string
+	Commit string
 }
 
 //go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 -generate
@@ -51,7 +59,7 @@ func NewManager(ctx context.Context, namespace string, kClient Kubernetes, timeo
 func (m *Manager) CreateAndWaitForResources(r profilesv1.Repository, instances []Instance) ([]*sourcev1.GitRepository, error) {
 	var gitResources []*sourcev1.GitRepository
 	for _, instance := range instances {
-		gitRes := makeGitRepository(r, instance.Tag, instance.Path, m.namespace)
+		gitRes := makeGitRepository(r, instance, m.namespace)
 		if err := m.kClient.Create(m.ctx, gitRes); err != nil {
 			return nil, fmt.Errorf("failed to create gitrepository: %w", err)
 		}
@@ -86,15 +94,21 @@ func (m *Manager) waitForURL(gitRes *sourcev1.GitRepository) error {
 	}
 }
 
-func makeGitRepository(r profilesv1.Repository, tag, path, namespace string) *sourcev1.GitRepository {
-	ignore := fmt.Sprintf(`# exclude all
+func makeGitRepository(r profilesv1.Repository, instance Instance, namespace string) *sourcev1.GitRepository {
+	// include the directory of the profile.yaml, which holds the local artifacts of the profile.
+	// A profile at the root of the repository requires the whole repository.
+	var ignore *string
+	if dir := filepath.Dir(instance.Path); dir != "." {
+		exclude := fmt.Sprintf(`# exclude all
 /*
-# include deploy dir
-!/%s`, path)
+# include profile dir
+%s`, includeDir(dir))
+		ignore = &exclude
+	}
 
 	repo := &sourcev1.GitRepository{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      makeGitRepoName(tag, r.URL),
+			Name:      makeGitRepoName(instance.Tag, r.URL),
 			Namespace: namespace,
 		},
 		TypeMeta: metav1.TypeMeta{
@@ -104,11 +118,17 @@ func makeGitRepository(r profilesv1.Repository, tag, path, namespace string) *so
 		Spec: sourcev1.GitRepositorySpec{
 			URL: r.URL,
 			Reference: &sourcev1.GitRepositoryRef{
-				Tag: tag,
+				Tag: instance.Tag,
 			},
-			Ignore: &ignore,
+			Ignore: ignore,
 		},
 	}
+	if instance.Branch != "" {
+		repo.Spec.Reference = &sourcev1.GitRepositoryRef{
+			Branch: instance.Branch,
+			Commit: instance.Commit,
+		}
+	}
 
 	if r.SecretRef != nil {
 		repo.Spec.SecretRef = r.SecretRef
@@ -116,15 +136,42 @@ func makeGitRepository(r profilesv1.Repository, tag, path, namespace string) *so
 	return repo
 }
 
+var (
+	invalidNameChars = regexp.MustCompile(`[^a-z0-9.-]+`)
+	// nameSeparators matches the separators around a dot, as each part of a name must start
+	// and end with an alphanumeric character
+	nameSeparators = regexp.MustCompile(`[.-]*\.[.-]*`)
+)
+
+//makeGitRepoName returns the name of the gitrepository of a tag: the name of the repository and
+//the tag in lower case with the characters which are invalid in a resource name replaced. A name
+//longer than validation.DNS1123SubdomainMaxLength is shortened and suffixed with a hash.
 func makeGitRepoName(tag, url string) string {
 	urlParts := strings.Split(url, "/")
 	repo := strings.TrimRight(urlParts[len(urlParts)-1], ".git")
 
-	splitTag := strings.Split(tag, "/")
-	if len(splitTag) == 2 {
-		return fmt.Sprintf("%s-%s-%s", repo, splitTag[0], splitTag[1])
+	name := fmt.Sprintf("%s-%s", repo, tag)
+	sanitised := invalidNameChars.ReplaceAllString(strings.ToLower(name), "-")
+	sanitised = strings.Trim(nameSeparators.ReplaceAllString(sanitised, "."), ".-")
+	if len(sanitised) > validation.DNS1123SubdomainMaxLength {
+		sum := sha256.Sum256([]byte(name))
+		sanitised = strings.TrimRight(sanitised[:validation.DNS1123SubdomainMaxLength-9], ".-") + "-" + hex.EncodeToString(sum[:])[:8]
+	}
+	return sanitised
+}
+
+// includeDir returns the ignore rules including dir. As a directory cannot be included
+// when its parent is excluded, each parent is included with its other entries excluded.
+func includeDir(dir string) string {
+	var rules []string
+	parts := strings.Split(filepath.ToSlash(dir), "/")
+	for i := range parts {
+		if i > 0 {
+			rules = append(rules, fmt.Sprintf("/%s/*", strings.Join(parts[:i], "/")))
+		}
+		rules = append(rules, fmt.Sprintf("!/%s/", strings.Join(parts[:i+1], "/")))
 	}
-	return fmt.Sprintf("%s-%s", repo, tag)
+	return strings.Join(rules, "\n")
 }
 
 //DeleteResources deletes the gitrepository resources
@@ -3,6 +3,7 @@ package gitrepository_test
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/fluxcd/pkg/apis/meta"
@@ -15,6 +16,7 @@ import (
 	"github.com/weaveworks/profiles/pkg/gitrepository"
 	"github.com/weaveworks/profiles/pkg/gitrepository/fakes"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
 var _ = Describe("Gitrepository", func() {
@@ -71,15 +73,10 @@ var _ = Describe("Gitrepository", func() {
 				})
 
 				Expect(err).NotTo(HaveOccurred())
-				ignore1 := `# exclude all
-/*
-# include deploy dir
-!/profile.yaml`
-
 				ignore2 := `# exclude all
 /*
-# include deploy dir
-!/foo/profile.yaml`
+# include profile dir
+!/foo/`
 				Expect(kClient.CreateCallCount()).To(Equal(2))
 				Expect(kClient.GetCallCount()).To(Equal(4))
 
@@ -98,7 +95,6 @@ var _ = Describe("Gitrepository", func() {
 							Reference: &sourcev1.GitRepositoryRef{
 								Tag: "v0.1.0",
 							},
-							Ignore: &ignore1,
 							SecretRef: &meta.LocalObjectReference{
 								Name: "my-secret",
 							},
@@ -134,6 +130,94 @@ var _ = Describe("Gitrepository", func() {
 			})
 		})
 
+		When("the profile is in a nested directory", func() {
+			BeforeEach(func() {
+				kClient.GetStub = func(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+					obj.(*sourcev1.GitRepository).Status = sourcev1.GitRepositoryStatus{
+						URL: "url1",
+					}
+					return nil
+				}
+			})
+
+			It("includes each parent directory of the profile", func() {
+				resources, err := manager.CreateAndWaitForResources(repo, []gitrepository.Instance{
+					{
+						Tag:  "profiles/observability/v1.2.0",
+						Path: "profiles/observability/profile.yaml",
+					},
+				})
+
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resources).To(HaveLen(1))
+				Expect(resources[0].Name).To(Equal("repo-profiles-observability-v1.2.0"))
+				Expect(*resources[0].Spec.Ignore).To(Equal(`# exclude all
+/*
+# include profile dir
+!/profiles/
+/profiles/*
+!/profiles/observability/`))
+			})
+		})
+
+		When("the instance is a tracked branch", func() {
+			BeforeEach(func() {
+				kClient.GetStub = func(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+					obj.(*sourcev1.GitRepository).Status = sourcev1.GitRepositoryStatus{
+						URL: "url1",
+					}
+					return nil
+				}
+			})
+
+			It("checks out the commit of the branch", func() {
+				resources, err := manager.CreateAndWaitForResources(repo, []gitrepository.Instance{
+					{
+						Tag:    "0.0.0-main.0123456",
+						Path:   "profile.yaml",
+						Branch: "main",
+						Commit: "0123456789abcdef0123456789abcdef01234567",
+					},
+				})
+
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resources).To(HaveLen(1))
+				Expect(resources[0].Name).To(Equal("repo-0.0.0-main.0123456"))
+				Expect(resources[0].Spec.Reference).To(Equal(&sourcev1.GitRepositoryRef{
+					Branch: "main",
+					Commit: "0123456789abcdef0123456789abcdef01234567",
+				}))
+			})
+		})
+
+		When("the tag is not a valid resource name", func() {
+			BeforeEach(func() {
+				kClient.GetStub = func(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+					obj.(*sourcev1.GitRepository).Status = sourcev1.GitRepositoryStatus{
+						URL: "url1",
+					}
+					return nil
+				}
+			})
+
+			It("sanitises the name of the gitrepository", func() {
+				resources, err := manager.CreateAndWaitForResources(repo, []gitrepository.Instance{
+					{Tag: "Foo_Bar/V1.0.0+Build", Path: "Foo_Bar/profile.yaml"},
+					{Tag: strings.Repeat("long/", 60) + "v1.0.0", Path: "profile.yaml"},
+				})
+
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resources).To(HaveLen(2))
+				Expect(resources[0].Name).To(Equal("repo-foo-bar-v1.0.0-build"))
+				Expect(resources[0].Spec.Reference.Tag).To(Equal("Foo_Bar/V1.0.0+Build"))
+				Expect(resources[1].Name).To(HaveLen(validation.DNS1123SubdomainMaxLength))
+				Expect(resources[1].Name).To(MatchRegexp(`^repo-long-long-.*-[0-9a-f]{8}$`))
+				for _, resource := range resources {
+					Expect(validation.IsDNS1123Subdomain(resource.Name)).To(BeEmpty())
+				}
+			})
+		})
+
 		When("create fails", func() {
 			BeforeEach(func() {
 				kClient.CreateReturns(fmt.Errorf("createfailed"))
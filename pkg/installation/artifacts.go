@@ -0,0 +1,263 @@
+package installation
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2beta1"
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+	"github.com/fluxcd/pkg/runtime/dependency"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	profilesv1 "github.com/weaveworks/profiles/api/v1alpha1"
+)
+
+const defaultInterval = time.Minute * 5
+
+// Source identifies the GitRepository containing a profile
+type Source struct {
+	// Name of the GitRepository
+	Name string
+	// Namespace of the GitRepository
+	Namespace string
+	// Path is the directory of the profile within the repository
+	Path string
+	// ProfileFilename is the name of the file containing the profile definition, DefinitionFile
+	// when empty
+	ProfileFilename string
+	// URL is the URL of the repository
+	URL string
+	// Branch is the branch the GitRepository tracks
+	Branch string
+	// Tag is the tag the GitRepository tracks
+	Tag string
+	// Commit is the commit of the GitRepository artifact
+	Commit string
+}
+
+// Resolved returns the location of the profile for the installation status.
+func (s Source) Resolved() *profilesv1.ResolvedSource {
+	return &profilesv1.ResolvedSource{
+		URL:    s.URL,
+		Branch: s.Branch,
+		Tag:    s.Tag,
+		Path:   s.Path,
+		Commit: s.Commit,
+	}
+}
+
+// ProfileArtifact is an artifact of the installed profile or one of its nested
+// profiles, together with the source containing it.
+type ProfileArtifact struct {
+	profilesv1.Artifact
+	Source Source
+}
+
+// MakeArtifacts returns the Flux resources which deploy the artifacts of a profile for the
+// given installation. Nested profiles must have been expanded with Expand. The values of chart
+// artifacts are merged with the user supplied values. Resources are
+// returned in dependency order and the dependencies between artifacts are set as the
+// dependsOn of the resources.
+func MakeArtifacts(pi profilesv1.ProfileInstallation, profileArtifacts []ProfileArtifact, values Values) ([]client.Object, error) {
+	spec := profilesv1.ProfileDefinitionSpec{}
+	sources := make(map[string]Source)
+	for _, artifact := range profileArtifacts {
+		if err := validateArtifact(artifact.Artifact); err != nil {
+			return nil, err
+		}
+		if _, ok := sources[artifact.Name]; ok {
+			return nil, fmt.Errorf("duplicate artifact name %q", artifact.Name)
+		}
+		sources[artifact.Name] = artifact.Source
+		spec.Artifacts = append(spec.Artifacts, artifact.Artifact)
+	}
+
+	artifacts, err := SortArtifacts(spec)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateDependencies(artifacts); err != nil {
+		return nil, err
+	}
+
+	var objs []client.Object
+	for _, artifact := range artifacts {
+		source := sources[artifact.Name]
+		switch {
+		case artifact.Kustomize != nil:
+			objs = append(objs, makeKustomization(pi, artifact, source))
+		case artifact.Chart != nil:
+			chartObjs, err := makeChartResources(pi, artifact, source, values)
+			if err != nil {
+				return nil, err
+			}
+			objs = append(objs, chartObjs...)
+		case artifact.Profile != nil:
+			return nil, fmt.Errorf("artifact %q: nested profile has not been expanded", artifact.Name)
+		}
+	}
+	return objs, nil
+}
+
+func validateArtifact(artifact profilesv1.Artifact) error {
+	if artifact.Name == "" {
+		return fmt.Errorf("artifact name must be set")
+	}
+	kinds := 0
+	if artifact.Chart != nil {
+		kinds++
+	}
+	if artifact.Kustomize != nil {
+		kinds++
+	}
+	if artifact.Profile != nil {
+		kinds++
+	}
+	if kinds != 1 {
+		return fmt.Errorf("artifact %q must define exactly one of chart, kustomize or profile", artifact.Name)
+	}
+	if artifact.Chart != nil && artifact.Chart.Path == "" && (artifact.Chart.URL == "" || artifact.Chart.Name == "") {
+		return fmt.Errorf("artifact %q: chart must define either a path or a url and name", artifact.Name)
+	}
+	if artifact.Profile != nil && artifact.Profile.Source == nil {
+		return fmt.Errorf("artifact %q: profile must define a source", artifact.Name)
+	}
+	return nil
+}
+
+// validateDependencies checks that artifacts only depend on artifacts of the same kind,
+// as a HelmRelease can only depend on HelmReleases and a Kustomization on Kustomizations.
+func validateDependencies(artifacts []profilesv1.Artifact) error {
+	kinds := make(map[string]string, len(artifacts))
+	for _, artifact := range artifacts {
+		kinds[artifact.Name] = artifactKind(artifact)
+	}
+	for _, artifact := range artifacts {
+		for _, dep := range artifact.DependsOn {
+			if kinds[dep.Name] != kinds[artifact.Name] {
+				return fmt.Errorf("artifact %q (%s) cannot depend on artifact %q (%s): dependencies must be of the same kind",
+					artifact.Name, kinds[artifact.Name], dep.Name, kinds[dep.Name])
+			}
+		}
+	}
+	return nil
+}
+
+func artifactKind(artifact profilesv1.Artifact) string {
+	switch {
+	case artifact.Chart != nil:
+		return "chart"
+	case artifact.Kustomize != nil:
+		return "kustomize"
+	default:
+		return "profile"
+	}
+}
+
+func makeDependsOn(pi profilesv1.ProfileInstallation, artifact profilesv1.Artifact) []dependency.CrossNamespaceDependencyReference {
+	var deps []dependency.CrossNamespaceDependencyReference
+	for _, dep := range artifact.DependsOn {
+		deps = append(deps, dependency.CrossNamespaceDependencyReference{
+			Name:      makeArtifactName(pi, profilesv1.Artifact{Name: dep.Name}),
+			Namespace: pi.Namespace,
+		})
+	}
+	return deps
+}
+
+func makeArtifactName(pi profilesv1.ProfileInstallation, artifact profilesv1.Artifact) string {
+	return fmt.Sprintf("%s-%s", pi.Name, artifact.Name)
+}
+
+func makeKustomization(pi profilesv1.ProfileInstallation, artifact profilesv1.Artifact, source Source) *kustomizev1.Kustomization {
+	return &kustomizev1.Kustomization{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      makeArtifactName(pi, artifact),
+			Namespace: pi.Namespace,
+		},
+		TypeMeta: metav1.TypeMeta{
+			Kind:       kustomizev1.KustomizationKind,
+			APIVersion: kustomizev1.GroupVersion.String(),
+		},
+		Spec: kustomizev1.KustomizationSpec{
+			Interval: metav1.Duration{Duration: defaultInterval},
+			Path:     filepath.Join(source.Path, artifact.Kustomize.Path),
+			Prune:    true,
+			SourceRef: kustomizev1.CrossNamespaceSourceReference{
+				Kind:      sourcev1.GitRepositoryKind,
+				Name:      source.Name,
+				Namespace: source.Namespace,
+			},
+			TargetNamespace: pi.Namespace,
+			DependsOn:       makeDependsOn(pi, artifact),
+		},
+	}
+}
+
+func makeChartResources(pi profilesv1.ProfileInstallation, artifact profilesv1.Artifact, source Source, values Values) ([]client.Object, error) {
+	name := makeArtifactName(pi, artifact)
+	release := &helmv2.HelmRelease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: pi.Namespace,
+		},
+		TypeMeta: metav1.TypeMeta{
+			Kind:       helmv2.HelmReleaseKind,
+			APIVersion: helmv2.GroupVersion.String(),
+		},
+		Spec: helmv2.HelmReleaseSpec{
+			Interval:  metav1.Duration{Duration: defaultInterval},
+			DependsOn: makeDependsOn(pi, artifact),
+		},
+	}
+
+	var err error
+	release.Spec.Values, release.Spec.ValuesFrom, err = values.makeValues(artifact.Name, artifact.Chart.DefaultValues)
+	if err != nil {
+		return nil, err
+	}
+
+	if artifact.Chart.Path != "" {
+		release.Spec.Chart = helmv2.HelmChartTemplate{
+			Spec: helmv2.HelmChartTemplateSpec{
+				Chart: filepath.Join(source.Path, artifact.Chart.Path),
+				SourceRef: helmv2.CrossNamespaceObjectReference{
+					Kind:      sourcev1.GitRepositoryKind,
+					Name:      source.Name,
+					Namespace: source.Namespace,
+				},
+			},
+		}
+		return []client.Object{release}, nil
+	}
+
+	helmRepo := &sourcev1.HelmRepository{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: pi.Namespace,
+		},
+		TypeMeta: metav1.TypeMeta{
+			Kind:       sourcev1.HelmRepositoryKind,
+			APIVersion: sourcev1.GroupVersion.String(),
+		},
+		Spec: sourcev1.HelmRepositorySpec{
+			URL:      artifact.Chart.URL,
+			Interval: metav1.Duration{Duration: defaultInterval},
+		},
+	}
+	release.Spec.Chart = helmv2.HelmChartTemplate{
+		Spec: helmv2.HelmChartTemplateSpec{
+			Chart:   artifact.Chart.Name,
+			Version: artifact.Chart.Version,
+			SourceRef: helmv2.CrossNamespaceObjectReference{
+				Kind:      sourcev1.HelmRepositoryKind,
+				Name:      helmRepo.Name,
+				Namespace: helmRepo.Namespace,
+			},
+		},
+	}
+	return []client.Object{helmRepo, release}, nil
+}
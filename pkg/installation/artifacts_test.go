@@ -0,0 +1,259 @@
+package installation_test
+
+import (
+	"time"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2beta1"
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta1"
+	"github.com/fluxcd/pkg/runtime/dependency"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	profilesv1 "github.com/weaveworks/profiles/api/v1alpha1"
+	"github.com/weaveworks/profiles/pkg/installation"
+)
+
+var _ = Describe("MakeArtifacts", func() {
+	var (
+		pi     profilesv1.ProfileInstallation
+		def    profilesv1.ProfileDefinition
+		source = installation.Source{
+			Name:      "my-profile",
+			Namespace: "default",
+			Path:      "weaveworks-nginx",
+		}
+	)
+
+	profileArtifacts := func() []installation.ProfileArtifact {
+		var artifacts []installation.ProfileArtifact
+		for _, artifact := range def.Spec.Artifacts {
+			artifacts = append(artifacts, installation.ProfileArtifact{Artifact: artifact, Source: source})
+		}
+		return artifacts
+	}
+
+	BeforeEach(func() {
+		pi = profilesv1.ProfileInstallation{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-profile",
+				Namespace: "default",
+			},
+		}
+		def = profilesv1.ProfileDefinition{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "nginx",
+			},
+		}
+	})
+
+	It("creates a resource per artifact", func() {
+		def.Spec.Artifacts = []profilesv1.Artifact{
+			{
+				Name: "dokuwiki",
+				Chart: &profilesv1.Chart{
+					URL:           "https://charts.bitnami.com/bitnami",
+					Name:          "dokuwiki",
+					Version:       "11.1.6",
+					DefaultValues: "service:\n  port: 8080",
+				},
+			},
+			{
+				Name: "nginx-server",
+				Chart: &profilesv1.Chart{
+					Path: "nginx/chart",
+				},
+			},
+			{
+				Name: "nginx-deployment",
+				Kustomize: &profilesv1.Kustomize{
+					Path: "nginx/deployment",
+				},
+			},
+		}
+
+		objs, err := installation.MakeArtifacts(pi, profileArtifacts(), installation.Values{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(objs).To(HaveLen(4))
+
+		interval := metav1.Duration{Duration: time.Minute * 5}
+		Expect(objs).To(Equal([]client.Object{
+			&sourcev1.HelmRepository{
+				ObjectMeta: metav1.ObjectMeta{Name: "my-profile-dokuwiki", Namespace: "default"},
+				TypeMeta:   metav1.TypeMeta{Kind: "HelmRepository", APIVersion: "source.toolkit.fluxcd.io/v1beta1"},
+				Spec: sourcev1.HelmRepositorySpec{
+					URL:      "https://charts.bitnami.com/bitnami",
+					Interval: interval,
+				},
+			},
+			&helmv2.HelmRelease{
+				ObjectMeta: metav1.ObjectMeta{Name: "my-profile-dokuwiki", Namespace: "default"},
+				TypeMeta:   metav1.TypeMeta{Kind: "HelmRelease", APIVersion: "helm.toolkit.fluxcd.io/v2beta1"},
+				Spec: helmv2.HelmReleaseSpec{
+					Interval: interval,
+					Values:   &apiextensionsv1.JSON{Raw: []byte(`{"service":{"port":8080}}`)},
+					Chart: helmv2.HelmChartTemplate{
+						Spec: helmv2.HelmChartTemplateSpec{
+							Chart:   "dokuwiki",
+							Version: "11.1.6",
+							SourceRef: helmv2.CrossNamespaceObjectReference{
+								Kind:      "HelmRepository",
+								Name:      "my-profile-dokuwiki",
+								Namespace: "default",
+							},
+						},
+					},
+				},
+			},
+			&helmv2.HelmRelease{
+				ObjectMeta: metav1.ObjectMeta{Name: "my-profile-nginx-server", Namespace: "default"},
+				TypeMeta:   metav1.TypeMeta{Kind: "HelmRelease", APIVersion: "helm.toolkit.fluxcd.io/v2beta1"},
+				Spec: helmv2.HelmReleaseSpec{
+					Interval: interval,
+					Chart: helmv2.HelmChartTemplate{
+						Spec: helmv2.HelmChartTemplateSpec{
+							Chart: "weaveworks-nginx/nginx/chart",
+							SourceRef: helmv2.CrossNamespaceObjectReference{
+								Kind:      "GitRepository",
+								Name:      "my-profile",
+								Namespace: "default",
+							},
+						},
+					},
+				},
+			},
+			&kustomizev1.Kustomization{
+				ObjectMeta: metav1.ObjectMeta{Name: "my-profile-nginx-deployment", Namespace: "default"},
+				TypeMeta:   metav1.TypeMeta{Kind: "Kustomization", APIVersion: "kustomize.toolkit.fluxcd.io/v1beta1"},
+				Spec: kustomizev1.KustomizationSpec{
+					Interval: interval,
+					Path:     "weaveworks-nginx/nginx/deployment",
+					Prune:    true,
+					SourceRef: kustomizev1.CrossNamespaceSourceReference{
+						Kind:      "GitRepository",
+						Name:      "my-profile",
+						Namespace: "default",
+					},
+					TargetNamespace: "default",
+				},
+			},
+		}))
+	})
+
+	When("artifacts depend on each other", func() {
+		It("creates the dependencies first and sets dependsOn", func() {
+			def.Spec.Artifacts = []profilesv1.Artifact{
+				{
+					Name:      "nginx-server",
+					Chart:     &profilesv1.Chart{Path: "nginx/chart"},
+					DependsOn: []profilesv1.DependsOn{{Name: "nginx-config"}},
+				},
+				{
+					Name:  "nginx-config",
+					Chart: &profilesv1.Chart{Path: "nginx/config"},
+				},
+			}
+
+			objs, err := installation.MakeArtifacts(pi, profileArtifacts(), installation.Values{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(objs).To(HaveLen(2))
+			Expect(objs[0].GetName()).To(Equal("my-profile-nginx-config"))
+			Expect(objs[1].GetName()).To(Equal("my-profile-nginx-server"))
+			Expect(objs[1].(*helmv2.HelmRelease).Spec.DependsOn).To(Equal([]dependency.CrossNamespaceDependencyReference{
+				{Name: "my-profile-nginx-config", Namespace: "default"},
+			}))
+		})
+
+		When("the dependency is of a different kind", func() {
+			It("returns an error", func() {
+				def.Spec.Artifacts = []profilesv1.Artifact{
+					{Name: "foo", Chart: &profilesv1.Chart{Path: "foo"}, DependsOn: []profilesv1.DependsOn{{Name: "bar"}}},
+					{Name: "bar", Kustomize: &profilesv1.Kustomize{Path: "bar"}},
+				}
+				_, err := installation.MakeArtifacts(pi, profileArtifacts(), installation.Values{})
+				Expect(err).To(MatchError(`artifact "foo" (chart) cannot depend on artifact "bar" (kustomize): dependencies must be of the same kind`))
+			})
+		})
+
+		When("the dependencies contain a cycle", func() {
+			It("returns an error", func() {
+				def.Spec.Artifacts = []profilesv1.Artifact{
+					{Name: "foo", Kustomize: &profilesv1.Kustomize{Path: "foo"}, DependsOn: []profilesv1.DependsOn{{Name: "bar"}}},
+					{Name: "bar", Kustomize: &profilesv1.Kustomize{Path: "bar"}, DependsOn: []profilesv1.DependsOn{{Name: "foo"}}},
+				}
+				_, err := installation.MakeArtifacts(pi, profileArtifacts(), installation.Values{})
+				Expect(err).To(MatchError("dependency cycle detected: foo -> bar -> foo"))
+			})
+		})
+	})
+
+	When("artifacts come from different sources", func() {
+		It("uses the source of each artifact", func() {
+			objs, err := installation.MakeArtifacts(pi, []installation.ProfileArtifact{
+				{
+					Artifact: profilesv1.Artifact{Name: "nginx", Kustomize: &profilesv1.Kustomize{Path: "nginx"}},
+					Source:   source,
+				},
+				{
+					Artifact: profilesv1.Artifact{Name: "base-nginx", Kustomize: &profilesv1.Kustomize{Path: "nginx"}},
+					Source:   installation.Source{Name: "my-profile-base", Namespace: "default", Path: "base"},
+				},
+			}, installation.Values{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(objs).To(HaveLen(2))
+			Expect(objs[1].GetName()).To(Equal("my-profile-base-nginx"))
+			Expect(objs[1].(*kustomizev1.Kustomization).Spec.Path).To(Equal("base/nginx"))
+			Expect(objs[1].(*kustomizev1.Kustomization).Spec.SourceRef.Name).To(Equal("my-profile-base"))
+		})
+	})
+
+	When("a nested profile has not been expanded", func() {
+		It("returns an error", func() {
+			def.Spec.Artifacts = []profilesv1.Artifact{
+				{Name: "base", Profile: &profilesv1.Profile{Source: &profilesv1.Source{Path: "base"}}},
+			}
+			_, err := installation.MakeArtifacts(pi, profileArtifacts(), installation.Values{})
+			Expect(err).To(MatchError(`artifact "base": nested profile has not been expanded`))
+		})
+	})
+
+	When("an artifact is invalid", func() {
+		It("returns an error", func() {
+			def.Spec.Artifacts = []profilesv1.Artifact{
+				{Name: "foo", Kustomize: &profilesv1.Kustomize{Path: "foo"}, Chart: &profilesv1.Chart{Path: "foo"}},
+			}
+			_, err := installation.MakeArtifacts(pi, profileArtifacts(), installation.Values{})
+			Expect(err).To(MatchError(`artifact "foo" must define exactly one of chart, kustomize or profile`))
+
+			def.Spec.Artifacts = []profilesv1.Artifact{
+				{Name: "foo", Chart: &profilesv1.Chart{Name: "foo"}},
+			}
+			_, err = installation.MakeArtifacts(pi, profileArtifacts(), installation.Values{})
+			Expect(err).To(MatchError(`artifact "foo": chart must define either a path or a url and name`))
+		})
+	})
+
+	When("artifact names are not unique", func() {
+		It("returns an error", func() {
+			def.Spec.Artifacts = []profilesv1.Artifact{
+				{Name: "foo", Kustomize: &profilesv1.Kustomize{Path: "foo"}},
+				{Name: "foo", Kustomize: &profilesv1.Kustomize{Path: "bar"}},
+			}
+			_, err := installation.MakeArtifacts(pi, profileArtifacts(), installation.Values{})
+			Expect(err).To(MatchError(`duplicate artifact name "foo"`))
+		})
+	})
+
+	When("the default values are not valid yaml", func() {
+		It("returns an error", func() {
+			def.Spec.Artifacts = []profilesv1.Artifact{
+				{Name: "foo", Chart: &profilesv1.Chart{Path: "foo", DefaultValues: "foo: [bar"}},
+			}
+			_, err := installation.MakeArtifacts(pi, profileArtifacts(), installation.Values{})
+			Expect(err).To(MatchError(ContainSubstring(`artifact "foo": failed to parse default values:`)))
+		})
+	})
+})
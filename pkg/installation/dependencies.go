@@ -0,0 +1,75 @@
+package installation
+
+import (
+	"fmt"
+	"strings"
+
+	profilesv1 "github.com/weaveworks/profiles/api/v1alpha1"
+)
+
+// SortArtifacts returns the artifacts of a ProfileDefinition ordered so that every
+// artifact comes after the artifacts it depends on. Artifacts without a dependency
+// between them keep the order they are defined in. An error is returned when an
+// artifact depends on an artifact which does not exist or the dependencies form a cycle.
+func SortArtifacts(spec profilesv1.ProfileDefinitionSpec) ([]profilesv1.Artifact, error) {
+	artifacts := make(map[string]profilesv1.Artifact, len(spec.Artifacts))
+	for _, artifact := range spec.Artifacts {
+		artifacts[artifact.Name] = artifact
+	}
+	for _, artifact := range spec.Artifacts {
+		for _, dep := range artifact.DependsOn {
+			if _, ok := artifacts[dep.Name]; !ok {
+				return nil, fmt.Errorf("artifact %q depends on unknown artifact %q", artifact.Name, dep.Name)
+			}
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	var (
+		sorted = make([]profilesv1.Artifact, 0, len(spec.Artifacts))
+		state  = make(map[string]int, len(spec.Artifacts))
+		path   []string
+		visit  func(name string) error
+	)
+	visit = func(name string) error {
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			cycle := append(path[indexOf(path, name):], name)
+			return fmt.Errorf("dependency cycle detected: %s", strings.Join(cycle, " -> "))
+		}
+
+		state[name] = visiting
+		path = append(path, name)
+		for _, dep := range artifacts[name].DependsOn {
+			if err := visit(dep.Name); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+		sorted = append(sorted, artifacts[name])
+		return nil
+	}
+
+	for _, artifact := range spec.Artifacts {
+		if err := visit(artifact.Name); err != nil {
+			return nil, err
+		}
+	}
+	return sorted, nil
+}
+
+func indexOf(names []string, name string) int {
+	for i, n := range names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
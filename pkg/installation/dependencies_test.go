@@ -0,0 +1,69 @@
+package installation_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	profilesv1 "github.com/weaveworks/profiles/api/v1alpha1"
+	"github.com/weaveworks/profiles/pkg/installation"
+)
+
+var _ = Describe("SortArtifacts", func() {
+	artifact := func(name string, deps ...string) profilesv1.Artifact {
+		a := profilesv1.Artifact{Name: name, Kustomize: &profilesv1.Kustomize{Path: name}}
+		for _, dep := range deps {
+			a.DependsOn = append(a.DependsOn, profilesv1.DependsOn{Name: dep})
+		}
+		return a
+	}
+
+	names := func(artifacts []profilesv1.Artifact) []string {
+		var n []string
+		for _, a := range artifacts {
+			n = append(n, a.Name)
+		}
+		return n
+	}
+
+	It("orders artifacts after their dependencies", func() {
+		sorted, err := installation.SortArtifacts(profilesv1.ProfileDefinitionSpec{
+			Artifacts: []profilesv1.Artifact{
+				artifact("app", "database", "cache"),
+				artifact("database", "storage"),
+				artifact("cache"),
+				artifact("storage"),
+				artifact("monitoring"),
+			},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(names(sorted)).To(Equal([]string{"storage", "database", "cache", "app", "monitoring"}))
+	})
+
+	When("an artifact depends on an unknown artifact", func() {
+		It("returns an error", func() {
+			_, err := installation.SortArtifacts(profilesv1.ProfileDefinitionSpec{
+				Artifacts: []profilesv1.Artifact{artifact("app", "database")},
+			})
+			Expect(err).To(MatchError(`artifact "app" depends on unknown artifact "database"`))
+		})
+	})
+
+	When("the dependencies contain a cycle", func() {
+		It("returns an error describing the cycle", func() {
+			_, err := installation.SortArtifacts(profilesv1.ProfileDefinitionSpec{
+				Artifacts: []profilesv1.Artifact{
+					artifact("monitoring"),
+					artifact("app", "database"),
+					artifact("database", "storage"),
+					artifact("storage", "app"),
+				},
+			})
+			Expect(err).To(MatchError("dependency cycle detected: app -> database -> storage -> app"))
+
+			_, err = installation.SortArtifacts(profilesv1.ProfileDefinitionSpec{
+				Artifacts: []profilesv1.Artifact{artifact("app", "app")},
+			})
+			Expect(err).To(MatchError("dependency cycle detected: app -> app"))
+		})
+	})
+})
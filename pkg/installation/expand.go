@@ -0,0 +1,163 @@
+package installation
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	profilesv1 "github.com/weaveworks/profiles/api/v1alpha1"
+)
+
+// DefaultMaxDepth is the maximum number of nested profile levels expanded by default.
+const DefaultMaxDepth = 5
+
+// ErrSourceNotReady is returned by a Loader while the source of a nested profile
+// has not been fetched yet.
+var ErrSourceNotReady = errors.New("source is not ready")
+
+//counterfeiter:generate -o fakes/fake_loader.go . Loader
+// Loader loads the ProfileDefinitions of nested profiles
+type Loader interface {
+	// Load returns the definition of the profile included by the artifact name, and
+	// the source containing it. A profile source without a URL refers to the
+	// repository of the parent profile.
+	Load(parent Source, name string, profile profilesv1.Source) (*profilesv1.ProfileDefinition, Source, error)
+}
+
+// Expand flattens a ProfileDefinition and the profiles it includes into a single list of
+// artifacts. Artifacts of nested profiles are named after the artifact including the
+// profile, e.g. artifact "nginx" of a profile included by artifact "base" becomes
+// "base-nginx". Depending on a profile artifact depends on the artifacts of that profile
+// which are of the same kind as the dependent artifact.
+// The status of every nested profile is returned, even when expansion fails. An error
+// wrapping ErrSourceNotReady is returned when a nested profile could not be loaded yet.
+func Expand(def profilesv1.ProfileDefinition, source Source, loader Loader, maxDepth int) ([]ProfileArtifact, []profilesv1.NestedProfileStatus, error) {
+	e := &expander{loader: loader, maxDepth: maxDepth}
+	artifacts, err := e.expand(def, source, "", 0, []string{sourceID(source)})
+	if err != nil {
+		return nil, e.statuses, err
+	}
+	return artifacts, e.statuses, nil
+}
+
+type expander struct {
+	loader   Loader
+	maxDepth int
+	statuses []profilesv1.NestedProfileStatus
+}
+
+func (e *expander) expand(def profilesv1.ProfileDefinition, source Source, prefix string, depth int, chain []string) ([]ProfileArtifact, error) {
+	seen := make(map[string]bool)
+	for _, artifact := range def.Spec.Artifacts {
+		if err := validateArtifact(artifact); err != nil {
+			return nil, err
+		}
+		if seen[artifact.Name] {
+			return nil, fmt.Errorf("duplicate artifact name %q", artifact.Name)
+		}
+		seen[artifact.Name] = true
+	}
+	if _, err := SortArtifacts(def.Spec); err != nil {
+		return nil, err
+	}
+
+	var notReady error
+	expanded := make(map[string][]ProfileArtifact, len(def.Spec.Artifacts))
+	for _, artifact := range def.Spec.Artifacts {
+		name := prefix + artifact.Name
+		if artifact.Profile == nil {
+			leaf := *artifact.DeepCopy()
+			leaf.Name = name
+			leaf.DependsOn = nil
+			expanded[artifact.Name] = []ProfileArtifact{{Artifact: leaf, Source: source}}
+			continue
+		}
+
+		artifacts, err := e.expandProfile(artifact, source, name, depth+1, chain)
+		if errors.Is(err, ErrSourceNotReady) {
+			if notReady == nil {
+				notReady = err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		expanded[artifact.Name] = artifacts
+	}
+	if notReady != nil {
+		return nil, notReady
+	}
+
+	var result []ProfileArtifact
+	for _, artifact := range def.Spec.Artifacts {
+		for _, leaf := range expanded[artifact.Name] {
+			dependsOn := append([]profilesv1.DependsOn(nil), leaf.DependsOn...)
+			for _, dep := range artifact.DependsOn {
+				dependsOn = append(dependsOn, dependenciesOfKind(expanded[dep.Name], artifactKind(leaf.Artifact))...)
+			}
+			leaf.DependsOn = dependsOn
+			result = append(result, leaf)
+		}
+	}
+	return result, nil
+}
+
+func (e *expander) expandProfile(artifact profilesv1.Artifact, parent Source, name string, depth int, chain []string) ([]ProfileArtifact, error) {
+	if depth > e.maxDepth {
+		return nil, fmt.Errorf("artifact %q: nested profiles exceed the maximum depth of %d", artifact.Name, e.maxDepth)
+	}
+
+	index := len(e.statuses)
+	e.statuses = append(e.statuses, profilesv1.NestedProfileStatus{Name: name, Depth: depth})
+	fail := func(err error) ([]ProfileArtifact, error) {
+		e.statuses[index].Message = err.Error()
+		return nil, fmt.Errorf("artifact %q: %w", artifact.Name, err)
+	}
+
+	def, source, err := e.loader.Load(parent, name, *artifact.Profile.Source)
+	if err != nil {
+		return fail(err)
+	}
+	e.statuses[index].Source = source.Resolved()
+
+	id := sourceID(source)
+	for _, included := range chain {
+		if included == id {
+			return fail(fmt.Errorf("profile includes itself: %s", strings.Join(append(chain, id), " -> ")))
+		}
+	}
+
+	artifacts, err := e.expand(*def, source, name+"-", depth, append(chain[:len(chain):len(chain)], id))
+	if err != nil {
+		return fail(err)
+	}
+	e.statuses[index].Ready = true
+	return artifacts, nil
+}
+
+// dependenciesOfKind returns the artifacts to depend on when depending on artifacts, which
+// are all the expanded artifacts of an artifact. Only the artifacts of the given kind are
+// returned, unless there are none, in which case dependency validation reports the mismatch.
+func dependenciesOfKind(artifacts []ProfileArtifact, kind string) []profilesv1.DependsOn {
+	var all, ofKind []profilesv1.DependsOn
+	for _, artifact := range artifacts {
+		all = append(all, profilesv1.DependsOn{Name: artifact.Name})
+		if artifactKind(artifact.Artifact) == kind {
+			ofKind = append(ofKind, profilesv1.DependsOn{Name: artifact.Name})
+		}
+	}
+	if len(ofKind) == 0 {
+		return all
+	}
+	return ofKind
+}
+
+// sourceID identifies the profile in a source, used to detect a profile including itself.
+func sourceID(source Source) string {
+	ref := source.Tag
+	if ref == "" {
+		ref = source.Branch
+	}
+	return fmt.Sprintf("%s//%s@%s", source.URL, source.Path, ref)
+}
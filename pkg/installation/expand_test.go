@@ -0,0 +1,190 @@
+package installation_test
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	profilesv1 "github.com/weaveworks/profiles/api/v1alpha1"
+	"github.com/weaveworks/profiles/pkg/installation"
+	"github.com/weaveworks/profiles/pkg/installation/fakes"
+)
+
+var _ = Describe("Expand", func() {
+	var (
+		fakeLoader *fakes.FakeLoader
+		root       profilesv1.ProfileDefinition
+		rootSource = installation.Source{
+			Name:      "my-profile",
+			Namespace: "default",
+			Path:      "product",
+			URL:       "https://github.com/weaveworks/profiles-examples",
+			Branch:    "main",
+		}
+		definitions map[string]profilesv1.ProfileDefinition
+	)
+
+	kustomize := func(name string, deps ...string) profilesv1.Artifact {
+		a := profilesv1.Artifact{Name: name, Kustomize: &profilesv1.Kustomize{Path: name}}
+		for _, dep := range deps {
+			a.DependsOn = append(a.DependsOn, profilesv1.DependsOn{Name: dep})
+		}
+		return a
+	}
+
+	profile := func(name, path string, deps ...string) profilesv1.Artifact {
+		a := profilesv1.Artifact{Name: name, Profile: &profilesv1.Profile{Source: &profilesv1.Source{Path: path}}}
+		for _, dep := range deps {
+			a.DependsOn = append(a.DependsOn, profilesv1.DependsOn{Name: dep})
+		}
+		return a
+	}
+
+	definition := func(artifacts ...profilesv1.Artifact) profilesv1.ProfileDefinition {
+		return profilesv1.ProfileDefinition{
+			ObjectMeta: metav1.ObjectMeta{Name: "profile"},
+			Spec:       profilesv1.ProfileDefinitionSpec{Artifacts: artifacts},
+		}
+	}
+
+	BeforeEach(func() {
+		definitions = make(map[string]profilesv1.ProfileDefinition)
+		fakeLoader = new(fakes.FakeLoader)
+		fakeLoader.LoadStub = func(parent installation.Source, name string, src profilesv1.Source) (*profilesv1.ProfileDefinition, installation.Source, error) {
+			def, ok := definitions[src.Path]
+			if !ok {
+				return nil, installation.Source{}, fmt.Errorf("%s not found", src.Path)
+			}
+			source := parent
+			source.Path = src.Path
+			return &def, source, nil
+		}
+	})
+
+	It("flattens nested profiles into namespaced artifacts", func() {
+		definitions["base"] = definition(kustomize("namespace"), kustomize("nginx", "namespace"), profile("core", "core"))
+		definitions["core"] = definition(kustomize("crds"))
+		root = definition(profile("base", "base"), kustomize("app", "base"))
+
+		artifacts, statuses, err := installation.Expand(root, rootSource, fakeLoader, installation.DefaultMaxDepth)
+		Expect(err).NotTo(HaveOccurred())
+
+		var names []string
+		for _, a := range artifacts {
+			names = append(names, a.Name)
+		}
+		Expect(names).To(Equal([]string{"base-namespace", "base-nginx", "base-core-crds", "app"}))
+		Expect(artifacts[0].Source.Path).To(Equal("base"))
+		Expect(artifacts[2].Source.Path).To(Equal("core"))
+		Expect(artifacts[3].Source).To(Equal(rootSource))
+
+		Expect(artifacts[1].DependsOn).To(Equal([]profilesv1.DependsOn{{Name: "base-namespace"}}))
+		Expect(artifacts[3].DependsOn).To(Equal([]profilesv1.DependsOn{
+			{Name: "base-namespace"}, {Name: "base-nginx"}, {Name: "base-core-crds"},
+		}))
+
+		Expect(statuses).To(Equal([]profilesv1.NestedProfileStatus{
+			{
+				Name:   "base",
+				Depth:  1,
+				Ready:  true,
+				Source: &profilesv1.ResolvedSource{URL: rootSource.URL, Branch: "main", Path: "base"},
+			},
+			{
+				Name:   "base-core",
+				Depth:  2,
+				Ready:  true,
+				Source: &profilesv1.ResolvedSource{URL: rootSource.URL, Branch: "main", Path: "core"},
+			},
+		}))
+
+		parent, name, src := fakeLoader.LoadArgsForCall(1)
+		Expect(parent.Path).To(Equal("base"))
+		Expect(name).To(Equal("base-core"))
+		Expect(src).To(Equal(profilesv1.Source{Path: "core"}))
+	})
+
+	When("a nested profile has dependencies", func() {
+		It("adds the dependencies to the artifacts of the nested profile", func() {
+			definitions["base"] = definition(kustomize("nginx"), profilesv1.Artifact{Name: "chart", Chart: &profilesv1.Chart{Path: "chart"}})
+			root = definition(kustomize("namespace"), profile("base", "base", "namespace"))
+
+			artifacts, _, err := installation.Expand(root, rootSource, fakeLoader, installation.DefaultMaxDepth)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(artifacts[1].Name).To(Equal("base-nginx"))
+			Expect(artifacts[1].DependsOn).To(Equal([]profilesv1.DependsOn{{Name: "namespace"}}))
+			Expect(artifacts[2].Name).To(Equal("base-chart"))
+			Expect(artifacts[2].DependsOn).To(Equal([]profilesv1.DependsOn{{Name: "namespace"}}))
+		})
+	})
+
+	When("a profile includes itself", func() {
+		It("returns an error", func() {
+			definitions["base"] = definition(profile("core", "core"))
+			definitions["core"] = definition(profile("base", "base"))
+			root = definition(profile("base", "base"))
+
+			_, statuses, err := installation.Expand(root, rootSource, fakeLoader, installation.DefaultMaxDepth)
+			Expect(err).To(MatchError(ContainSubstring("profile includes itself: " +
+				"https://github.com/weaveworks/profiles-examples//product@main -> " +
+				"https://github.com/weaveworks/profiles-examples//base@main -> " +
+				"https://github.com/weaveworks/profiles-examples//core@main -> " +
+				"https://github.com/weaveworks/profiles-examples//base@main")))
+			Expect(err.Error()).To(HavePrefix(`artifact "base": artifact "core": artifact "base": `))
+			Expect(statuses).To(HaveLen(3))
+			for _, status := range statuses {
+				Expect(status.Ready).To(BeFalse())
+			}
+		})
+	})
+
+	When("the nested profiles are too deep", func() {
+		It("returns an error", func() {
+			definitions["one"] = definition(profile("two", "two"))
+			definitions["two"] = definition(profile("three", "three"))
+			definitions["three"] = definition(kustomize("nginx"))
+			root = definition(profile("one", "one"))
+
+			_, _, err := installation.Expand(root, rootSource, fakeLoader, 2)
+			Expect(err).To(MatchError(`artifact "one": artifact "two": artifact "three": nested profiles exceed the maximum depth of 2`))
+
+			_, _, err = installation.Expand(root, rootSource, fakeLoader, 3)
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	When("the source of a nested profile is not ready", func() {
+		It("loads the other nested profiles and returns ErrSourceNotReady", func() {
+			definitions["core"] = definition(kustomize("crds"))
+			fakeLoader.LoadStub = func(parent installation.Source, name string, src profilesv1.Source) (*profilesv1.ProfileDefinition, installation.Source, error) {
+				if src.Path == "base" {
+					return nil, installation.Source{}, fmt.Errorf("waiting for base: %w", installation.ErrSourceNotReady)
+				}
+				def := definitions[src.Path]
+				return &def, installation.Source{Path: src.Path}, nil
+			}
+			root = definition(profile("base", "base"), profile("core", "core"))
+
+			_, statuses, err := installation.Expand(root, rootSource, fakeLoader, installation.DefaultMaxDepth)
+			Expect(err).To(MatchError(installation.ErrSourceNotReady))
+			Expect(fakeLoader.LoadCallCount()).To(Equal(2))
+			Expect(statuses).To(HaveLen(2))
+			Expect(statuses[0].Ready).To(BeFalse())
+			Expect(statuses[0].Message).To(Equal("waiting for base: source is not ready"))
+			Expect(statuses[1].Ready).To(BeTrue())
+		})
+	})
+
+	When("a nested profile is invalid", func() {
+		It("returns an error", func() {
+			definitions["base"] = definition(kustomize("nginx", "missing"))
+			root = definition(profile("base", "base"))
+
+			_, statuses, err := installation.Expand(root, rootSource, fakeLoader, installation.DefaultMaxDepth)
+			Expect(err).To(MatchError(`artifact "base": artifact "nginx" depends on unknown artifact "missing"`))
+			Expect(statuses[0].Message).To(Equal(`artifact "nginx" depends on unknown artifact "missing"`))
+		})
+	})
+})
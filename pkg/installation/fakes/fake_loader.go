@@ -0,0 +1,126 @@
+// Code generated by counterfeiter. DO NOT EDIT.
+package fakes
+
+import (
+	"sync"
+
+	"github.com/weaveworks/profiles/api/v1alpha1"
+	"github.com/weaveworks/profiles/pkg/installation"
+)
+
+type FakeLoader struct {
+	LoadStub        func(installation.Source, string, v1alpha1.Source) (*v1alpha1.ProfileDefinition, installation.Source, error)
+	loadMutex       sync.RWMutex
+	loadArgsForCall []struct {
+		arg1 installation.Source
+		arg2 string
+		arg3 v1alpha1.Source
+	}
+	loadReturns struct {
+		result1 *v1alpha1.ProfileDefinition
+		result2 installation.Source
+		result3 error
+	}
+	loadReturnsOnCall map[int]struct {
+		result1 *v1alpha1.ProfileDefinition
+		result2 installation.Source
+		result3 error
+	}
+	invocations      map[string][][]interface{}
+	invocationsMutex sync.RWMutex
+}
+
+func (fake *FakeLoader) Load(arg1 installation.Source, arg2 string, arg3 v1alpha1.Source) (*v1alpha1.ProfileDefinition, installation.Source, error) {
+	fake.loadMutex.Lock()
+	ret, specificReturn := fake.loadReturnsOnCall[len(fake.loadArgsForCall)]
+	fake.loadArgsForCall = append(fake.loadArgsForCall, struct {
+		arg1 installation.Source
+		arg2 string
+		arg3 v1alpha1.Source
+	}{arg1, arg2, arg3})
+	stub := fake.LoadStub
+	fakeReturns := fake.loadReturns
+	fake.recordInvocation("Load", []interface{}{arg1, arg2, arg3})
+	fake.loadMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2, ret.result3
+	}
+	return fakeReturns.result1, fakeReturns.result2, fakeReturns.result3
+}
+
+func (fake *FakeLoader) LoadCallCount() int {
+	fake.loadMutex.RLock()
+	defer fake.loadMutex.RUnlock()
+	return len(fake.loadArgsForCall)
+}
+
+func (fake *FakeLoader) LoadCalls(stub func(installation.Source, string, v1alpha1.Source) (*v1alpha1.ProfileDefinition, installation.Source, error)) {
+	fake.loadMutex.Lock()
+	defer fake.loadMutex.Unlock()
+	fake.LoadStub = stub
+}
+
+func (fake *FakeLoader) LoadArgsForCall(i int) (installation.Source, string, v1alpha1.Source) {
+	fake.loadMutex.RLock()
+	defer fake.loadMutex.RUnlock()
+	argsForCall := fake.loadArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeLoader) LoadReturns(result1 *v1alpha1.ProfileDefinition, result2 installation.Source, result3 error) {
+	fake.loadMutex.Lock()
+	defer fake.loadMutex.Unlock()
+	fake.LoadStub = nil
+	fake.loadReturns = struct {
+		result1 *v1alpha1.ProfileDefinition
+		result2 installation.Source
+		result3 error
+	}{result1, result2, result3}
+}
+
+func (fake *FakeLoader) LoadReturnsOnCall(i int, result1 *v1alpha1.ProfileDefinition, result2 installation.Source, result3 error) {
+	fake.loadMutex.Lock()
+	defer fake.loadMutex.Unlock()
+	fake.LoadStub = nil
+	if fake.loadReturnsOnCall == nil {
+		fake.loadReturnsOnCall = make(map[int]struct {
+			result1 *v1alpha1.ProfileDefinition
+			result2 installation.Source
+			result3 error
+		})
+	}
+	fake.loadReturnsOnCall[i] = struct {
+		result1 *v1alpha1.ProfileDefinition
+		result2 installation.Source
+		result3 error
+	}{result1, result2, result3}
+}
+
+func (fake *FakeLoader) Invocations() map[string][][]interface{} {
+	fake.invocationsMutex.RLock()
+	defer fake.invocationsMutex.RUnlock()
+	fake.loadMutex.RLock()
+	defer fake.loadMutex.RUnlock()
+	copiedInvocations := map[string][][]interface{}{}
+	for key, value := range fake.invocations {
+		copiedInvocations[key] = value
+	}
+	return copiedInvocations
+}
+
+func (fake *FakeLoader) recordInvocation(key string, args []interface{}) {
+	fake.invocationsMutex.Lock()
+	defer fake.invocationsMutex.Unlock()
+	if fake.invocations == nil {
+		fake.invocations = map[string][][]interface{}{}
+	}
+	if fake.invocations[key] == nil {
+		fake.invocations[key] = [][]interface{}{}
+	}
+	fake.invocations[key] = append(fake.invocations[key], args)
+}
+
+var _ installation.Loader = new(FakeLoader)
@@ -0,0 +1,70 @@
+package installation
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+
+	profilesv1 "github.com/weaveworks/profiles/api/v1alpha1"
+	"github.com/weaveworks/profiles/pkg/scanner"
+)
+
+// DefinitionFile is the default name of the file containing the ProfileDefinition.
+const DefinitionFile = profilesv1.DefaultProfileFilename
+
+//go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 -generate
+//counterfeiter:generate -o fakes/fake_http_client.go . HTTPClient
+//HTTPClient for making HTTP requests
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+//counterfeiter:generate -o fakes/fake_fetcher.go . Fetcher
+// Fetcher retrieves ProfileDefinitions from source artifacts
+type Fetcher interface {
+	Fetch(artifactURL, path, filename string) (*profilesv1.ProfileDefinition, error)
+}
+
+// ArtifactFetcher fetches ProfileDefinitions from the tarballs served by source-controller
+type ArtifactFetcher struct {
+	httpClient HTTPClient
+}
+
+// NewFetcher returns an ArtifactFetcher
+func NewFetcher(httpClient HTTPClient) *ArtifactFetcher {
+	return &ArtifactFetcher{
+		httpClient: httpClient,
+	}
+}
+
+// Fetch downloads the artifact and decodes the definition file located in the directory path,
+// rejecting the definitions the catalog rejects. The definition file is DefinitionFile when
+// filename is empty.
+func (f *ArtifactFetcher) Fetch(artifactURL, path, filename string) (*profilesv1.ProfileDefinition, error) {
+	req, err := http.NewRequest("GET", artifactURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := f.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to GET %q: %w", artifactURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("request failed status code %d", resp.StatusCode)
+	}
+
+	if filename == "" {
+		filename = DefinitionFile
+	}
+	name := filepath.ToSlash(filepath.Join(path, filename))
+	profileDef, err := scanner.ExtractProfileFromTarball(resp.Body, name)
+	if err != nil {
+		return nil, err
+	}
+	if profileDef == nil {
+		return nil, fmt.Errorf("%s not found in artifact", name)
+	}
+	return profileDef, nil
+}
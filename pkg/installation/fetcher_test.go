@@ -0,0 +1,127 @@
+package installation_test
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+
+	"github.com/weaveworks/profiles/pkg/installation"
+	"github.com/weaveworks/profiles/pkg/installation/fakes"
+)
+
+var _ = Describe("ArtifactFetcher", func() {
+	const header = "apiVersion: weave.works/v1alpha1\nkind: ProfileDefinition\n"
+
+	var (
+		fetcher    *installation.ArtifactFetcher
+		httpClient *fakes.FakeHTTPClient
+	)
+
+	BeforeEach(func() {
+		httpClient = new(fakes.FakeHTTPClient)
+		fetcher = installation.NewFetcher(httpClient)
+	})
+
+	It("returns the profile definition found at the given path", func() {
+		httpClient.DoReturns(&http.Response{
+			StatusCode: http.StatusOK,
+			Body: tarContents(map[string]string{
+				"README.md":                "# profiles",
+				"profile.yaml":             header + "metadata:\n  name: root",
+				"nginx/profile.yaml":       header + "metadata:\n  name: nginx\nspec:\n  description: some desc",
+				"nginx/deploy/config.yaml": "foo: bar",
+			}),
+		}, nil)
+
+		def, err := fetcher.Fetch("tarball.one", "nginx", "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(def.Name).To(Equal("nginx"))
+		Expect(def.Spec.Description).To(Equal("some desc"))
+		Expect(httpClient.DoArgsForCall(0).URL.String()).To(Equal("tarball.one"))
+	})
+
+	It("returns the profile definition from a custom definition file", func() {
+		httpClient.DoReturns(&http.Response{
+			StatusCode: http.StatusOK,
+			Body: tarContents(map[string]string{
+				"nginx/profile.yaml":    header + "metadata:\n  name: nginx",
+				"nginx/definition.yaml": header + "metadata:\n  name: custom-nginx",
+			}),
+		}, nil)
+
+		def, err := fetcher.Fetch("tarball.one", "nginx", "definition.yaml")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(def.Name).To(Equal("custom-nginx"))
+	})
+
+	When("the profile is not in the artifact", func() {
+		It("returns an error", func() {
+			httpClient.DoReturns(&http.Response{
+				StatusCode: http.StatusOK,
+				Body:       tarContents(map[string]string{"profile.yaml": header + "metadata:\n  name: root"}),
+			}, nil)
+
+			_, err := fetcher.Fetch("tarball.one", "nginx", "")
+			Expect(err).To(MatchError("nginx/profile.yaml not found in artifact"))
+		})
+	})
+
+	When("the definition file is not a profile definition", func() {
+		It("returns an error", func() {
+			httpClient.DoReturns(&http.Response{
+				StatusCode: http.StatusOK,
+				Body:       tarContents(map[string]string{"nginx/profile.yaml": "kind: ConfigMap\nmetadata:\n  name: nginx"}),
+			}, nil)
+
+			_, err := fetcher.Fetch("tarball.one", "nginx", "")
+			Expect(err).To(MatchError(`invalid profile.yaml: kind must be "ProfileDefinition", got "ConfigMap"`))
+		})
+	})
+
+	When("the request fails", func() {
+		It("returns an error", func() {
+			httpClient.DoReturns(&http.Response{}, fmt.Errorf("dofail"))
+
+			_, err := fetcher.Fetch("tarball.one", "nginx", "")
+			Expect(err).To(MatchError(`failed to GET "tarball.one": dofail`))
+		})
+	})
+
+	When("request returns non 200", func() {
+		It("returns an error", func() {
+			httpClient.DoReturns(&http.Response{
+				StatusCode: http.StatusNotFound,
+				Body:       gbytes.NewBuffer(),
+			}, nil)
+
+			_, err := fetcher.Fetch("tarball.one", "nginx", "")
+			Expect(err).To(MatchError("request failed status code 404"))
+		})
+	})
+})
+
+func tarContents(files map[string]string) io.ReadCloser {
+	buf := gbytes.NewBuffer()
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+	for name, content := range files {
+		hdr := &tar.Header{
+			Name:     name,
+			Mode:     0600,
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+		}
+		Expect(tw.WriteHeader(hdr)).To(Succeed())
+		_, err := tw.Write([]byte(content))
+		Expect(err).NotTo(HaveOccurred())
+	}
+	Expect(tw.Close()).To(Succeed())
+	Expect(gw.Close()).To(Succeed())
+	return buf
+}
@@ -0,0 +1,137 @@
+package installation
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2beta1"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// Values holds the user supplied helm values of an installation. Values for a chart
+// artifact are read from the key named after the artifact.
+type Values struct {
+	// ConfigMap holds values which are merged over the default values of the chart
+	ConfigMap *corev1.ConfigMap
+	// Secret holds sensitive values which take precedence over all other values. They
+	// are passed to the HelmRelease by reference instead of being copied into it.
+	Secret *corev1.Secret
+}
+
+// ValuesError is returned when the values of an artifact are invalid.
+type ValuesError struct {
+	Artifact string
+	Err      error
+}
+
+func (e *ValuesError) Error() string {
+	return fmt.Sprintf("artifact %q: %s", e.Artifact, e.Err)
+}
+
+func (e *ValuesError) Unwrap() error {
+	return e.Err
+}
+
+// makeValues deep-merges the default values of an artifact with the values of the
+// ConfigMap. Keys set by the Secret are removed from the result, as flux merges the
+// values of the HelmRelease over those it references.
+func (v Values) makeValues(name, defaultValues string) (*apiextensionsv1.JSON, []helmv2.ValuesReference, error) {
+	values, err := parseValues(defaultValues)
+	if err != nil {
+		return nil, nil, &ValuesError{Artifact: name, Err: fmt.Errorf("failed to parse default values: %w", err)}
+	}
+
+	if v.ConfigMap != nil {
+		if data, ok := v.ConfigMap.Data[name]; ok {
+			overrides, err := parseValues(data)
+			if err != nil {
+				return nil, nil, &ValuesError{Artifact: name, Err: fmt.Errorf("failed to parse values from configmap %s: %w", v.ConfigMap.Name, err)}
+			}
+			values = mergeValues(values, overrides)
+		}
+	}
+
+	var valuesFrom []helmv2.ValuesReference
+	if v.Secret != nil {
+		if data, ok := v.Secret.Data[name]; ok {
+			secretValues, err := parseValues(string(data))
+			if err != nil {
+				return nil, nil, &ValuesError{Artifact: name, Err: fmt.Errorf("failed to parse values from secret %s: %w", v.Secret.Name, err)}
+			}
+			pruneValues(values, secretValues)
+			valuesFrom = append(valuesFrom, helmv2.ValuesReference{
+				Kind:      "Secret",
+				Name:      v.Secret.Name,
+				ValuesKey: name,
+			})
+		}
+	}
+
+	if len(values) == 0 {
+		return nil, valuesFrom, nil
+	}
+	raw, err := json.Marshal(values)
+	if err != nil {
+		return nil, nil, &ValuesError{Artifact: name, Err: fmt.Errorf("failed to encode values: %w", err)}
+	}
+	return &apiextensionsv1.JSON{Raw: raw}, valuesFrom, nil
+}
+
+func parseValues(data string) (map[string]interface{}, error) {
+	if data == "" {
+		return nil, nil
+	}
+	raw, err := yaml.ToJSON([]byte(data))
+	if err != nil {
+		return nil, err
+	}
+	var values interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&values); err != nil {
+		return nil, err
+	}
+	if values == nil {
+		return nil, nil
+	}
+	m, ok := values.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("values must be a map, got %T", values)
+	}
+	return m, nil
+}
+
+// mergeValues merges overrides into values. Nested maps are merged, any other value
+// in overrides replaces the value in values.
+func mergeValues(values, overrides map[string]interface{}) map[string]interface{} {
+	if values == nil {
+		values = make(map[string]interface{}, len(overrides))
+	}
+	for k, override := range overrides {
+		overrideMap, isMap := override.(map[string]interface{})
+		if existing, ok := values[k].(map[string]interface{}); ok && isMap {
+			values[k] = mergeValues(existing, overrideMap)
+			continue
+		}
+		values[k] = override
+	}
+	return values
+}
+
+// pruneValues removes every value from values which is set in overrides.
+func pruneValues(values, overrides map[string]interface{}) {
+	for k, override := range overrides {
+		overrideMap, isMap := override.(map[string]interface{})
+		if existing, ok := values[k].(map[string]interface{}); ok && isMap {
+			pruneValues(existing, overrideMap)
+			if len(existing) == 0 {
+				delete(values, k)
+			}
+			continue
+		}
+		delete(values, k)
+	}
+}
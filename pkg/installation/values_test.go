@@ -0,0 +1,114 @@
+package installation_test
+
+import (
+	"errors"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2beta1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	profilesv1 "github.com/weaveworks/profiles/api/v1alpha1"
+	"github.com/weaveworks/profiles/pkg/installation"
+)
+
+var _ = Describe("Values", func() {
+	var (
+		pi        profilesv1.ProfileInstallation
+		artifacts []installation.ProfileArtifact
+		values    installation.Values
+	)
+
+	BeforeEach(func() {
+		pi = profilesv1.ProfileInstallation{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-profile", Namespace: "default"},
+		}
+		artifacts = []installation.ProfileArtifact{
+			{
+				Artifact: profilesv1.Artifact{
+					Name: "nginx",
+					Chart: &profilesv1.Chart{
+						Path:          "nginx/chart",
+						DefaultValues: "replicas: 1\nservice:\n  type: ClusterIP\n  port: 80\nimage:\n  tag: 1.0.0",
+					},
+				},
+				Source: installation.Source{Name: "my-profile", Namespace: "default"},
+			},
+		}
+		values = installation.Values{
+			ConfigMap: &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "my-values"},
+				Data: map[string]string{
+					"nginx": "replicas: 3\nservice:\n  port: 8080\nextra: [a, b]",
+					"other": "not: [valid",
+				},
+			},
+		}
+	})
+
+	release := func(values installation.Values) *helmv2.HelmRelease {
+		objs, err := installation.MakeArtifacts(pi, artifacts, values)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(objs).To(HaveLen(1))
+		return objs[0].(*helmv2.HelmRelease)
+	}
+
+	It("deep merges the configmap values over the default values", func() {
+		r := release(values)
+		Expect(string(r.Spec.Values.Raw)).To(MatchJSON(`{
+			"replicas": 3,
+			"service": {"type": "ClusterIP", "port": 8080},
+			"image": {"tag": "1.0.0"},
+			"extra": ["a", "b"]
+		}`))
+		Expect(r.Spec.ValuesFrom).To(BeEmpty())
+	})
+
+	When("the configmap has no values for the artifact", func() {
+		It("uses the default values", func() {
+			delete(values.ConfigMap.Data, "nginx")
+			r := release(values)
+			Expect(string(r.Spec.Values.Raw)).To(MatchJSON(`{
+				"replicas": 1,
+				"service": {"type": "ClusterIP", "port": 80},
+				"image": {"tag": "1.0.0"}
+			}`))
+		})
+	})
+
+	When("a secret is provided", func() {
+		It("references the secret and removes the values it overrides", func() {
+			values.Secret = &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "my-secret"},
+				Data: map[string][]byte{
+					"nginx": []byte("service:\n  port: 443\nimage: private"),
+				},
+			}
+			r := release(values)
+			Expect(string(r.Spec.Values.Raw)).To(MatchJSON(`{
+				"replicas": 3,
+				"service": {"type": "ClusterIP"},
+				"extra": ["a", "b"]
+			}`))
+			Expect(r.Spec.ValuesFrom).To(Equal([]helmv2.ValuesReference{
+				{Kind: "Secret", Name: "my-secret", ValuesKey: "nginx"},
+			}))
+		})
+	})
+
+	When("the values are invalid", func() {
+		It("returns a ValuesError", func() {
+			values.ConfigMap.Data["nginx"] = "replicas: [3"
+			_, err := installation.MakeArtifacts(pi, artifacts, values)
+			Expect(err).To(MatchError(ContainSubstring(`artifact "nginx": failed to parse values from configmap my-values:`)))
+			var valuesErr *installation.ValuesError
+			Expect(errors.As(err, &valuesErr)).To(BeTrue())
+			Expect(valuesErr.Artifact).To(Equal("nginx"))
+
+			values.ConfigMap.Data["nginx"] = "- a\n- b"
+			_, err = installation.MakeArtifacts(pi, artifacts, values)
+			Expect(err).To(MatchError(`artifact "nginx": failed to parse values from configmap my-values: values must be a map, got []interface {}`))
+		})
+	})
+})
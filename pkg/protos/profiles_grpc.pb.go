@@ -24,8 +24,15 @@ type ProfilesServiceClient interface {
 	GetWithVersion(ctx context.Context, in *GetWithVersionRequest, opts ...grpc.CallOption) (*GetWithVersionResponse, error)
 	// ProfilesGreaterThanVersion returns all profiles which are of a greater version for a given profile with a version.
 	ProfilesGreaterThanVersion(ctx context.Context, in *ProfilesGreaterThanVersionRequest, opts ...grpc.CallOption) (*ProfilesGreaterThanVersionResponse, error)
-	// Search will return a list of profiles which match query
+	// Search will return a list of profiles which match query, ranked by relevance.
+	// Text is matched case-insensitively.
 	Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SearchResponse, error)
+	// ListCatalogSources will return all catalog sources with their profile counts and scan status
+	ListCatalogSources(ctx context.Context, in *ListCatalogSourcesRequest, opts ...grpc.CallOption) (*ListCatalogSourcesResponse, error)
+	// GetCatalogSource will return a specific catalog source with its profile counts and scan status
+	GetCatalogSource(ctx context.Context, in *GetCatalogSourceRequest, opts ...grpc.CallOption) (*GetCatalogSourceResponse, error)
+	// ListVersions will return all versions of a profile in descending order
+	ListVersions(ctx context.Context, in *ListVersionsRequest, opts ...grpc.CallOption) (*ListVersionsResponse, error)
 }
 
 type profilesServiceClient struct {
@@ -72,6 +79,33 @@ func (c *profilesServiceClient) Search(ctx context.Context, in *SearchRequest, o
 	return out, nil
 }
 
+func (c *profilesServiceClient) ListCatalogSources(ctx context.Context, in *ListCatalogSourcesRequest, opts ...grpc.CallOption) (*ListCatalogSourcesResponse, error) {
+	out := new(ListCatalogSourcesResponse)
+	err := c.cc.Invoke(ctx, "/weave.works.profiles.v1.ProfilesService/ListCatalogSources", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *profilesServiceClient) GetCatalogSource(ctx context.Context, in *GetCatalogSourceRequest, opts ...grpc.CallOption) (*GetCatalogSourceResponse, error) {
+	out := new(GetCatalogSourceResponse)
+	err := c.cc.Invoke(ctx, "/weave.works.profiles.v1.ProfilesService/GetCatalogSource", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *profilesServiceClient) ListVersions(ctx context.Context, in *ListVersionsRequest, opts ...grpc.CallOption) (*ListVersionsResponse, error) {
+	out := new(ListVersionsResponse)
+	err := c.cc.Invoke(ctx, "/weave.works.profiles.v1.ProfilesService/ListVersions", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // ProfilesServiceServer is the server API for ProfilesService service.
 // All implementations should embed UnimplementedProfilesServiceServer
 // for forward compatibility
@@ -82,8 +116,15 @@ type ProfilesServiceServer interface {
 	GetWithVersion(context.Context, *GetWithVersionRequest) (*GetWithVersionResponse, error)
 	// ProfilesGreaterThanVersion returns all profiles which are of a greater version for a given profile with a version.
 	ProfilesGreaterThanVersion(context.Context, *ProfilesGreaterThanVersionRequest) (*ProfilesGreaterThanVersionResponse, error)
-	// Search will return a list of profiles which match query
+	// Search will return a list of profiles which match query, ranked by relevance.
+	// Text is matched case-insensitively.
 	Search(context.Context, *SearchRequest) (*SearchResponse, error)
+	// ListCatalogSources will return all catalog sources with their profile counts and scan status
+	ListCatalogSources(context.Context, *ListCatalogSourcesRequest) (*ListCatalogSourcesResponse, error)
+	// GetCatalogSource will return a specific catalog source with its profile counts and scan status
+	GetCatalogSource(context.Context, *GetCatalogSourceRequest) (*GetCatalogSourceResponse, error)
+	// ListVersions will return all versions of a profile in descending order
+	ListVersions(context.Context, *ListVersionsRequest) (*ListVersionsResponse, error)
 }
 
 // UnimplementedProfilesServiceServer should be embedded to have forward compatible implementations.
@@ -102,6 +143,15 @@ func (UnimplementedProfilesServiceServer) ProfilesGreaterThanVersion(context.Con
 func (UnimplementedProfilesServiceServer) Search(context.Context, *SearchRequest) (*SearchResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Search not implemented")
 }
+func (UnimplementedProfilesServiceServer) ListCatalogSources(context.Context, *ListCatalogSourcesRequest) (*ListCatalogSourcesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListCatalogSources not implemented")
+}
+func (UnimplementedProfilesServiceServer) GetCatalogSource(context.Context, *GetCatalogSourceRequest) (*GetCatalogSourceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetCatalogSource not implemented")
+}
+func (UnimplementedProfilesServiceServer) ListVersions(context.Context, *ListVersionsRequest) (*ListVersionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListVersions not implemented")
+}
 
 // UnsafeProfilesServiceServer may be embedded to opt out of forward compatibility for this service.
 // Use of this interface is not recommended, as added methods to ProfilesServiceServer will
@@ -186,6 +236,60 @@ func _ProfilesService_Search_Handler(srv interface{}, ctx context.Context, dec f
 	return interceptor(ctx, in, info, handler)
 }
 
+func _ProfilesService_ListCatalogSources_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListCatalogSourcesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProfilesServiceServer).ListCatalogSources(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/weave.works.profiles.v1.ProfilesService/ListCatalogSources",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProfilesServiceServer).ListCatalogSources(ctx, req.(*ListCatalogSourcesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ProfilesService_GetCatalogSource_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetCatalogSourceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProfilesServiceServer).GetCatalogSource(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/weave.works.profiles.v1.ProfilesService/GetCatalogSource",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProfilesServiceServer).GetCatalogSource(ctx, req.(*GetCatalogSourceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ProfilesService_ListVersions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListVersionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProfilesServiceServer).ListVersions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/weave.works.profiles.v1.ProfilesService/ListVersions",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProfilesServiceServer).ListVersions(ctx, req.(*ListVersionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // ProfilesService_ServiceDesc is the grpc.ServiceDesc for ProfilesService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -209,6 +313,18 @@ var ProfilesService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Search",
 			Handler:    _ProfilesService_Search_Handler,
 		},
+		{
+			MethodName: "ListCatalogSources",
+			Handler:    _ProfilesService_ListCatalogSources_Handler,
+		},
+		{
+			MethodName: "GetCatalogSource",
+			Handler:    _ProfilesService_GetCatalogSource_Handler,
+		},
+		{
+			MethodName: "ListVersions",
+			Handler:    _ProfilesService_ListVersions_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "profiles.proto",
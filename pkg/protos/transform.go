@@ -12,6 +12,7 @@ func TransformCatalogEntry(origin *profilesv1.ProfileCatalogEntry) *ProfileCatal
 		Description:   origin.ProfileDescription.Description,
 		Maintainer:    origin.ProfileDescription.Maintainer,
 		Prerequisites: origin.ProfileDescription.Prerequisites,
+		ArtifactPaths: origin.ArtifactPaths,
 	}
 }
 
@@ -0,0 +1,93 @@
+package scanner
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	profilesv1 "github.com/weaveworks/profiles/api/v1alpha1"
+	"github.com/weaveworks/profiles/pkg/gitrepository"
+	corev1 "k8s.io/api/core/v1"
+)
+
+//counterfeiter:generate -o fakes/fake_file_reader.go . FileReader
+//FileReader for reading files from git repositories. ReadFile returns the contents of the file
+//in a tag, or nil if it does not exist, and the directories below its directory. ReadBranchFile
+//does the same for a commit of a branch.
+type FileReader interface {
+	ReadFile(url, tag, path string, secret *corev1.Secret) ([]byte, []string, error)
+	ReadBranchFile(url, branch, commit, path string, secret *corev1.Secret) ([]byte, []string, error)
+}
+
+//DirectScanner scans repositorys by reading the profile definition of each tag directly from git,
+//without creating Flux GitRepository resources
+type DirectScanner struct {
+	gitClient  GitClient
+	fileReader FileReader
+	logger     logr.Logger
+	options
+}
+
+//NewDirect returns a DirectScanner
+func NewDirect(gitClient GitClient, fileReader FileReader, logger logr.Logger, opts ...Option) RepoScanner {
+	return &DirectScanner{
+		gitClient:  gitClient,
+		fileReader: fileReader,
+		logger:     logger,
+		options:    makeOptions(opts),
+	}
+}
+
+//ScanRepository for profiles
+func (s *DirectScanner) ScanRepository(repo profilesv1.Repository, secret *corev1.Secret, scannedTags map[string]string) ([]profilesv1.ProfileCatalogEntry, map[string]string, error) {
+	var (
+		instances []gitrepository.Instance
+		tags      map[string]string
+		err       error
+	)
+	s.sem.do(func() {
+		instances, tags, err = listInstances(s.gitClient, s.logger, repo, secret, scannedTags)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	scannedProfiles := make([]*scannedProfile, len(instances))
+	errs := forEach(len(instances), s.sem, func(i int) error {
+		profile, err := s.readProfile(repo, instances[i], secret)
+		scannedProfiles[i] = profile
+		return err
+	})
+
+	return makeEntries(repo, instances, scannedProfiles, errs, tags)
+}
+
+func (s *DirectScanner) readProfile(repo profilesv1.Repository, instance gitrepository.Instance, secret *corev1.Secret) (*scannedProfile, error) {
+	var (
+		data []byte
+		dirs []string
+		err  error
+	)
+	if instance.Branch != "" {
+		// the listed commit is read, as the head of the branch may have moved since
+		data, dirs, err = s.fileReader.ReadBranchFile(repo.URL, instance.Branch, instance.Commit, instance.Path, secret)
+	} else {
+		data, dirs, err = s.fileReader.ReadFile(repo.URL, instance.Tag, instance.Path, secret)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %q: %w", instance.Path, err)
+	}
+	if data == nil {
+		return nil, nil
+	}
+
+	profileDef, err := decodeProfile(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	profile := &scannedProfile{definition: profileDef, dirs: make(map[string]bool, len(dirs))}
+	for _, dir := range dirs {
+		profile.dirs[dir] = true
+	}
+	return profile, nil
+}
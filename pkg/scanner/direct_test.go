@@ -0,0 +1,403 @@
+package scanner_test
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/version"
+	"github.com/go-logr/logr"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+
+	profilesv1 "github.com/weaveworks/profiles/api/v1alpha1"
+	"github.com/weaveworks/profiles/pkg/scanner"
+	"github.com/weaveworks/profiles/pkg/scanner/fakes"
+)
+
+var _ = Describe("DirectScanner", func() {
+	const header = "apiVersion: weave.works/v1alpha1\nkind: ProfileDefinition\n"
+
+	var (
+		s          scanner.RepoScanner
+		gitClient  *fakes.FakeGitClient
+		fileReader *fakes.FakeFileReader
+		repoSecret = &corev1.Secret{
+			Data: map[string][]byte{
+				"foo": []byte("bar"),
+			},
+		}
+		repo = profilesv1.Repository{
+			URL: "github.com/example/repo",
+			SecretRef: &meta.LocalObjectReference{
+				Name: "foo",
+			},
+		}
+	)
+
+	BeforeEach(func() {
+		gitClient = new(fakes.FakeGitClient)
+		fileReader = new(fakes.FakeFileReader)
+		s = scanner.NewDirect(gitClient, fileReader, logr.Discard())
+	})
+
+	When("the repo has matching tags", func() {
+		BeforeEach(func() {
+			gitClient.ListTagsReturns(commits("name/v0.0.1", "name/v0.1.0", "v1.0.0", "v2.0.0", "some-notsemver"), nil)
+			fileReader.ReadFileStub = func(url, tag, path string, secret *corev1.Secret) ([]byte, []string, error) {
+				switch tag {
+				case "name/v0.1.0":
+					return []byte(header + "metadata:\n  name: name\nspec:\n  description: some desc\n  maintainer: me\n" +
+						"  artifacts:\n  - name: nginx\n    kustomize:\n      path: nginx/deploy"), []string{"nginx", "nginx/deploy"}, nil
+				case "v1.0.0":
+					return []byte(header + "metadata:\n  name: other-name\nspec:\n  description: other desc"), nil, nil
+				}
+				return nil, nil, nil
+			}
+		})
+
+		It("reads the profile.yaml of each new tag", func() {
+			profiles, tags, err := s.ScanRepository(repo, repoSecret, commits("name/v0.0.1"))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(gitClient.ListTagsCallCount()).To(Equal(1))
+			url, secret := gitClient.ListTagsArgsForCall(0)
+			Expect(url).To(Equal("github.com/example/repo"))
+			Expect(secret).To(Equal(repoSecret))
+
+			Expect(fileReader.ReadFileCallCount()).To(Equal(3))
+			paths := map[string]string{}
+			for i := 0; i < fileReader.ReadFileCallCount(); i++ {
+				url, tag, path, secret := fileReader.ReadFileArgsForCall(i)
+				Expect(url).To(Equal("github.com/example/repo"))
+				Expect(secret).To(Equal(repoSecret))
+				paths[tag] = path
+			}
+			Expect(paths).To(Equal(map[string]string{
+				"name/v0.1.0": "name/profile.yaml",
+				"v1.0.0":      "profile.yaml",
+				"v2.0.0":      "profile.yaml",
+			}))
+
+			Expect(profiles).To(ConsistOf(profilesv1.ProfileCatalogEntry{
+				ProfileDescription: profilesv1.ProfileDescription{
+					Description: "some desc",
+					Maintainer:  "me",
+				},
+				Name:          "name",
+				Tag:           "name/v0.1.0",
+				URL:           "github.com/example/repo",
+				ArtifactPaths: []string{"nginx/deploy"},
+			}, profilesv1.ProfileCatalogEntry{
+				ProfileDescription: profilesv1.ProfileDescription{
+					Description: "other desc",
+				},
+				Name: "other-name",
+				Tag:  "v1.0.0",
+				URL:  "github.com/example/repo",
+			}))
+			Expect(tags).To(Equal(commits("name/v0.0.1", "name/v0.1.0", "v1.0.0", "v2.0.0", "some-notsemver")))
+		})
+	})
+
+	When("tags were moved or deleted since they were scanned", func() {
+		BeforeEach(func() {
+			gitClient.ListTagsReturns(map[string]string{"v0.1.0": "sha-v0.1.0", "v0.2.0": "moved", "v0.3.0": "sha-v0.3.0"}, nil)
+			fileReader.ReadFileReturns([]byte(header+"metadata:\n  name: name"), nil, nil)
+		})
+
+		It("scans the moved tags again and drops the deleted tags", func() {
+			profiles, tags, err := s.ScanRepository(repo, repoSecret, map[string]string{
+				"v0.1.0": "sha-v0.1.0",
+				"v0.2.0": "sha-v0.2.0",
+				"v0.3.0": "",
+				"v0.4.0": "sha-v0.4.0",
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(fileReader.ReadFileCallCount()).To(Equal(1))
+			_, tag, _, _ := fileReader.ReadFileArgsForCall(0)
+			Expect(tag).To(Equal("v0.2.0"))
+			Expect(profiles).To(HaveLen(1))
+			Expect(tags).To(Equal(map[string]string{"v0.1.0": "sha-v0.1.0", "v0.2.0": "moved", "v0.3.0": "sha-v0.3.0"}))
+		})
+	})
+
+	When("the repository has a tag pattern and profile filename", func() {
+		BeforeEach(func() {
+			gitClient.ListTagsReturns(commits("profiles/observability/v1.2.0", "logging-v0.1.0", "v1.0.0"), nil)
+			fileReader.ReadFileReturns([]byte(header+"metadata:\n  name: name"), nil, nil)
+		})
+
+		It("reads the profile definitions of the tags matching a regex", func() {
+			repo := profilesv1.Repository{
+				URL:             "github.com/example/repo",
+				TagPattern:      `^(?P<path>profiles/[^/]+)/v(?P<version>.+)$`,
+				ProfileFilename: "profile-definition.yaml",
+			}
+			profiles, tags, err := s.ScanRepository(repo, repoSecret, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(fileReader.ReadFileCallCount()).To(Equal(1))
+			_, tag, path, _ := fileReader.ReadFileArgsForCall(0)
+			Expect(tag).To(Equal("profiles/observability/v1.2.0"))
+			Expect(path).To(Equal("profiles/observability/profile-definition.yaml"))
+			Expect(profiles).To(ConsistOf(profilesv1.ProfileCatalogEntry{
+				Name:            "name",
+				Tag:             "profiles/observability/v1.2.0",
+				URL:             "github.com/example/repo",
+				TagPattern:      `^(?P<path>profiles/[^/]+)/v(?P<version>.+)$`,
+				ProfileFilename: "profile-definition.yaml",
+			}))
+			Expect(tags).To(Equal(commits("profiles/observability/v1.2.0", "logging-v0.1.0", "v1.0.0")))
+		})
+
+		It("reads the profile definitions of the tags matching a template", func() {
+			repo := profilesv1.Repository{
+				URL:        "github.com/example/repo",
+				TagPattern: "{path}-v{version}",
+			}
+			_, _, err := s.ScanRepository(repo, repoSecret, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(fileReader.ReadFileCallCount()).To(Equal(1))
+			_, tag, path, _ := fileReader.ReadFileArgsForCall(0)
+			Expect(tag).To(Equal("logging-v0.1.0"))
+			Expect(path).To(Equal("logging/profile.yaml"))
+		})
+
+		It("returns an error when the pattern is invalid", func() {
+			repo := profilesv1.Repository{
+				URL:        "github.com/example/repo",
+				TagPattern: `^(?P<path>.+)/(?P<semver>.+)$`,
+			}
+			_, _, err := s.ScanRepository(repo, repoSecret, nil)
+			Expect(err).To(MatchError(`invalid tag pattern "^(?P<path>.+)/(?P<semver>.+)$": missing "version" capture`))
+			Expect(gitClient.ListTagsCallCount()).To(Equal(0))
+		})
+	})
+
+	When("the repository filters its tags", func() {
+		BeforeEach(func() {
+			gitClient.ListTagsReturns(commits("v0.9.0", "v1.0.0", "v1.1.0-rc.1", "v2.0.0", "v3.0.0", "nginx/v1.0.0", "experimental/v1.0.0"), nil)
+			fileReader.ReadFileReturns([]byte(header+"metadata:\n  name: name"), nil, nil)
+		})
+
+		It("only reads the tags passing the filters", func() {
+			repo := profilesv1.Repository{
+				URL:              "github.com/example/repo",
+				IncludeTags:      []string{"v*", "*/v*"},
+				ExcludeTags:      []string{"experimental/*", "v2.*"},
+				SemverConstraint: ">=1.0.0 <3.0.0",
+			}
+			_, tags, err := s.ScanRepository(repo, repoSecret, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			var read []string
+			for i := 0; i < fileReader.ReadFileCallCount(); i++ {
+				_, tag, _, _ := fileReader.ReadFileArgsForCall(i)
+				read = append(read, tag)
+			}
+			Expect(read).To(ConsistOf("v1.0.0", "nginx/v1.0.0"))
+			Expect(tags).To(Equal(commits("v1.0.0", "nginx/v1.0.0")))
+		})
+
+		It("returns an error when the constraint is invalid", func() {
+			repo := profilesv1.Repository{
+				URL:              "github.com/example/repo",
+				SemverConstraint: "not-a-constraint",
+			}
+			_, _, err := s.ScanRepository(repo, repoSecret, nil)
+			Expect(err).To(MatchError(ContainSubstring(`invalid semver constraint "not-a-constraint"`)))
+		})
+
+		It("returns an error when a glob is invalid", func() {
+			repo := profilesv1.Repository{
+				URL:         "github.com/example/repo",
+				ExcludeTags: []string{"["},
+			}
+			_, _, err := s.ScanRepository(repo, repoSecret, nil)
+			Expect(err).To(MatchError(`invalid tag glob "[": syntax error in pattern`))
+		})
+	})
+
+	When("the repository tracks branches", func() {
+		var branchRepo profilesv1.Repository
+
+		BeforeEach(func() {
+			branchRepo = profilesv1.Repository{
+				URL: "github.com/example/repo",
+				Branches: []profilesv1.TrackedBranch{
+					{Name: "main"},
+					{Name: "feature/foo", Path: "profiles/foo"},
+					{Name: "gone"},
+				},
+			}
+			gitClient.ListTagsReturns(commits("v1.0.0"), nil)
+			gitClient.ListBranchesReturns(map[string]string{
+				"main":        "0123456789abcdef0123456789abcdef01234567",
+				"feature/foo": "fedcba9876543210fedcba9876543210fedcba98",
+			}, nil)
+			fileReader.ReadFileReturns([]byte(header+"metadata:\n  name: name"), nil, nil)
+			fileReader.ReadBranchFileReturns([]byte(header+"metadata:\n  name: dev"), nil, nil)
+		})
+
+		It("reads the profile definition at the listed head of each branch", func() {
+			profiles, tags, err := s.ScanRepository(branchRepo, repoSecret, commits("v1.0.0"))
+			Expect(err).NotTo(HaveOccurred())
+
+			url, secret := gitClient.ListBranchesArgsForCall(0)
+			Expect(url).To(Equal("github.com/example/repo"))
+			Expect(secret).To(Equal(repoSecret))
+
+			Expect(fileReader.ReadFileCallCount()).To(Equal(0))
+			Expect(fileReader.ReadBranchFileCallCount()).To(Equal(2))
+			paths, heads := map[string]string{}, map[string]string{}
+			for i := 0; i < fileReader.ReadBranchFileCallCount(); i++ {
+				_, branch, commit, path, _ := fileReader.ReadBranchFileArgsForCall(i)
+				paths[branch], heads[branch] = path, commit
+			}
+			Expect(paths).To(Equal(map[string]string{
+				"main":        "profile.yaml",
+				"feature/foo": "profiles/foo/profile.yaml",
+			}))
+			Expect(heads).To(Equal(map[string]string{
+				"main":        "0123456789abcdef0123456789abcdef01234567",
+				"feature/foo": "fedcba9876543210fedcba9876543210fedcba98",
+			}))
+
+			Expect(profiles).To(ConsistOf(profilesv1.ProfileCatalogEntry{
+				Name:       "dev",
+				Tag:        "0.0.0-main.g0123456",
+				URL:        "github.com/example/repo",
+				TagPattern: profilesv1.BranchTagPattern,
+				Branch:     "main",
+				Commit:     "0123456789abcdef0123456789abcdef01234567",
+			}, profilesv1.ProfileCatalogEntry{
+				Name:       "dev",
+				Tag:        "profiles/foo/0.0.0-feature-foo.fedcba9",
+				URL:        "github.com/example/repo",
+				TagPattern: profilesv1.BranchTagPattern,
+				Branch:     "feature/foo",
+				Commit:     "fedcba9876543210fedcba9876543210fedcba98",
+			}))
+			Expect(tags).To(Equal(map[string]string{
+				"v1.0.0":                                 "sha-v1.0.0",
+				"0.0.0-main.g0123456":                    "0123456789abcdef0123456789abcdef01234567",
+				"profiles/foo/0.0.0-feature-foo.fedcba9": "fedcba9876543210fedcba9876543210fedcba98",
+			}))
+		})
+
+		It("does not read the branches whose head was already scanned", func() {
+			_, tags, err := s.ScanRepository(branchRepo, repoSecret, commits("v1.0.0", "0.0.0-main.g0123456"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fileReader.ReadBranchFileCallCount()).To(Equal(1))
+			_, branch, _, _, _ := fileReader.ReadBranchFileArgsForCall(0)
+			Expect(branch).To(Equal("feature/foo"))
+			Expect(tags).To(HaveKey("0.0.0-main.g0123456"))
+			Expect(tags).To(HaveKey("profiles/foo/0.0.0-feature-foo.fedcba9"))
+		})
+
+		It("uses a valid pre-release identifier for the version of each branch", func() {
+			branchRepo.Branches = []profilesv1.TrackedBranch{
+				{Name: "Feature_Foo"},
+				{Name: "0123"},
+				{Name: "feature/" + strings.Repeat("x", 40)},
+			}
+			gitClient.ListBranchesReturns(map[string]string{
+				"Feature_Foo":                        "0123456789abcdef0123456789abcdef01234567",
+				"0123":                               "0123456789abcdef0123456789abcdef01234567",
+				"feature/" + strings.Repeat("x", 40): "0123456789abcdef0123456789abcdef01234567",
+			}, nil)
+
+			_, tags, err := s.ScanRepository(branchRepo, repoSecret, commits("v1.0.0"))
+			Expect(err).NotTo(HaveOccurred())
+			delete(tags, "v1.0.0")
+			Expect(tags).To(HaveLen(3))
+			Expect(tags).To(HaveKey("0.0.0-feature-foo.g0123456"))
+			Expect(tags).To(HaveKey("0.0.0-branch-0123.g0123456"))
+			for tag := range tags {
+				_, err := version.ParseVersion(tag)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(len(tag)).To(BeNumerically("<=", len("0.0.0-.g0123456")+32))
+			}
+		})
+
+		It("returns an error when the branches cannot be listed", func() {
+			gitClient.ListBranchesReturns(nil, fmt.Errorf("listfail"))
+			_, _, err := s.ScanRepository(branchRepo, repoSecret, nil)
+			Expect(err).To(MatchError("failed to list branches: listfail"))
+		})
+	})
+
+	When("ListTags fails", func() {
+		BeforeEach(func() {
+			gitClient.ListTagsReturns(nil, fmt.Errorf("listfail"))
+		})
+
+		It("returns an error", func() {
+			_, _, err := s.ScanRepository(repo, repoSecret, nil)
+			Expect(err).To(MatchError("failed to list tags: listfail"))
+		})
+	})
+
+	When("scanning with a concurrency limit", func() {
+		It("never reads more tags in parallel than the limit", func() {
+			s = scanner.NewDirect(gitClient, fileReader, logr.Discard(), scanner.WithConcurrency(2))
+			gitClient.ListTagsReturns(commits("v0.1.0", "v0.2.0", "v0.3.0", "v0.4.0", "v0.5.0"), nil)
+
+			var running, maxRunning int32
+			fileReader.ReadFileStub = func(url, tag, path string, secret *corev1.Secret) ([]byte, []string, error) {
+				n := atomic.AddInt32(&running, 1)
+				defer atomic.AddInt32(&running, -1)
+				for {
+					max := atomic.LoadInt32(&maxRunning)
+					if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+						break
+					}
+				}
+				time.Sleep(10 * time.Millisecond)
+				return []byte(header + "metadata:\n  name: name"), nil, nil
+			}
+
+			profiles, _, err := s.ScanRepository(repo, repoSecret, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fileReader.ReadFileCallCount()).To(Equal(5))
+			Expect(atomic.LoadInt32(&maxRunning)).To(Equal(int32(2)))
+
+			By("returning the profiles in the order of the tags")
+			var tags []string
+			for _, p := range profiles {
+				tags = append(tags, p.Tag)
+			}
+			Expect(tags).To(Equal([]string{"v0.1.0", "v0.2.0", "v0.3.0", "v0.4.0", "v0.5.0"}))
+		})
+	})
+
+	When("ReadFile fails", func() {
+		BeforeEach(func() {
+			gitClient.ListTagsReturns(commits("v0.1.0"), nil)
+			fileReader.ReadFileReturns(nil, nil, fmt.Errorf("readfail"))
+		})
+
+		It("returns an error", func() {
+			_, _, err := s.ScanRepository(repo, repoSecret, nil)
+			Expect(err).To(MatchError(`failed to scan 1 tags: tag "v0.1.0": failed to read "profile.yaml": readfail`))
+		})
+	})
+
+	When("the file isn't valid yaml", func() {
+		BeforeEach(func() {
+			gitClient.ListTagsReturns(commits("v0.1.0"), nil)
+			fileReader.ReadFileReturns([]byte(`!@\:1\23notyaml`), nil, nil)
+		})
+
+		It("returns an error", func() {
+			_, _, err := s.ScanRepository(repo, repoSecret, nil)
+			Expect(err).To(MatchError(ContainSubstring("failed to decode profile.yaml:")))
+		})
+	})
+})
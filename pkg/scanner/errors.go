@@ -0,0 +1,55 @@
+package scanner
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+//TagError is the error of scanning a single tag
+type TagError struct {
+	Tag string
+	Err error
+}
+
+func (e TagError) Error() string {
+	return fmt.Sprintf("tag %q: %s", e.Tag, e.Err)
+}
+
+func (e TagError) Unwrap() error {
+	return e.Err
+}
+
+//Permanent reports whether scanning the tag again fails the same way until the tag is moved
+func (e TagError) Permanent() bool {
+	var invalid *InvalidProfileError
+	return errors.As(e.Err, &invalid)
+}
+
+//InvalidProfileError is returned when the profile definition of a tag is invalid
+type InvalidProfileError struct {
+	Err error
+}
+
+func (e *InvalidProfileError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *InvalidProfileError) Unwrap() error {
+	return e.Err
+}
+
+//PartialScanError is returned when some tags of a repository could not be scanned. The
+//profiles and tags returned with it are those of the tags which were scanned successfully,
+//and the tags which failed permanently.
+type PartialScanError struct {
+	TagErrors []TagError
+}
+
+func (e *PartialScanError) Error() string {
+	messages := make([]string, len(e.TagErrors))
+	for i, tagErr := range e.TagErrors {
+		messages[i] = tagErr.Error()
+	}
+	return fmt.Sprintf("failed to scan %d tags: %s", len(e.TagErrors), strings.Join(messages, "; "))
+}
@@ -0,0 +1,220 @@
+// Code generated by counterfeiter. DO NOT EDIT.
+package fakes
+
+import (
+	"sync"
+
+	"github.com/weaveworks/profiles/pkg/scanner"
+	v1 "k8s.io/api/core/v1"
+)
+
+type FakeFileReader struct {
+	ReadBranchFileStub        func(string, string, string, string, *v1.Secret) ([]byte, []string, error)
+	readBranchFileMutex       sync.RWMutex
+	readBranchFileArgsForCall []struct {
+		arg1 string
+		arg2 string
+		arg3 string
+		arg4 string
+		arg5 *v1.Secret
+	}
+	readBranchFileReturns struct {
+		result1 []byte
+		result2 []string
+		result3 error
+	}
+	readBranchFileReturnsOnCall map[int]struct {
+		result1 []byte
+		result2 []string
+		result3 error
+	}
+	ReadFileStub        func(string, string, string, *v1.Secret) ([]byte, []string, error)
+	readFileMutex       sync.RWMutex
+	readFileArgsForCall []struct {
+		arg1 string
+		arg2 string
+		arg3 string
+		arg4 *v1.Secret
+	}
+	readFileReturns struct {
+		result1 []byte
+		result2 []string
+		result3 error
+	}
+	readFileReturnsOnCall map[int]struct {
+		result1 []byte
+		result2 []string
+		result3 error
+	}
+	invocations      map[string][][]interface{}
+	invocationsMutex sync.RWMutex
+}
+
+func (fake *FakeFileReader) ReadBranchFile(arg1 string, arg2 string, arg3 string, arg4 string, arg5 *v1.Secret) ([]byte, []string, error) {
+	fake.readBranchFileMutex.Lock()
+	ret, specificReturn := fake.readBranchFileReturnsOnCall[len(fake.readBranchFileArgsForCall)]
+	fake.readBranchFileArgsForCall = append(fake.readBranchFileArgsForCall, struct {
+		arg1 string
+		arg2 string
+		arg3 string
+		arg4 string
+		arg5 *v1.Secret
+	}{arg1, arg2, arg3, arg4, arg5})
+	stub := fake.ReadBranchFileStub
+	fakeReturns := fake.readBranchFileReturns
+	fake.recordInvocation("ReadBranchFile", []interface{}{arg1, arg2, arg3, arg4, arg5})
+	fake.readBranchFileMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3, arg4, arg5)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2, ret.result3
+	}
+	return fakeReturns.result1, fakeReturns.result2, fakeReturns.result3
+}
+
+func (fake *FakeFileReader) ReadBranchFileCallCount() int {
+	fake.readBranchFileMutex.RLock()
+	defer fake.readBranchFileMutex.RUnlock()
+	return len(fake.readBranchFileArgsForCall)
+}
+
+func (fake *FakeFileReader) ReadBranchFileCalls(stub func(string, string, string, string, *v1.Secret) ([]byte, []string, error)) {
+	fake.readBranchFileMutex.Lock()
+	defer fake.readBranchFileMutex.Unlock()
+	fake.ReadBranchFileStub = stub
+}
+
+func (fake *FakeFileReader) ReadBranchFileArgsForCall(i int) (string, string, string, string, *v1.Secret) {
+	fake.readBranchFileMutex.RLock()
+	defer fake.readBranchFileMutex.RUnlock()
+	argsForCall := fake.readBranchFileArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3, argsForCall.arg4, argsForCall.arg5
+}
+
+func (fake *FakeFileReader) ReadBranchFileReturns(result1 []byte, result2 []string, result3 error) {
+	fake.readBranchFileMutex.Lock()
+	defer fake.readBranchFileMutex.Unlock()
+	fake.ReadBranchFileStub = nil
+	fake.readBranchFileReturns = struct {
+		result1 []byte
+		result2 []string
+		result3 error
+	}{result1, result2, result3}
+}
+
+func (fake *FakeFileReader) ReadBranchFileReturnsOnCall(i int, result1 []byte, result2 []string, result3 error) {
+	fake.readBranchFileMutex.Lock()
+	defer fake.readBranchFileMutex.Unlock()
+	fake.ReadBranchFileStub = nil
+	if fake.readBranchFileReturnsOnCall == nil {
+		fake.readBranchFileReturnsOnCall = make(map[int]struct {
+			result1 []byte
+			result2 []string
+			result3 error
+		})
+	}
+	fake.readBranchFileReturnsOnCall[i] = struct {
+		result1 []byte
+		result2 []string
+		result3 error
+	}{result1, result2, result3}
+}
+
+func (fake *FakeFileReader) ReadFile(arg1 string, arg2 string, arg3 string, arg4 *v1.Secret) ([]byte, []string, error) {
+	fake.readFileMutex.Lock()
+	ret, specificReturn := fake.readFileReturnsOnCall[len(fake.readFileArgsForCall)]
+	fake.readFileArgsForCall = append(fake.readFileArgsForCall, struct {
+		arg1 string
+		arg2 string
+		arg3 string
+		arg4 *v1.Secret
+	}{arg1, arg2, arg3, arg4})
+	stub := fake.ReadFileStub
+	fakeReturns := fake.readFileReturns
+	fake.recordInvocation("ReadFile", []interface{}{arg1, arg2, arg3, arg4})
+	fake.readFileMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3, arg4)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2, ret.result3
+	}
+	return fakeReturns.result1, fakeReturns.result2, fakeReturns.result3
+}
+
+func (fake *FakeFileReader) ReadFileCallCount() int {
+	fake.readFileMutex.RLock()
+	defer fake.readFileMutex.RUnlock()
+	return len(fake.readFileArgsForCall)
+}
+
+func (fake *FakeFileReader) ReadFileCalls(stub func(string, string, string, *v1.Secret) ([]byte, []string, error)) {
+	fake.readFileMutex.Lock()
+	defer fake.readFileMutex.Unlock()
+	fake.ReadFileStub = stub
+}
+
+func (fake *FakeFileReader) ReadFileArgsForCall(i int) (string, string, string, *v1.Secret) {
+	fake.readFileMutex.RLock()
+	defer fake.readFileMutex.RUnlock()
+	argsForCall := fake.readFileArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3, argsForCall.arg4
+}
+
+func (fake *FakeFileReader) ReadFileReturns(result1 []byte, result2 []string, result3 error) {
+	fake.readFileMutex.Lock()
+	defer fake.readFileMutex.Unlock()
+	fake.ReadFileStub = nil
+	fake.readFileReturns = struct {
+		result1 []byte
+		result2 []string
+		result3 error
+	}{result1, result2, result3}
+}
+
+func (fake *FakeFileReader) ReadFileReturnsOnCall(i int, result1 []byte, result2 []string, result3 error) {
+	fake.readFileMutex.Lock()
+	defer fake.readFileMutex.Unlock()
+	fake.ReadFileStub = nil
+	if fake.readFileReturnsOnCall == nil {
+		fake.readFileReturnsOnCall = make(map[int]struct {
+			result1 []byte
+			result2 []string
+			result3 error
+		})
+	}
+	fake.readFileReturnsOnCall[i] = struct {
+		result1 []byte
+		result2 []string
+		result3 error
+	}{result1, result2, result3}
+}
+
+func (fake *FakeFileReader) Invocations() map[string][][]interface{} {
+	fake.invocationsMutex.RLock()
+	defer fake.invocationsMutex.RUnlock()
+	fake.readBranchFileMutex.RLock()
+	defer fake.readBranchFileMutex.RUnlock()
+	fake.readFileMutex.RLock()
+	defer fake.readFileMutex.RUnlock()
+	copiedInvocations := map[string][][]interface{}{}
+	for key, value := range fake.invocations {
+		copiedInvocations[key] = value
+	}
+	return copiedInvocations
+}
+
+func (fake *FakeFileReader) recordInvocation(key string, args []interface{}) {
+	fake.invocationsMutex.Lock()
+	defer fake.invocationsMutex.Unlock()
+	if fake.invocations == nil {
+		fake.invocations = map[string][][]interface{}{}
+	}
+	if fake.invocations[key] == nil {
+		fake.invocations[key] = [][]interface{}{}
+	}
+	fake.invocations[key] = append(fake.invocations[key], args)
+}
+
+var _ scanner.FileReader = new(FakeFileReader)
@@ -9,25 +9,104 @@ import (
 )
 
 type FakeGitClient struct {
-	ListTagsStub        func(string, *v1.Secret) ([]string, error)
+	ListBranchesStub        func(string, *v1.Secret) (map[string]string, error)
+	listBranchesMutex       sync.RWMutex
+	listBranchesArgsForCall []struct {
+		arg1 string
+		arg2 *v1.Secret
+	}
+	listBranchesReturns struct {
+		result1 map[string]string
+		result2 error
+	}
+	listBranchesReturnsOnCall map[int]struct {
+		result1 map[string]string
+		result2 error
+	}
+	ListTagsStub        func(string, *v1.Secret) (map[string]string, error)
 	listTagsMutex       sync.RWMutex
 	listTagsArgsForCall []struct {
 		arg1 string
 		arg2 *v1.Secret
 	}
 	listTagsReturns struct {
-		result1 []string
+		result1 map[string]string
 		result2 error
 	}
 	listTagsReturnsOnCall map[int]struct {
-		result1 []string
+		result1 map[string]string
 		result2 error
 	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
 
-func (fake *FakeGitClient) ListTags(arg1 string, arg2 *v1.Secret) ([]string, error) {
+func (fake *FakeGitClient) ListBranches(arg1 string, arg2 *v1.Secret) (map[string]string, error) {
+	fake.listBranchesMutex.Lock()
+	ret, specificReturn := fake.listBranchesReturnsOnCall[len(fake.listBranchesArgsForCall)]
+	fake.listBranchesArgsForCall = append(fake.listBranchesArgsForCall, struct {
+		arg1 string
+		arg2 *v1.Secret
+	}{arg1, arg2})
+	stub := fake.ListBranchesStub
+	fakeReturns := fake.listBranchesReturns
+	fake.recordInvocation("ListBranches", []interface{}{arg1, arg2})
+	fake.listBranchesMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeGitClient) ListBranchesCallCount() int {
+	fake.listBranchesMutex.RLock()
+	defer fake.listBranchesMutex.RUnlock()
+	return len(fake.listBranchesArgsForCall)
+}
+
+func (fake *FakeGitClient) ListBranchesCalls(stub func(string, *v1.Secret) (map[string]string, error)) {
+	fake.listBranchesMutex.Lock()
+	defer fake.listBranchesMutex.Unlock()
+	fake.ListBranchesStub = stub
+}
+
+func (fake *FakeGitClient) ListBranchesArgsForCall(i int) (string, *v1.Secret) {
+	fake.listBranchesMutex.RLock()
+	defer fake.listBranchesMutex.RUnlock()
+	argsForCall := fake.listBranchesArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeGitClient) ListBranchesReturns(result1 map[string]string, result2 error) {
+	fake.listBranchesMutex.Lock()
+	defer fake.listBranchesMutex.Unlock()
+	fake.ListBranchesStub = nil
+	fake.listBranchesReturns = struct {
+		result1 map[string]string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeGitClient) ListBranchesReturnsOnCall(i int, result1 map[string]string, result2 error) {
+	fake.listBranchesMutex.Lock()
+	defer fake.listBranchesMutex.Unlock()
+	fake.ListBranchesStub = nil
+	if fake.listBranchesReturnsOnCall == nil {
+		fake.listBranchesReturnsOnCall = make(map[int]struct {
+			result1 map[string]string
+			result2 error
+		})
+	}
+	fake.listBranchesReturnsOnCall[i] = struct {
+		result1 map[string]string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeGitClient) ListTags(arg1 string, arg2 *v1.Secret) (map[string]string, error) {
 	fake.listTagsMutex.Lock()
 	ret, specificReturn := fake.listTagsReturnsOnCall[len(fake.listTagsArgsForCall)]
 	fake.listTagsArgsForCall = append(fake.listTagsArgsForCall, struct {
@@ -53,7 +132,7 @@ func (fake *FakeGitClient) ListTagsCallCount() int {
 	return len(fake.listTagsArgsForCall)
 }
 
-func (fake *FakeGitClient) ListTagsCalls(stub func(string, *v1.Secret) ([]string, error)) {
+func (fake *FakeGitClient) ListTagsCalls(stub func(string, *v1.Secret) (map[string]string, error)) {
 	fake.listTagsMutex.Lock()
 	defer fake.listTagsMutex.Unlock()
 	fake.ListTagsStub = stub
@@ -66,28 +145,28 @@ func (fake *FakeGitClient) ListTagsArgsForCall(i int) (string, *v1.Secret) {
 	return argsForCall.arg1, argsForCall.arg2
 }
 
-func (fake *FakeGitClient) ListTagsReturns(result1 []string, result2 error) {
+func (fake *FakeGitClient) ListTagsReturns(result1 map[string]string, result2 error) {
 	fake.listTagsMutex.Lock()
 	defer fake.listTagsMutex.Unlock()
 	fake.ListTagsStub = nil
 	fake.listTagsReturns = struct {
-		result1 []string
+		result1 map[string]string
 		result2 error
 	}{result1, result2}
 }
 
-func (fake *FakeGitClient) ListTagsReturnsOnCall(i int, result1 []string, result2 error) {
+func (fake *FakeGitClient) ListTagsReturnsOnCall(i int, result1 map[string]string, result2 error) {
 	fake.listTagsMutex.Lock()
 	defer fake.listTagsMutex.Unlock()
 	fake.ListTagsStub = nil
 	if fake.listTagsReturnsOnCall == nil {
 		fake.listTagsReturnsOnCall = make(map[int]struct {
-			result1 []string
+			result1 map[string]string
 			result2 error
 		})
 	}
 	fake.listTagsReturnsOnCall[i] = struct {
-		result1 []string
+		result1 map[string]string
 		result2 error
 	}{result1, result2}
 }
@@ -95,6 +174,8 @@ func (fake *FakeGitClient) ListTagsReturnsOnCall(i int, result1 []string, result
 func (fake *FakeGitClient) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
+	fake.listBranchesMutex.RLock()
+	defer fake.listBranchesMutex.RUnlock()
 	fake.listTagsMutex.RLock()
 	defer fake.listTagsMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
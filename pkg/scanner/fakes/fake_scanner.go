@@ -10,43 +10,38 @@ import (
 )
 
 type FakeRepoScanner struct {
-	ScanRepositoryStub        func(v1alpha1.Repository, *v1.Secret, []string) ([]v1alpha1.ProfileCatalogEntry, []string, error)
+	ScanRepositoryStub        func(v1alpha1.Repository, *v1.Secret, map[string]string) ([]v1alpha1.ProfileCatalogEntry, map[string]string, error)
 	scanRepositoryMutex       sync.RWMutex
 	scanRepositoryArgsForCall []struct {
 		arg1 v1alpha1.Repository
 		arg2 *v1.Secret
-		arg3 []string
+		arg3 map[string]string
 	}
 	scanRepositoryReturns struct {
 		result1 []v1alpha1.ProfileCatalogEntry
-		result2 []string
+		result2 map[string]string
 		result3 error
 	}
 	scanRepositoryReturnsOnCall map[int]struct {
 		result1 []v1alpha1.ProfileCatalogEntry
-		result2 []string
+		result2 map[string]string
 		result3 error
 	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
 
-func (fake *FakeRepoScanner) ScanRepository(arg1 v1alpha1.Repository, arg2 *v1.Secret, arg3 []string) ([]v1alpha1.ProfileCatalogEntry, []string, error) {
-	var arg3Copy []string
-	if arg3 != nil {
-		arg3Copy = make([]string, len(arg3))
-		copy(arg3Copy, arg3)
-	}
+func (fake *FakeRepoScanner) ScanRepository(arg1 v1alpha1.Repository, arg2 *v1.Secret, arg3 map[string]string) ([]v1alpha1.ProfileCatalogEntry, map[string]string, error) {
 	fake.scanRepositoryMutex.Lock()
 	ret, specificReturn := fake.scanRepositoryReturnsOnCall[len(fake.scanRepositoryArgsForCall)]
 	fake.scanRepositoryArgsForCall = append(fake.scanRepositoryArgsForCall, struct {
 		arg1 v1alpha1.Repository
 		arg2 *v1.Secret
-		arg3 []string
-	}{arg1, arg2, arg3Copy})
+		arg3 map[string]string
+	}{arg1, arg2, arg3})
 	stub := fake.ScanRepositoryStub
 	fakeReturns := fake.scanRepositoryReturns
-	fake.recordInvocation("ScanRepository", []interface{}{arg1, arg2, arg3Copy})
+	fake.recordInvocation("ScanRepository", []interface{}{arg1, arg2, arg3})
 	fake.scanRepositoryMutex.Unlock()
 	if stub != nil {
 		return stub(arg1, arg2, arg3)
@@ -63,44 +58,44 @@ func (fake *FakeRepoScanner) ScanRepositoryCallCount() int {
 	return len(fake.scanRepositoryArgsForCall)
 }
 
-func (fake *FakeRepoScanner) ScanRepositoryCalls(stub func(v1alpha1.Repository, *v1.Secret, []string) ([]v1alpha1.ProfileCatalogEntry, []string, error)) {
+func (fake *FakeRepoScanner) ScanRepositoryCalls(stub func(v1alpha1.Repository, *v1.Secret, map[string]string) ([]v1alpha1.ProfileCatalogEntry, map[string]string, error)) {
 	fake.scanRepositoryMutex.Lock()
 	defer fake.scanRepositoryMutex.Unlock()
 	fake.ScanRepositoryStub = stub
 }
 
-func (fake *FakeRepoScanner) ScanRepositoryArgsForCall(i int) (v1alpha1.Repository, *v1.Secret, []string) {
+func (fake *FakeRepoScanner) ScanRepositoryArgsForCall(i int) (v1alpha1.Repository, *v1.Secret, map[string]string) {
 	fake.scanRepositoryMutex.RLock()
 	defer fake.scanRepositoryMutex.RUnlock()
 	argsForCall := fake.scanRepositoryArgsForCall[i]
 	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
 }
 
-func (fake *FakeRepoScanner) ScanRepositoryReturns(result1 []v1alpha1.ProfileCatalogEntry, result2 []string, result3 error) {
+func (fake *FakeRepoScanner) ScanRepositoryReturns(result1 []v1alpha1.ProfileCatalogEntry, result2 map[string]string, result3 error) {
 	fake.scanRepositoryMutex.Lock()
 	defer fake.scanRepositoryMutex.Unlock()
 	fake.ScanRepositoryStub = nil
 	fake.scanRepositoryReturns = struct {
 		result1 []v1alpha1.ProfileCatalogEntry
-		result2 []string
+		result2 map[string]string
 		result3 error
 	}{result1, result2, result3}
 }
 
-func (fake *FakeRepoScanner) ScanRepositoryReturnsOnCall(i int, result1 []v1alpha1.ProfileCatalogEntry, result2 []string, result3 error) {
+func (fake *FakeRepoScanner) ScanRepositoryReturnsOnCall(i int, result1 []v1alpha1.ProfileCatalogEntry, result2 map[string]string, result3 error) {
 	fake.scanRepositoryMutex.Lock()
 	defer fake.scanRepositoryMutex.Unlock()
 	fake.ScanRepositoryStub = nil
 	if fake.scanRepositoryReturnsOnCall == nil {
 		fake.scanRepositoryReturnsOnCall = make(map[int]struct {
 			result1 []v1alpha1.ProfileCatalogEntry
-			result2 []string
+			result2 map[string]string
 			result3 error
 		})
 	}
 	fake.scanRepositoryReturnsOnCall[i] = struct {
 		result1 []v1alpha1.ProfileCatalogEntry
-		result2 []string
+		result2 map[string]string
 		result3 error
 	}{result1, result2, result3}
 }
@@ -0,0 +1,60 @@
+package scanner
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/fluxcd/pkg/version"
+	profilesv1 "github.com/weaveworks/profiles/api/v1alpha1"
+)
+
+//tagFilter selects the tags of a repository to scan
+type tagFilter struct {
+	include    []string
+	exclude    []string
+	constraint *semver.Constraints
+}
+
+func newTagFilter(repo profilesv1.Repository) (*tagFilter, error) {
+	for _, pattern := range append(append([]string{}, repo.IncludeTags...), repo.ExcludeTags...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid tag glob %q: %w", pattern, err)
+		}
+	}
+	f := &tagFilter{include: repo.IncludeTags, exclude: repo.ExcludeTags}
+	if repo.SemverConstraint != "" {
+		constraint, err := semver.NewConstraint(repo.SemverConstraint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid semver constraint %q: %w", repo.SemverConstraint, err)
+		}
+		f.constraint = constraint
+	}
+	return f, nil
+}
+
+//matches returns whether a tag with the given version passes the filter. Tags must match one
+//of the include globs, if any, none of the exclude globs and have a version satisfying the
+//semver constraint, if any.
+func (f *tagFilter) matches(tag, tagVersion string) bool {
+	if len(f.include) > 0 && !matchesAny(f.include, tag) {
+		return false
+	}
+	if matchesAny(f.exclude, tag) {
+		return false
+	}
+	if f.constraint == nil {
+		return true
+	}
+	v, err := version.ParseVersion(tagVersion)
+	return err == nil && f.constraint.Check(v)
+}
+
+func matchesAny(patterns []string, tag string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, tag); ok {
+			return true
+		}
+	}
+	return false
+}
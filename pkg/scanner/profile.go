@@ -0,0 +1,74 @@
+package scanner
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	profilesv1 "github.com/weaveworks/profiles/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+const profileDefinitionKind = "ProfileDefinition"
+
+// scannedProfile is a profile found in a tag
+type scannedProfile struct {
+	definition *profilesv1.ProfileDefinition
+	// dirs are the directories below the directory of the profile.yaml, relative to it
+	dirs map[string]bool
+}
+
+// decodeProfile decodes a profile.yaml, checking it is a ProfileDefinition. The errors of an
+// invalid profile.yaml are InvalidProfileErrors.
+func decodeProfile(r io.Reader) (*profilesv1.ProfileDefinition, error) {
+	var profileDef profilesv1.ProfileDefinition
+	if err := yaml.NewYAMLOrJSONDecoder(r, 10000).Decode(&profileDef); err != nil {
+		return nil, &InvalidProfileError{Err: fmt.Errorf("failed to decode profile.yaml: %w", err)}
+	}
+	if profileDef.Kind != profileDefinitionKind {
+		return nil, &InvalidProfileError{Err: fmt.Errorf("invalid profile.yaml: kind must be %q, got %q", profileDefinitionKind, profileDef.Kind)}
+	}
+	if profileDef.APIVersion != profilesv1.GroupVersion.String() {
+		return nil, &InvalidProfileError{Err: fmt.Errorf("invalid profile.yaml: apiVersion must be %q, got %q", profilesv1.GroupVersion.String(), profileDef.APIVersion)}
+	}
+	return &profileDef, nil
+}
+
+// addDirs adds the directories of file, relative to the profile directory, to dirs.
+func addDirs(dirs map[string]bool, profileDir, file string) {
+	rel := file
+	if profileDir != "." {
+		if !strings.HasPrefix(file, profileDir+"/") {
+			return
+		}
+		rel = strings.TrimPrefix(file, profileDir+"/")
+	}
+	for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+		dirs[dir] = true
+	}
+}
+
+// artifactPaths returns the local paths of the artifacts of the profile which exist.
+func artifactPaths(profile *scannedProfile) []string {
+	var paths []string
+	for _, artifact := range profile.definition.Spec.Artifacts {
+		var artifactPath string
+		switch {
+		case artifact.Kustomize != nil:
+			artifactPath = artifact.Kustomize.Path
+		case artifact.Chart != nil:
+			artifactPath = artifact.Chart.Path
+		}
+		if artifactPath == "" {
+			continue
+		}
+		artifactPath = path.Clean(strings.TrimPrefix(artifactPath, "/"))
+		if profile.dirs[artifactPath] && !containsString(paths, artifactPath) {
+			paths = append(paths, artifactPath)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
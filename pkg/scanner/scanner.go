@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"path/filepath"
+	"path"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/fluxcd/pkg/version"
 	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
@@ -15,14 +17,14 @@ import (
 	profilesv1 "github.com/weaveworks/profiles/api/v1alpha1"
 	"github.com/weaveworks/profiles/pkg/gitrepository"
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/util/yaml"
 )
 
 //go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 -generate
 //counterfeiter:generate -o fakes/fake_git_client.go . GitClient
 //GitClient client for interacting with git
 type GitClient interface {
-	ListTags(url string, secret *corev1.Secret) ([]string, error)
+	ListTags(url string, secret *corev1.Secret) (map[string]string, error)
+	ListBranches(url string, secret *corev1.Secret) (map[string]string, error)
 }
 
 //counterfeiter:generate -o fakes/fake_repo_manager.go . GitRepositoryManager
@@ -38,56 +40,123 @@ type HTTPClient interface {
 	Do(req *http.Request) (*http.Response, error)
 }
 
+//DefaultConcurrency is the number of tags scanned in parallel by default
+const DefaultConcurrency = 4
+
+//Semaphore limits the repositories and tags scanned in parallel by the scanners sharing it.
+//Listing the tags of a repository and scanning each of its tags take one slot each.
+type Semaphore chan struct{}
+
+//NewSemaphore returns a Semaphore scanning at most concurrency repositories and tags in parallel
+func NewSemaphore(concurrency int) Semaphore {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return make(Semaphore, concurrency)
+}
+
+// do calls f holding a slot of the semaphore.
+func (s Semaphore) do(f func()) {
+	s <- struct{}{}
+	defer func() {
+		<-s
+	}()
+	f()
+}
+
+//Option configures a scanner
+type Option func(*options)
+
+type options struct {
+	concurrency int
+	sem         Semaphore
+}
+
+//WithConcurrency sets the number of tags scanned in parallel
+func WithConcurrency(concurrency int) Option {
+	return func(o *options) {
+		o.concurrency = concurrency
+	}
+}
+
+//WithSemaphore shares a Semaphore between scanners, in place of the concurrency of each scanner
+func WithSemaphore(sem Semaphore) Option {
+	return func(o *options) {
+		o.sem = sem
+	}
+}
+
+func makeOptions(opts []Option) options {
+	o := options{concurrency: DefaultConcurrency}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.sem == nil {
+		o.sem = NewSemaphore(o.concurrency)
+	}
+	return o
+}
+
 //Scanner for scanning repositorys
 type Scanner struct {
 	gitRepositoryManager GitRepositoryManager
 	gitClient            GitClient
 	httpClient           HTTPClient
 	logger               logr.Logger
+	options
 }
 
 //New returns a Scanner
-func New(gitRepositoryManager GitRepositoryManager, gitClient GitClient, httpClient HTTPClient, logger logr.Logger) RepoScanner {
+func New(gitRepositoryManager GitRepositoryManager, gitClient GitClient, httpClient HTTPClient, logger logr.Logger, opts ...Option) RepoScanner {
 	return &Scanner{
 		gitRepositoryManager: gitRepositoryManager,
 		gitClient:            gitClient,
 		httpClient:           httpClient,
 		logger:               logger,
+		options:              makeOptions(opts),
 	}
 }
 
 //counterfeiter:generate -o fakes/fake_scanner.go . RepoScanner
-// RepoScanner is an interface for scanning repositories for profiles
+// RepoScanner is an interface for scanning repositories for profiles. ScanRepository is given
+// the tags scanned already with the commits they were scanned at, or an empty commit when it is
+// unknown. It returns the profiles found in the tags it scanned and all the tags which are
+// scanned afterwards with their commits: tags which were deleted, or moved and failed to be
+// scanned again, are not returned.
 type RepoScanner interface {
-	ScanRepository(profilesv1.Repository, *corev1.Secret, []string) ([]profilesv1.ProfileCatalogEntry, []string, error)
+	ScanRepository(profilesv1.Repository, *corev1.Secret, map[string]string) ([]profilesv1.ProfileCatalogEntry, map[string]string, error)
 }
 
 //ScanRepository for profiles
-func (s *Scanner) ScanRepository(repo profilesv1.Repository, secret *corev1.Secret, alreadyScannedTags []string) ([]profilesv1.ProfileCatalogEntry, []string, error) {
-	tags, err := s.gitClient.ListTags(repo.URL, secret)
+func (s *Scanner) ScanRepository(repo profilesv1.Repository, secret *corev1.Secret, scannedTags map[string]string) ([]profilesv1.ProfileCatalogEntry, map[string]string, error) {
+	var (
+		instances []gitrepository.Instance
+		tags      map[string]string
+		err       error
+	)
+	s.sem.do(func() {
+		instances, tags, err = listInstances(s.gitClient, s.logger, repo, secret, scannedTags)
+	})
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to list tags: %w", err)
+		return nil, nil, err
 	}
-	s.logger.Info("found tags", "url", repo.URL, "tags", tags)
 
-	var instances []gitrepository.Instance
-	var newTags []string
-	for _, tag := range tags {
-		semver, path := getSemverAndPathFromTag(tag)
-		if !containsString(alreadyScannedTags, tag) {
-			newTags = append(newTags, tag)
-			if _, err := version.ParseVersion(semver); err == nil {
-				instances = append(instances, gitrepository.Instance{
-					Tag:  tag,
-					Path: path,
-				})
-			}
-		}
-	}
+	scannedProfiles := make([]*scannedProfile, len(instances))
+	errs := forEach(len(instances), s.sem, func(i int) error {
+		profile, err := s.scanInstance(repo, instances[i])
+		scannedProfiles[i] = profile
+		return err
+	})
+
+	return makeEntries(repo, instances, scannedProfiles, errs, tags)
+}
 
-	gitRepositoryResources, err := s.gitRepositoryManager.CreateAndWaitForResources(repo, instances)
+// scanInstance scans a tag through a gitrepository resource created for it, which is deleted
+// once its artifact is fetched.
+func (s *Scanner) scanInstance(repo profilesv1.Repository, instance gitrepository.Instance) (*scannedProfile, error) {
+	gitRepositoryResources, err := s.gitRepositoryManager.CreateAndWaitForResources(repo, []gitrepository.Instance{instance})
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create gitrepository resources: %w", err)
+		return nil, fmt.Errorf("failed to create gitrepository resources: %w", err)
 	}
 	s.logger.Info("gitrepositorys created", "gitrepositories", gitRepositoryResources)
 
@@ -97,23 +166,128 @@ func (s *Scanner) ScanRepository(repo profilesv1.Repository, secret *corev1.Secr
 		}
 	}()
 
-	var profiles []profilesv1.ProfileCatalogEntry
-	for _, gitRepo := range gitRepositoryResources {
-		profileDef, err := s.fetchProfileFromTarball(gitRepo)
-		if err != nil {
-			return nil, nil, err
+	if len(gitRepositoryResources) == 0 {
+		return nil, fmt.Errorf("no gitrepository resource created")
+	}
+	return s.fetchProfileFromTarball(gitRepositoryResources[0], instance.Path)
+}
+
+// makeEntries returns the catalog entries of the profiles found in the tags. Tags without a
+// profile definition are scanned and have no entry. Tags which failed to scan are returned in
+// a PartialScanError, and removed from the scanned tags unless they failed permanently.
+func makeEntries(repo profilesv1.Repository, instances []gitrepository.Instance, scannedProfiles []*scannedProfile, errs []error, scannedTags map[string]string) ([]profilesv1.ProfileCatalogEntry, map[string]string, error) {
+	var (
+		profiles  []profilesv1.ProfileCatalogEntry
+		tagErrors []TagError
+	)
+	for i, instance := range instances {
+		if errs[i] != nil {
+			tagErrors = append(tagErrors, TagError{Tag: instance.Tag, Err: errs[i]})
+			continue
+		}
+		if scannedProfiles[i] == nil {
+			continue
 		}
-		if profileDef != nil && profileDef.Name != "" {
+		if profileDef := scannedProfiles[i].definition; profileDef.Name != "" {
+			tagPattern := repo.TagPattern
+			if instance.Branch != "" {
+				tagPattern = profilesv1.BranchTagPattern
+			}
 			profiles = append(profiles, profilesv1.ProfileCatalogEntry{
 				ProfileDescription: profileDef.Spec.ProfileDescription,
-				Tag:                gitRepo.Spec.Reference.Tag,
+				Tag:                instance.Tag,
 				URL:                repo.URL,
 				Name:               profileDef.Name,
+				TagPattern:         tagPattern,
+				Branch:             instance.Branch,
+				Commit:             instance.Commit,
+				ProfileFilename:    repo.ProfileFilename,
+				ArtifactPaths:      artifactPaths(scannedProfiles[i]),
 			})
 		}
 	}
 
-	return profiles, newTags, nil
+	if len(tagErrors) == 0 {
+		return profiles, scannedTags, nil
+	}
+	for _, tagErr := range tagErrors {
+		if !tagErr.Permanent() {
+			delete(scannedTags, tagErr.Tag)
+		}
+	}
+	return profiles, scannedTags, &PartialScanError{TagErrors: tagErrors}
+}
+
+// listInstances lists the tags and tracked branches of the repository, returning the instances
+// to scan and the tags which are scanned once the instances are, with their current commits.
+func listInstances(gitClient GitClient, logger logr.Logger, repo profilesv1.Repository, secret *corev1.Secret, scannedTags map[string]string) ([]gitrepository.Instance, map[string]string, error) {
+	if _, err := profilesv1.CompileTagPattern(repo.TagPattern); err != nil {
+		return nil, nil, err
+	}
+	filter, err := newTagFilter(repo)
+	if err != nil {
+		return nil, nil, err
+	}
+	tags, err := gitClient.ListTags(repo.URL, secret)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list tags: %w", err)
+	}
+	logger.Info("found tags", "url", repo.URL, "tags", tags)
+	instances, newTags := newInstances(repo, filter, tags, scannedTags)
+
+	if len(repo.Branches) == 0 {
+		return instances, newTags, nil
+	}
+	heads, err := gitClient.ListBranches(repo.URL, secret)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list branches: %w", err)
+	}
+	for _, branch := range repo.Branches {
+		commit, ok := heads[branch.Name]
+		if !ok {
+			logger.Info("tracked branch not found", "url", repo.URL, "branch", branch.Name)
+			continue
+		}
+		tag := profilesv1.BranchTag(branch.Path, branch.Name, commit)
+		_, scanned := scannedTags[tag]
+		newTags[tag] = commit
+		if scanned {
+			continue
+		}
+		instances = append(instances, gitrepository.Instance{
+			Tag:    tag,
+			Path:   path.Join(branch.Path, profileFilename(repo)),
+			Branch: branch.Name,
+			Commit: commit,
+		})
+	}
+	return instances, newTags, nil
+}
+
+// newInstances returns the instances to scan for the tags which have not been scanned yet, or
+// were scanned at another commit, and match the tag pattern of the repository with a valid
+// semver. It also returns the tags passing the filter with their commits. Filtered tags are not
+// returned so that they are reconsidered when the filter changes.
+func newInstances(repo profilesv1.Repository, filter *tagFilter, tags, scannedTags map[string]string) ([]gitrepository.Instance, map[string]string) {
+	var instances []gitrepository.Instance
+	newTags := make(map[string]string)
+	for _, tag := range sortedKeys(tags) {
+		semver, path, ok := parseTag(repo, tag)
+		if ok && !filter.matches(tag, semver) {
+			continue
+		}
+		newTags[tag] = tags[tag]
+		if scannedCommit, scanned := scannedTags[tag]; scanned && (scannedCommit == "" || scannedCommit == tags[tag]) {
+			continue
+		}
+		if _, err := version.ParseVersion(semver); ok && err == nil {
+			instances = append(instances, gitrepository.Instance{
+				Tag:  tag,
+				Path: path,
+			})
+		}
+	}
+	return instances, newTags
 }
 
 func containsString(list []string, value string) bool {
@@ -125,7 +299,36 @@ func containsString(list []string, value string) bool {
 	return false
 }
 
-func (s *Scanner) fetchProfileFromTarball(gitRepo *sourcev1.GitRepository) (*profilesv1.ProfileDefinition, error) {
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// forEach calls f for 0..n-1, each call holding a slot of the semaphore. It returns the error
+// of each call at its index.
+func forEach(n int, sem Semaphore, f func(i int) error) []error {
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			errs[i] = f(i)
+		}(i)
+	}
+	wg.Wait()
+	return errs
+}
+
+func (s *Scanner) fetchProfileFromTarball(gitRepo *sourcev1.GitRepository, profilePath string) (*scannedProfile, error) {
 	req, err := http.NewRequest("GET", gitRepo.Status.URL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
@@ -135,48 +338,81 @@ func (s *Scanner) fetchProfileFromTarball(gitRepo *sourcev1.GitRepository) (*pro
 	if err != nil {
 		return nil, fmt.Errorf("failed to GET %q: %w", gitRepo.Status.URL, err)
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("request failed status code %d", resp.StatusCode)
 	}
 
-	return extractProfileFromTarball(resp.Body)
+	return extractProfileFromTarball(resp.Body, profilePath)
 }
 
-func extractProfileFromTarball(gzipStream io.Reader) (*profilesv1.ProfileDefinition, error) {
+//ExtractProfileFromTarball decodes the profile definition at profilePath in a gzipped tarball,
+//checking it is a ProfileDefinition like the profiles of the catalog are. It returns nil if the
+//tarball has no profile definition at profilePath.
+func ExtractProfileFromTarball(gzipStream io.Reader, profilePath string) (*profilesv1.ProfileDefinition, error) {
+	profile, err := extractProfileFromTarball(gzipStream, profilePath)
+	if err != nil || profile == nil {
+		return nil, err
+	}
+	return profile.definition, nil
+}
+
+// extractProfileFromTarball decodes the profile.yaml at profilePath in the tarball, and
+// collects the directories next to it. It returns nil if the tarball has no profile.yaml.
+func extractProfileFromTarball(gzipStream io.Reader, profilePath string) (*scannedProfile, error) {
 	uncompressedStream, err := gzip.NewReader(gzipStream)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse tarball: %w", err)
 	}
 	tarReader := tar.NewReader(uncompressedStream)
+
+	var profileDef *profilesv1.ProfileDefinition
+	profileDir := path.Dir(profilePath)
+	dirs := make(map[string]bool)
 	for {
 		header, err := tarReader.Next()
 
 		if err == io.EOF {
-			return nil, nil
+			break
 		}
 
 		if err != nil {
 			return nil, fmt.Errorf("failed to read tarball file: %w", err)
 		}
 
-		if header.Typeflag == tar.TypeReg {
-			decoder := yaml.NewYAMLOrJSONDecoder(tarReader, 10000)
-			var profileDef profilesv1.ProfileDefinition
-			if err = decoder.Decode(&profileDef); err != nil {
-				return nil, fmt.Errorf("failed to decode profile.yaml: %w", err)
+		name := strings.TrimPrefix(path.Clean(header.Name), "./")
+		switch header.Typeflag {
+		case tar.TypeDir:
+			addDirs(dirs, profileDir, name+"/")
+		case tar.TypeReg:
+			addDirs(dirs, profileDir, name)
+			if name == profilePath {
+				if profileDef, err = decodeProfile(tarReader); err != nil {
+					return nil, err
+				}
 			}
-			return &profileDef, nil
 		}
 	}
+
+	if profileDef == nil {
+		return nil, nil
+	}
+	return &scannedProfile{definition: profileDef, dirs: dirs}, nil
+}
+
+// parseTag returns the version of the profile a tag was created for and the path of its
+// definition file, and whether the tag matches the tag pattern of the repository.
+func parseTag(repo profilesv1.Repository, tag string) (string, string, bool) {
+	semver, dir, ok := profilesv1.ParseTag(tag, repo.TagPattern)
+	if !ok {
+		return "", "", false
+	}
+	return semver, path.Join(dir, profileFilename(repo)), true
 }
 
-func getSemverAndPathFromTag(tag string) (string, string) {
-	v := tag
-	path := "profile.yaml"
-	splitTag := strings.Split(tag, "/")
-	if len(splitTag) == 2 {
-		path = filepath.Join(splitTag[0], path)
-		v = splitTag[1]
+func profileFilename(repo profilesv1.Repository) string {
+	if repo.ProfileFilename == "" {
+		return profilesv1.DefaultProfileFilename
 	}
-	return v, path
+	return repo.ProfileFilename
 }
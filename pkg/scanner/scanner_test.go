@@ -3,9 +3,14 @@ package scanner_test
 import (
 	"archive/tar"
 	"compress/gzip"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-logr/logr"
 	. "github.com/onsi/ginkgo"
@@ -50,64 +55,59 @@ var _ = Describe("Scanner", func() {
 
 	Context("when the repo has matching tags", func() {
 		BeforeEach(func() {
-			gitClient.ListTagsReturns([]string{"name/v0.0.1", "name/v0.1.0", "v1.0.0", "some-notsemver"}, nil)
-			gitRepoManager.CreateAndWaitForResourcesReturns([]*sourcev1.GitRepository{
-				{
-					ObjectMeta: metav1.ObjectMeta{
-						Name:      "repo-v0.1.0",
-						Namespace: "profiles-system",
-					},
-					Spec: sourcev1.GitRepositorySpec{
-						URL: "github.com/example/repo",
-						Reference: &sourcev1.GitRepositoryRef{
-							Tag: "v0.1.0",
-						},
-					},
-					Status: sourcev1.GitRepositoryStatus{
-						URL: "tarball.one",
-					},
-				},
-				{
-					ObjectMeta: metav1.ObjectMeta{
-						Name:      "repo-foo-v1.0.0",
-						Namespace: "profiles-system",
-					},
-					Spec: sourcev1.GitRepositorySpec{
-						URL: "github.com/example/repo",
-						Reference: &sourcev1.GitRepositoryRef{
-							Tag: "foo/v1.0.0",
-						},
-					},
-					Status: sourcev1.GitRepositoryStatus{
-						URL: "tarball.two",
-					},
-				},
-			}, nil)
-
-			httpClient.DoReturnsOnCall(0, &http.Response{
-				StatusCode: http.StatusOK,
-				Body: tarContents([]byte(`---
+			gitClient.ListTagsReturns(commits("name/v0.0.1", "name/v0.1.0", "v1.0.0", "some-notsemver"), nil)
+			gitRepoManager.CreateAndWaitForResourcesStub = gitRepositories(map[string]string{
+				"v1.0.0":      "tarball.one",
+				"name/v0.1.0": "tarball.two",
+			})
+
+			tarballs := map[string]io.ReadCloser{
+				"tarball.one": tarContents([]byte(`---
+apiVersion: weave.works/v1alpha1
+kind: ProfileDefinition
 metadata:
   name: other-name
 spec:
   description: some desc
   maintainer: me
   Prerequisites:
-  - stuff`))}, nil)
-			httpClient.DoReturnsOnCall(1, &http.Response{
-				StatusCode: http.StatusOK,
-				Body: tarContents([]byte(`---
+  - stuff`)),
+				"tarball.two": tarFiles(map[string]string{
+					"name/profile.yaml": `---
+apiVersion: weave.works/v1alpha1
+kind: ProfileDefinition
 metadata:
   name: foo-name
 spec:
   description: some desc
   maintainer: me
   Prerequisites:
-  - stuff`))}, nil)
+  - stuff
+  artifacts:
+  - name: nginx
+    chart:
+      path: nginx/chart
+  - name: missing
+    kustomize:
+      path: missing
+  - name: remote
+    chart:
+      url: https://charts.example.com
+      name: remote`,
+					"name/nginx/chart/Chart.yaml": "name: nginx",
+					"other/profile.yaml":          "not: the profile",
+				}),
+			}
+			httpClient.DoStub = func(req *http.Request) (*http.Response, error) {
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       tarballs[req.URL.String()],
+				}, nil
+			}
 		})
 
 		It("returns a list of profiles", func() {
-			profiles, tags, err := s.ScanRepository(repo, repoSecret, []string{"name/v0.0.1"})
+			profiles, tags, err := s.ScanRepository(repo, repoSecret, commits("name/v0.0.1"))
 			Expect(err).NotTo(HaveOccurred())
 
 			Expect(gitClient.ListTagsCallCount()).To(Equal(1))
@@ -115,12 +115,16 @@ spec:
 			Expect(url).To(Equal("github.com/example/repo"))
 			Expect(secret).To(Equal(repoSecret))
 
-			Expect(gitRepoManager.CreateAndWaitForResourcesCallCount()).To(Equal(1))
-			givenRepo, repos := gitRepoManager.CreateAndWaitForResourcesArgsForCall(0)
-			Expect(givenRepo).To(Equal(repo))
-			Expect(url).To(Equal("github.com/example/repo"))
-			Expect(secret).To(Equal(repoSecret))
-			Expect(repos).To(ConsistOf(
+			By("creating a gitrepository resource for each tag")
+			Expect(gitRepoManager.CreateAndWaitForResourcesCallCount()).To(Equal(2))
+			var instances []gitrepository.Instance
+			for i := 0; i < 2; i++ {
+				givenRepo, repos := gitRepoManager.CreateAndWaitForResourcesArgsForCall(i)
+				Expect(givenRepo).To(Equal(repo))
+				Expect(repos).To(HaveLen(1))
+				instances = append(instances, repos...)
+			}
+			Expect(instances).To(ConsistOf(
 				gitrepository.Instance{
 					Tag:  "name/v0.1.0",
 					Path: "name/profile.yaml",
@@ -131,42 +135,20 @@ spec:
 				},
 			))
 			Expect(httpClient.DoCallCount()).To(Equal(2))
-			Expect(httpClient.DoArgsForCall(0).URL.String()).To(Equal("tarball.one"))
-			Expect(httpClient.DoArgsForCall(1).URL.String()).To(Equal("tarball.two"))
-
-			Expect(gitRepoManager.DeleteResourcesCallCount()).To(Equal(1))
-			Expect(gitRepoManager.DeleteResourcesArgsForCall(0)).To(ConsistOf(
-				&sourcev1.GitRepository{
-					ObjectMeta: metav1.ObjectMeta{
-						Name:      "repo-v0.1.0",
-						Namespace: "profiles-system",
-					},
-					Spec: sourcev1.GitRepositorySpec{
-						URL: "github.com/example/repo",
-						Reference: &sourcev1.GitRepositoryRef{
-							Tag: "v0.1.0",
-						},
-					},
-					Status: sourcev1.GitRepositoryStatus{
-						URL: "tarball.one",
-					},
-				},
-				&sourcev1.GitRepository{
-					ObjectMeta: metav1.ObjectMeta{
-						Name:      "repo-foo-v1.0.0",
-						Namespace: "profiles-system",
-					},
-					Spec: sourcev1.GitRepositorySpec{
-						URL: "github.com/example/repo",
-						Reference: &sourcev1.GitRepositoryRef{
-							Tag: "foo/v1.0.0",
-						},
-					},
-					Status: sourcev1.GitRepositoryStatus{
-						URL: "tarball.two",
-					},
-				},
-			))
+			Expect([]string{
+				httpClient.DoArgsForCall(0).URL.String(),
+				httpClient.DoArgsForCall(1).URL.String(),
+			}).To(ConsistOf("tarball.one", "tarball.two"))
+
+			By("deleting the gitrepository resources")
+			Expect(gitRepoManager.DeleteResourcesCallCount()).To(Equal(2))
+			var deleted []string
+			for i := 0; i < 2; i++ {
+				for _, gitRepo := range gitRepoManager.DeleteResourcesArgsForCall(i) {
+					deleted = append(deleted, gitRepo.Spec.Reference.Tag)
+				}
+			}
+			Expect(deleted).To(ConsistOf("name/v0.1.0", "v1.0.0"))
 
 			Expect(profiles).To(ConsistOf(profilesv1.ProfileCatalogEntry{
 				ProfileDescription: profilesv1.ProfileDescription{
@@ -174,9 +156,10 @@ spec:
 					Maintainer:    "me",
 					Prerequisites: []string{"stuff"},
 				},
-				Name: "foo-name",
-				Tag:  "foo/v1.0.0",
-				URL:  "github.com/example/repo",
+				Name:          "foo-name",
+				Tag:           "name/v0.1.0",
+				URL:           "github.com/example/repo",
+				ArtifactPaths: []string{"nginx/chart"},
 			}, profilesv1.ProfileCatalogEntry{
 				ProfileDescription: profilesv1.ProfileDescription{
 					Description:   "some desc",
@@ -184,10 +167,10 @@ spec:
 					Prerequisites: []string{"stuff"},
 				},
 				Name: "other-name",
-				Tag:  "v0.1.0",
+				Tag:  "v1.0.0",
 				URL:  "github.com/example/repo",
 			}))
-			Expect(tags).To(ConsistOf("name/v0.1.0", "v1.0.0", "some-notsemver"))
+			Expect(tags).To(Equal(commits("name/v0.0.1", "name/v0.1.0", "v1.0.0", "some-notsemver")))
 		})
 	})
 
@@ -205,36 +188,22 @@ spec:
 
 	When("CreateAndWaitForResources fails", func() {
 		BeforeEach(func() {
+			gitClient.ListTagsReturns(commits("v0.1.0"), nil)
 			gitRepoManager.CreateAndWaitForResourcesReturns(nil, fmt.Errorf("createfail"))
 		})
 
-		It("returns an error", func() {
+		It("returns an error for the tag", func() {
 			_, _, err := s.ScanRepository(repo, repoSecret, nil)
-			Expect(err).To(MatchError("failed to create gitrepository resources: createfail"))
+			Expect(err).To(MatchError(`failed to scan 1 tags: tag "v0.1.0": failed to create gitrepository resources: createfail`))
 		})
 	})
 
 	When("the tarball url is invalid", func() {
 		BeforeEach(func() {
-			gitClient.ListTagsReturns([]string{"name/v0.1.0", "v1.0.0", "some-notsemver"}, nil)
-			gitRepoManager.CreateAndWaitForResourcesReturns([]*sourcev1.GitRepository{
-				{
-					ObjectMeta: metav1.ObjectMeta{
-						Name:      "repo-v0.1.0",
-						Namespace: "profiles-system",
-					},
-					Spec: sourcev1.GitRepositorySpec{
-						URL: "github.com/example/repo",
-						Reference: &sourcev1.GitRepositoryRef{
-							Tag: "v0.1.0",
-						},
-					},
-					Status: sourcev1.GitRepositoryStatus{
-						URL: "invaludurl{DEf1=ghi@example.com:5432/db?sslmode=require",
-					},
-				},
-			}, nil)
-
+			gitClient.ListTagsReturns(commits("v0.1.0"), nil)
+			gitRepoManager.CreateAndWaitForResourcesStub = gitRepositories(map[string]string{
+				"v0.1.0": "invaludurl{DEf1=ghi@example.com:5432/db?sslmode=require",
+			})
 		})
 
 		It("returns an error", func() {
@@ -245,54 +214,22 @@ spec:
 
 	When("httpclient.Do fails", func() {
 		BeforeEach(func() {
-			gitClient.ListTagsReturns([]string{"name/v0.1.0", "v1.0.0", "some-notsemver"}, nil)
-			gitRepoManager.CreateAndWaitForResourcesReturns([]*sourcev1.GitRepository{
-				{
-					ObjectMeta: metav1.ObjectMeta{
-						Name:      "repo-v0.1.0",
-						Namespace: "profiles-system",
-					},
-					Spec: sourcev1.GitRepositorySpec{
-						URL: "github.com/example/repo",
-						Reference: &sourcev1.GitRepositoryRef{
-							Tag: "v0.1.0",
-						},
-					},
-					Status: sourcev1.GitRepositoryStatus{
-						URL: "tarball.one",
-					},
-				},
-			}, nil)
+			gitClient.ListTagsReturns(commits("v0.1.0"), nil)
+			gitRepoManager.CreateAndWaitForResourcesStub = gitRepositories(map[string]string{"v0.1.0": "tarball.one"})
 
 			httpClient.DoReturnsOnCall(0, &http.Response{}, fmt.Errorf("dofail"))
 		})
 
 		It("returns an error", func() {
 			_, _, err := s.ScanRepository(repo, repoSecret, nil)
-			Expect(err).To(MatchError("failed to GET \"tarball.one\": dofail"))
+			Expect(err).To(MatchError(`failed to scan 1 tags: tag "v0.1.0": failed to GET "tarball.one": dofail`))
 		})
 	})
 
 	When("request returns non 200", func() {
 		BeforeEach(func() {
-			gitClient.ListTagsReturns([]string{"name/v0.1.0", "v1.0.0", "some-notsemver"}, nil)
-			gitRepoManager.CreateAndWaitForResourcesReturns([]*sourcev1.GitRepository{
-				{
-					ObjectMeta: metav1.ObjectMeta{
-						Name:      "repo-v0.1.0",
-						Namespace: "profiles-system",
-					},
-					Spec: sourcev1.GitRepositorySpec{
-						URL: "github.com/example/repo",
-						Reference: &sourcev1.GitRepositoryRef{
-							Tag: "v0.1.0",
-						},
-					},
-					Status: sourcev1.GitRepositoryStatus{
-						URL: "tarball.one",
-					},
-				},
-			}, nil)
+			gitClient.ListTagsReturns(commits("v0.1.0"), nil)
+			gitRepoManager.CreateAndWaitForResourcesStub = gitRepositories(map[string]string{"v0.1.0": "tarball.one"})
 
 			httpClient.DoReturnsOnCall(0, &http.Response{
 				StatusCode: http.StatusBadRequest,
@@ -302,30 +239,14 @@ spec:
 
 		It("returns an error", func() {
 			_, _, err := s.ScanRepository(repo, repoSecret, nil)
-			Expect(err).To(MatchError("request failed status code 400"))
+			Expect(err).To(MatchError(`failed to scan 1 tags: tag "v0.1.0": request failed status code 400`))
 		})
 	})
 
 	When("the body isn't a tarball", func() {
 		BeforeEach(func() {
-			gitClient.ListTagsReturns([]string{"name/v0.1.0", "v1.0.0", "some-notsemver"}, nil)
-			gitRepoManager.CreateAndWaitForResourcesReturns([]*sourcev1.GitRepository{
-				{
-					ObjectMeta: metav1.ObjectMeta{
-						Name:      "repo-v0.1.0",
-						Namespace: "profiles-system",
-					},
-					Spec: sourcev1.GitRepositorySpec{
-						URL: "github.com/example/repo",
-						Reference: &sourcev1.GitRepositoryRef{
-							Tag: "v0.1.0",
-						},
-					},
-					Status: sourcev1.GitRepositoryStatus{
-						URL: "tarball.one",
-					},
-				},
-			}, nil)
+			gitClient.ListTagsReturns(commits("v0.1.0"), nil)
+			gitRepoManager.CreateAndWaitForResourcesStub = gitRepositories(map[string]string{"v0.1.0": "tarball.one"})
 
 			httpClient.DoReturnsOnCall(0, &http.Response{
 				StatusCode: http.StatusOK,
@@ -339,26 +260,115 @@ spec:
 		})
 	})
 
+	When("some of the tags cannot be scanned", func() {
+		BeforeEach(func() {
+			gitClient.ListTagsReturns(commits("v0.1.0", "v0.2.0", "v0.3.0", "v0.4.0"), nil)
+			gitRepoManager.CreateAndWaitForResourcesStub = gitRepositories(map[string]string{
+				"v0.1.0": "tarball.v0.1.0",
+				"v0.2.0": "tarball.v0.2.0",
+				"v0.3.0": "tarball.v0.3.0",
+				"v0.4.0": "tarball.v0.4.0",
+			})
+
+			tarballs := map[string]io.ReadCloser{
+				"tarball.v0.1.0": tarContents([]byte("apiVersion: weave.works/v1alpha1\nkind: ProfileDefinition\nmetadata:\n  name: foo")),
+				"tarball.v0.2.0": tarContents([]byte(`!@\:1\23notyaml`)),
+				// a tag without a profile.yaml is scanned and has no profile
+				"tarball.v0.3.0": emptyTarball(),
+			}
+			httpClient.DoStub = func(req *http.Request) (*http.Response, error) {
+				if req.URL.String() == "tarball.v0.4.0" {
+					return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: emptyTarball()}, nil
+				}
+				return &http.Response{StatusCode: http.StatusOK, Body: tarballs[req.URL.String()]}, nil
+			}
+		})
+
+		It("returns the profiles of the other tags and the errors of the failed tags", func() {
+			profiles, tags, err := s.ScanRepository(repo, repoSecret, nil)
+			Expect(profiles).To(ConsistOf(profilesv1.ProfileCatalogEntry{
+				Name: "foo",
+				Tag:  "v0.1.0",
+				URL:  "github.com/example/repo",
+			}))
+
+			By("recording the tags which failed permanently as scanned")
+			Expect(tags).To(Equal(commits("v0.1.0", "v0.2.0", "v0.3.0")))
+
+			var partialErr *scanner.PartialScanError
+			Expect(errors.As(err, &partialErr)).To(BeTrue())
+			Expect(partialErr.TagErrors).To(HaveLen(2))
+			Expect(partialErr.TagErrors[0].Tag).To(Equal("v0.2.0"))
+			Expect(partialErr.TagErrors[0].Error()).To(ContainSubstring("failed to decode profile.yaml:"))
+			Expect(partialErr.TagErrors[0].Permanent()).To(BeTrue())
+			Expect(partialErr.TagErrors[1].Tag).To(Equal("v0.4.0"))
+			Expect(partialErr.TagErrors[1].Error()).To(Equal(`tag "v0.4.0": request failed status code 503`))
+			Expect(partialErr.TagErrors[1].Permanent()).To(BeFalse())
+		})
+	})
+
+	When("scanning with a shared semaphore", func() {
+		It("never scans more tags in parallel than the semaphore allows", func() {
+			sem := scanner.NewSemaphore(2)
+			s = scanner.New(gitRepoManager, gitClient, httpClient, logr.Discard(), scanner.WithSemaphore(sem))
+			other := scanner.New(gitRepoManager, gitClient, httpClient, logr.Discard(), scanner.WithSemaphore(sem))
+			gitClient.ListTagsReturns(commits("v0.1.0", "v0.2.0", "v0.3.0"), nil)
+
+			var running, maxRunning int32
+			createGitRepositories := gitRepositories(map[string]string{"v0.1.0": "tarball", "v0.2.0": "tarball", "v0.3.0": "tarball"})
+			gitRepoManager.CreateAndWaitForResourcesStub = func(repo profilesv1.Repository, instances []gitrepository.Instance) ([]*sourcev1.GitRepository, error) {
+				n := atomic.AddInt32(&running, 1)
+				defer atomic.AddInt32(&running, -1)
+				for {
+					max := atomic.LoadInt32(&maxRunning)
+					if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+						break
+					}
+				}
+				time.Sleep(10 * time.Millisecond)
+				return createGitRepositories(repo, instances)
+			}
+			httpClient.DoStub = func(req *http.Request) (*http.Response, error) {
+				return &http.Response{StatusCode: http.StatusOK, Body: tarContents([]byte("apiVersion: weave.works/v1alpha1\nkind: ProfileDefinition\nmetadata:\n  name: foo"))}, nil
+			}
+
+			var wg sync.WaitGroup
+			for _, repoScanner := range []scanner.RepoScanner{s, other} {
+				wg.Add(1)
+				go func(repoScanner scanner.RepoScanner) {
+					defer GinkgoRecover()
+					defer wg.Done()
+					profiles, _, err := repoScanner.ScanRepository(repo, repoSecret, nil)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(profiles).To(HaveLen(3))
+				}(repoScanner)
+			}
+			wg.Wait()
+			Expect(gitRepoManager.CreateAndWaitForResourcesCallCount()).To(Equal(6))
+			Expect(atomic.LoadInt32(&maxRunning)).To(Equal(int32(2)))
+		})
+	})
+
+	When("the file is not a ProfileDefinition", func() {
+		BeforeEach(func() {
+			gitClient.ListTagsReturns(commits("v0.1.0"), nil)
+			gitRepoManager.CreateAndWaitForResourcesStub = gitRepositories(map[string]string{"v0.1.0": "tarball.one"})
+
+			httpClient.DoReturnsOnCall(0, &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       tarContents([]byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: foo"))}, nil)
+		})
+
+		It("returns an error", func() {
+			_, _, err := s.ScanRepository(repo, repoSecret, nil)
+			Expect(err).To(MatchError(`failed to scan 1 tags: tag "v0.1.0": invalid profile.yaml: kind must be "ProfileDefinition", got "ConfigMap"`))
+		})
+	})
+
 	When("the file isn't valid yaml", func() {
 		BeforeEach(func() {
-			gitClient.ListTagsReturns([]string{"name/v0.1.0"}, nil)
-			gitRepoManager.CreateAndWaitForResourcesReturns([]*sourcev1.GitRepository{
-				{
-					ObjectMeta: metav1.ObjectMeta{
-						Name:      "repo-v0.1.0",
-						Namespace: "profiles-system",
-					},
-					Spec: sourcev1.GitRepositorySpec{
-						URL: "github.com/example/repo",
-						Reference: &sourcev1.GitRepositoryRef{
-							Tag: "v0.1.0",
-						},
-					},
-					Status: sourcev1.GitRepositoryStatus{
-						URL: "tarball.one",
-					},
-				},
-			}, nil)
+			gitClient.ListTagsReturns(commits("v0.1.0"), nil)
+			gitRepoManager.CreateAndWaitForResourcesStub = gitRepositories(map[string]string{"v0.1.0": "tarball.one"})
 
 			httpClient.DoReturnsOnCall(0, &http.Response{
 				StatusCode: http.StatusOK,
@@ -372,18 +382,65 @@ spec:
 	})
 })
 
+// gitRepositories returns a CreateAndWaitForResources stub returning a gitrepository resource
+// for each instance, with the artifact url of its tag.
+func gitRepositories(artifactURLs map[string]string) func(profilesv1.Repository, []gitrepository.Instance) ([]*sourcev1.GitRepository, error) {
+	return func(repo profilesv1.Repository, instances []gitrepository.Instance) ([]*sourcev1.GitRepository, error) {
+		var gitRepos []*sourcev1.GitRepository
+		for _, instance := range instances {
+			gitRepos = append(gitRepos, &sourcev1.GitRepository{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "repo-" + strings.ReplaceAll(instance.Tag, "/", "-"),
+					Namespace: "profiles-system",
+				},
+				Spec: sourcev1.GitRepositorySpec{
+					URL:       repo.URL,
+					Reference: &sourcev1.GitRepositoryRef{Tag: instance.Tag},
+				},
+				Status: sourcev1.GitRepositoryStatus{URL: artifactURLs[instance.Tag]},
+			})
+		}
+		return gitRepos, nil
+	}
+}
+
+// commits returns the tags with a commit for each
+func commits(tags ...string) map[string]string {
+	m := make(map[string]string)
+	for _, tag := range tags {
+		m[tag] = "sha-" + tag
+	}
+	return m
+}
+
+func emptyTarball() io.ReadCloser {
+	buf := gbytes.NewBuffer()
+	gw := gzip.NewWriter(buf)
+	Expect(tar.NewWriter(gw).Close()).To(Succeed())
+	Expect(gw.Close()).To(Succeed())
+	return buf
+}
+
 func tarContents(content []byte) io.ReadCloser {
+	return tarFiles(map[string]string{"profile.yaml": string(content)})
+}
+
+func tarFiles(files map[string]string) io.ReadCloser {
 	buf := gbytes.NewBuffer()
 	gw := gzip.NewWriter(buf)
 	tw := tar.NewWriter(gw)
-	hdr := &tar.Header{
-		Name: "profile.yaml",
-		Mode: 0600,
-		Size: int64(len(content)),
+	for name, content := range files {
+		hdr := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Mode:     0600,
+			Size:     int64(len(content)),
+		}
+		Expect(tw.WriteHeader(hdr)).To(Succeed())
+		_, err := tw.Write([]byte(content))
+		Expect(err).NotTo(HaveOccurred())
 	}
-	Expect(tw.WriteHeader(hdr)).To(Succeed())
-	_, err := tw.Write([]byte(content))
-	Expect(err).NotTo(HaveOccurred())
+	Expect(tw.Close()).To(Succeed())
 	Expect(gw.Close()).To(Succeed())
 	return buf
 }
@@ -0,0 +1,127 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const tagRefPrefix = "refs/tags/"
+
+type githubPayload struct {
+	Ref        string `json:"ref"`
+	RefType    string `json:"ref_type"`
+	Repository struct {
+		CloneURL string `json:"clone_url"`
+		SSHURL   string `json:"ssh_url"`
+		HTMLURL  string `json:"html_url"`
+	} `json:"repository"`
+}
+
+// parseGitHub handles push and create events, signed with the X-Hub-Signature-256 header.
+func parseGitHub(req *http.Request, body, secret []byte) (*pushEvent, error) {
+	if err := verifySignature(req.Header.Get("X-Hub-Signature-256"), body, secret); err != nil {
+		return nil, err
+	}
+
+	var payload githubPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("%w: %s", errBadRequest, err)
+	}
+
+	var tag string
+	switch req.Header.Get("X-GitHub-Event") {
+	case "push":
+		if !strings.HasPrefix(payload.Ref, tagRefPrefix) {
+			return nil, nil
+		}
+		tag = strings.TrimPrefix(payload.Ref, tagRefPrefix)
+	case "create":
+		if payload.RefType != "tag" {
+			return nil, nil
+		}
+		tag = payload.Ref
+	default:
+		return nil, nil
+	}
+
+	return &pushEvent{
+		URLs: []string{payload.Repository.CloneURL, payload.Repository.SSHURL, payload.Repository.HTMLURL},
+		Tag:  tag,
+	}, nil
+}
+
+type gitlabPayload struct {
+	Ref     string `json:"ref"`
+	Project struct {
+		GitHTTPURL string `json:"git_http_url"`
+		GitSSHURL  string `json:"git_ssh_url"`
+		WebURL     string `json:"web_url"`
+	} `json:"project"`
+}
+
+// parseGitLab handles tag push events. GitLab does not sign payloads, instead it sends
+// the secret in the X-Gitlab-Token header.
+func parseGitLab(req *http.Request, body, secret []byte) (*pushEvent, error) {
+	if subtle.ConstantTimeCompare([]byte(req.Header.Get("X-Gitlab-Token")), secret) != 1 {
+		return nil, errUnauthorized
+	}
+	if req.Header.Get("X-Gitlab-Event") != "Tag Push Hook" {
+		return nil, nil
+	}
+
+	var payload gitlabPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("%w: %s", errBadRequest, err)
+	}
+
+	return &pushEvent{
+		URLs: []string{payload.Project.GitHTTPURL, payload.Project.GitSSHURL, payload.Project.WebURL},
+		Tag:  strings.TrimPrefix(payload.Ref, tagRefPrefix),
+	}, nil
+}
+
+type genericPayload struct {
+	URL string `json:"url"`
+	Tag string `json:"tag"`
+}
+
+// parseGeneric handles payloads in the format {"url": "<repository url>", "tag": "<tag>"},
+// signed with the X-Signature header.
+func parseGeneric(req *http.Request, body, secret []byte) (*pushEvent, error) {
+	if err := verifySignature(req.Header.Get("X-Signature"), body, secret); err != nil {
+		return nil, err
+	}
+
+	var payload genericPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("%w: %s", errBadRequest, err)
+	}
+	if payload.URL == "" {
+		return nil, fmt.Errorf("%w: url must be set", errBadRequest)
+	}
+
+	return &pushEvent{URLs: []string{payload.URL}, Tag: payload.Tag}, nil
+}
+
+// verifySignature checks a signature in the format sha256=<hex encoded HMAC-SHA256 of body>.
+func verifySignature(signature string, body, secret []byte) error {
+	if !strings.HasPrefix(signature, "sha256=") {
+		return errUnauthorized
+	}
+	actual, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return errUnauthorized
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	if !hmac.Equal(actual, mac.Sum(nil)) {
+		return errUnauthorized
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	profilesv1 "github.com/weaveworks/profiles/api/v1alpha1"
+)
+
+const maxPayloadSize = 10 * 1024 * 1024
+
+var (
+	errUnauthorized = errors.New("invalid signature")
+	errBadRequest   = errors.New("invalid payload")
+)
+
+// pushEvent is a tag pushed to a repository.
+type pushEvent struct {
+	// URLs are the URLs the repository can be cloned from
+	URLs []string
+	// Tag is the name of the pushed tag
+	Tag string
+}
+
+// parser verifies and decodes the payload of a webhook. A nil pushEvent is returned for
+// events which are not tag pushes.
+type parser func(req *http.Request, body, secret []byte) (*pushEvent, error)
+
+// Receiver handles push webhooks by requesting a rescan of the ProfileCatalogSources
+// listing the pushed repository.
+type Receiver struct {
+	logger logr.Logger
+	client client.Client
+	secret []byte
+}
+
+// NewReceiver returns a Receiver verifying webhook payloads with secret.
+func NewReceiver(logger logr.Logger, c client.Client, secret []byte) *Receiver {
+	return &Receiver{
+		logger: logger.WithName("webhook-receiver"),
+		client: c,
+		secret: secret,
+	}
+}
+
+// Handler returns the handler serving the GitHub, GitLab and generic webhook endpoints.
+func (r *Receiver) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/hook/github", r.handle(parseGitHub))
+	mux.Handle("/hook/gitlab", r.handle(parseGitLab))
+	mux.Handle("/hook/generic", r.handle(parseGeneric))
+	return mux
+}
+
+func (r *Receiver) handle(parse parser) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, maxPayloadSize))
+		if err != nil {
+			http.Error(w, "failed to read payload", http.StatusBadRequest)
+			return
+		}
+
+		event, err := parse(req, body, r.secret)
+		switch {
+		case errors.Is(err, errUnauthorized):
+			r.logger.Info("rejected webhook", "path", req.URL.Path, "reason", err.Error())
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		case err != nil:
+			r.logger.Info("rejected webhook", "path", req.URL.Path, "reason", err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case event == nil:
+			fmt.Fprintln(w, "ignored")
+			return
+		}
+
+		queued, err := r.requestRescan(req.Context(), event)
+		if err != nil {
+			r.logger.Error(err, "failed to request rescan", "urls", event.URLs, "tag", event.Tag)
+			http.Error(w, "failed to request rescan", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, "%d catalog sources queued for rescan\n", queued)
+	})
+}
+
+// requestRescan annotates every ProfileCatalogSource with a repository matching the
+// pushed repository, which triggers a rescan of that repository.
+func (r *Receiver) requestRescan(ctx context.Context, event *pushEvent) (int, error) {
+	urls := make(map[string]bool, len(event.URLs))
+	for _, url := range event.URLs {
+		if url != "" {
+			urls[normalizeURL(url)] = true
+		}
+	}
+
+	var sources profilesv1.ProfileCatalogSourceList
+	if err := r.client.List(ctx, &sources); err != nil {
+		return 0, fmt.Errorf("failed to list profile catalog sources: %w", err)
+	}
+
+	queued := 0
+	for i := range sources.Items {
+		source := &sources.Items[i]
+		repo, ok := matchRepository(source.Spec.Repos, urls)
+		if !ok {
+			continue
+		}
+
+		patch := client.MergeFrom(source.DeepCopy())
+		// only the pushed repository is rescanned, not every repository of the source
+		profilesv1.RequestRescan(source, time.Now().Format(time.RFC3339Nano), repo.URL)
+		if err := r.client.Patch(ctx, source, patch); err != nil {
+			return queued, fmt.Errorf("failed to annotate %s/%s: %w", source.Namespace, source.Name, err)
+		}
+		r.logger.Info("requested rescan", "profilecatalogsource", client.ObjectKeyFromObject(source), "repo", repo.URL, "tag", event.Tag)
+		queued++
+	}
+	return queued, nil
+}
+
+func matchRepository(repos []profilesv1.Repository, urls map[string]bool) (profilesv1.Repository, bool) {
+	for _, repo := range repos {
+		if urls[normalizeURL(repo.URL)] {
+			return repo, true
+		}
+	}
+	return profilesv1.Repository{}, false
+}
+
+// normalizeURL reduces a repository URL to <host>/<path> so that the HTTPS and SSH
+// URLs of a repository are equal.
+func normalizeURL(url string) string {
+	url = strings.ToLower(strings.TrimSpace(url))
+	for _, scheme := range []string{"https://", "http://", "ssh://", "git://"} {
+		url = strings.TrimPrefix(url, scheme)
+	}
+	if at := strings.Index(url, "@"); at >= 0 && at < strings.Index(url+"/", "/") {
+		url = url[at+1:]
+	}
+	// scp-like syntax, e.g. github.com:weaveworks/profiles
+	if colon := strings.Index(url, ":"); colon >= 0 && colon < strings.Index(url+"/", "/") {
+		url = url[:colon] + "/" + url[colon+1:]
+	}
+	url = strings.TrimSuffix(url, "/")
+	return strings.TrimSuffix(url, ".git")
+}
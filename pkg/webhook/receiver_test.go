@@ -0,0 +1,198 @@
+package webhook_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	profilesv1 "github.com/weaveworks/profiles/api/v1alpha1"
+	"github.com/weaveworks/profiles/pkg/webhook"
+)
+
+var _ = Describe("Receiver", func() {
+	var (
+		k8sClient client.Client
+		handler   http.Handler
+		secret    = []byte("secret")
+	)
+
+	catalogSource := func(name, url string) *profilesv1.ProfileCatalogSource {
+		return &profilesv1.ProfileCatalogSource{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: profilesv1.ProfileCatalogSourceSpec{
+				Repos: []profilesv1.Repository{{URL: url}},
+			},
+		}
+	}
+
+	sign := func(body []byte) string {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(body)
+		return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	post := func(path string, body []byte, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	getSource := func(name string) *profilesv1.ProfileCatalogSource {
+		source := &profilesv1.ProfileCatalogSource{}
+		Expect(k8sClient.Get(context.TODO(), client.ObjectKey{Name: name, Namespace: "default"}, source)).To(Succeed())
+		return source
+	}
+
+	rescanRequested := func(name string) bool {
+		_, ok := getSource(name).Annotations[meta.ReconcileRequestAnnotation]
+		return ok
+	}
+
+	BeforeEach(func() {
+		scheme := runtime.NewScheme()
+		Expect(profilesv1.AddToScheme(scheme)).To(Succeed())
+		k8sClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			catalogSource("examples", "ssh://git@github.com/weaveworks/profiles-examples"),
+			catalogSource("other", "https://github.com/weaveworks/other"),
+		).Build()
+		handler = webhook.NewReceiver(log.NullLogger{}, k8sClient, secret).Handler()
+	})
+
+	When("a tag is pushed to github", func() {
+		body := []byte(`{
+			"ref": "refs/tags/weaveworks-nginx/v0.1.1",
+			"repository": {
+				"clone_url": "https://github.com/weaveworks/profiles-examples.git",
+				"ssh_url": "git@github.com:weaveworks/profiles-examples.git",
+				"html_url": "https://github.com/weaveworks/profiles-examples"
+			}
+		}`)
+
+		It("requests a rescan of the matching catalog sources", func() {
+			rec := post("/hook/github", body, map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": sign(body)})
+			Expect(rec.Code).To(Equal(http.StatusAccepted))
+			Expect(rec.Body.String()).To(Equal("1 catalog sources queued for rescan\n"))
+			Expect(rescanRequested("examples")).To(BeTrue())
+			Expect(rescanRequested("other")).To(BeFalse())
+			Expect(profilesv1.RequestedRepositories(getSource("examples"))).To(ConsistOf("ssh://git@github.com/weaveworks/profiles-examples"))
+		})
+
+		When("the catalog source lists other repositories", func() {
+			BeforeEach(func() {
+				source := getSource("examples")
+				source.Spec.Repos = append(source.Spec.Repos, profilesv1.Repository{URL: "https://github.com/weaveworks/other"})
+				Expect(k8sClient.Update(context.TODO(), source)).To(Succeed())
+			})
+
+			It("only requests a rescan of the pushed repository", func() {
+				rec := post("/hook/github", body, map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": sign(body)})
+				Expect(rec.Code).To(Equal(http.StatusAccepted))
+				Expect(profilesv1.RequestedRepositories(getSource("examples"))).To(ConsistOf("ssh://git@github.com/weaveworks/profiles-examples"))
+
+				By("adding the repositories of later pushes to the pending request")
+				otherBody := []byte(`{"url": "https://github.com/weaveworks/other", "tag": "v0.1.0"}`)
+				rec = post("/hook/generic", otherBody, map[string]string{"X-Signature": sign(otherBody)})
+				Expect(rec.Code).To(Equal(http.StatusAccepted))
+				Expect(profilesv1.RequestedRepositories(getSource("examples"))).To(ConsistOf(
+					"ssh://git@github.com/weaveworks/profiles-examples",
+					"https://github.com/weaveworks/other",
+				))
+			})
+
+			When("a rescan of all repositories is pending", func() {
+				BeforeEach(func() {
+					source := getSource("examples")
+					source.Annotations = map[string]string{meta.ReconcileRequestAnnotation: "requested"}
+					Expect(k8sClient.Update(context.TODO(), source)).To(Succeed())
+				})
+
+				It("keeps requesting a rescan of all repositories", func() {
+					rec := post("/hook/github", body, map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": sign(body)})
+					Expect(rec.Code).To(Equal(http.StatusAccepted))
+					source := getSource("examples")
+					Expect(source.Annotations[meta.ReconcileRequestAnnotation]).NotTo(Equal("requested"))
+					Expect(profilesv1.RequestedRepositories(source)).To(BeNil())
+				})
+			})
+		})
+
+		When("the signature is invalid", func() {
+			It("rejects the payload", func() {
+				rec := post("/hook/github", body, map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": "sha256=1234"})
+				Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+				Expect(rescanRequested("examples")).To(BeFalse())
+
+				rec = post("/hook/github", body, map[string]string{"X-GitHub-Event": "push"})
+				Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+			})
+		})
+	})
+
+	When("a branch is pushed to github", func() {
+		It("ignores the event", func() {
+			body := []byte(`{"ref": "refs/heads/main", "repository": {"clone_url": "https://github.com/weaveworks/profiles-examples.git"}}`)
+			rec := post("/hook/github", body, map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": sign(body)})
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rescanRequested("examples")).To(BeFalse())
+		})
+	})
+
+	When("a tag is pushed to gitlab", func() {
+		body := []byte(`{
+			"ref": "refs/tags/v0.1.0",
+			"project": {
+				"git_http_url": "https://github.com/weaveworks/other.git",
+				"git_ssh_url": "git@github.com:weaveworks/other.git"
+			}
+		}`)
+
+		It("requests a rescan of the matching catalog sources", func() {
+			rec := post("/hook/gitlab", body, map[string]string{"X-Gitlab-Event": "Tag Push Hook", "X-Gitlab-Token": "secret"})
+			Expect(rec.Code).To(Equal(http.StatusAccepted))
+			Expect(rescanRequested("other")).To(BeTrue())
+			Expect(rescanRequested("examples")).To(BeFalse())
+		})
+
+		When("the token is invalid", func() {
+			It("rejects the payload", func() {
+				rec := post("/hook/gitlab", body, map[string]string{"X-Gitlab-Event": "Tag Push Hook", "X-Gitlab-Token": "wrong"})
+				Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+				Expect(rescanRequested("other")).To(BeFalse())
+			})
+		})
+	})
+
+	When("a generic payload is received", func() {
+		It("requests a rescan of the matching catalog sources", func() {
+			body := []byte(`{"url": "git@github.com:weaveworks/profiles-examples", "tag": "v0.1.0"}`)
+			rec := post("/hook/generic", body, map[string]string{"X-Signature": sign(body)})
+			Expect(rec.Code).To(Equal(http.StatusAccepted))
+			Expect(rescanRequested("examples")).To(BeTrue())
+		})
+
+		When("the payload is invalid", func() {
+			It("returns bad request", func() {
+				body := []byte(`{"tag": "v0.1.0"}`)
+				rec := post("/hook/generic", body, map[string]string{"X-Signature": sign(body)})
+				Expect(rec.Code).To(Equal(http.StatusBadRequest))
+			})
+		})
+	})
+})
@@ -0,0 +1,60 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-logr/logr"
+	"golang.org/x/sync/errgroup"
+)
+
+const timeout = 10 * time.Second
+
+// Server contains details for the webhook receiver server.
+type Server struct {
+	logger   logr.Logger
+	server   *http.Server
+	addr     string
+	receiver *Receiver
+}
+
+// NewServer creates a new webhook receiver server.
+func NewServer(logger logr.Logger, addr string, receiver *Receiver) *Server {
+	logger = logger.WithName("webhook-server")
+	return &Server{
+		logger:   logger,
+		addr:     addr,
+		receiver: receiver,
+	}
+}
+
+// Start starts the webhook receiver server.
+func (s *Server) Start(ctx context.Context) error {
+	s.logger.Info(fmt.Sprintf("starting webhook receiver server at %s", s.addr))
+	server := &http.Server{Addr: s.addr, Handler: s.receiver.Handler()}
+
+	g, _ := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		// ignore server is closing error because the server receives that on graceful shutdown.
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error(err, "unable to start webhook receiver server")
+			return err
+		}
+		return nil
+	})
+	s.server = server
+	return g.Wait()
+}
+
+// Stop does a graceful shutdown of the server using a timeout of 10 seconds.
+func (s *Server) Stop() {
+	serverTimeoutContext, timeout := context.WithTimeout(context.Background(), timeout)
+	defer timeout()
+	if err := s.server.Shutdown(serverTimeoutContext); err != nil {
+		s.logger.Error(err, "Failed to gracefully shutdown server... terminating.")
+	}
+	s.logger.Info("server stopped")
+}